@@ -0,0 +1,53 @@
+// Package bookings embeds the templates, static files and migrations into the binary so that it
+// can be started from any directory, e.g. in a container or by systemd
+package bookings
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+//go:embed templates static migrations
+var files embed.FS
+
+// Assets holds the file systems the application reads its templates, static files and
+// migrations from
+type Assets struct {
+	Templates  fs.FS
+	Static     fs.FS
+	Migrations fs.FS
+}
+
+// Embedded returns the assets compiled into the binary
+func Embedded() Assets {
+
+	return Assets{
+		Templates:  mustSub("templates"),
+		Static:     mustSub("static"),
+		Migrations: mustSub("migrations"),
+	}
+}
+
+// Dir returns the assets read from disk below root. Used in development so that changes to
+// templates and static files show up without rebuilding the binary
+func Dir(root string) Assets {
+
+	return Assets{
+		Templates:  os.DirFS(filepath.Join(root, "templates")),
+		Static:     os.DirFS(filepath.Join(root, "static")),
+		Migrations: os.DirFS(filepath.Join(root, "migrations")),
+	}
+}
+
+// mustSub returns the embedded sub directory. The directories are fixed by the go:embed
+// directive, so an error here is a programming error
+func mustSub(dir string) fs.FS {
+
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
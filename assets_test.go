@@ -0,0 +1,52 @@
+package bookings
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/prayagsingh/bookings/internal/assets"
+)
+
+func TestEmbedded(t *testing.T) {
+
+	assets := Embedded()
+
+	var tests = []struct {
+		name string
+		fsys fs.FS
+		file string
+	}{
+		{"templates", assets.Templates, "base.layout.html"},
+		{"static", assets.Static, "css/style.css"},
+		{"migrations", assets.Migrations, "20210906162026_seed_rooms_table.up.sql"},
+	}
+
+	for _, e := range tests {
+		if _, err := fs.Stat(e.fsys, e.file); err != nil {
+			t.Errorf("%s: expected %s to be embedded: %s", e.name, e.file, err)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+
+	assets := Dir(".")
+
+	if _, err := fs.Stat(assets.Templates, "home.page.html"); err != nil {
+		t.Error("expected to read home.page.html from disk", err)
+	}
+}
+
+// the binary refuses to start without the vendored libraries, see "bookings assets vendor"
+func TestVendored(t *testing.T) {
+
+	manifest, err := assets.New(Embedded().Static)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if missing := manifest.Missing(); len(missing) > 0 {
+		t.Errorf("expected every vendored library to be embedded, missing: %s", strings.Join(missing, ", "))
+	}
+}
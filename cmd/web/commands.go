@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/assets"
+	"github.com/prayagsingh/bookings/internal/importer"
+	"github.com/prayagsingh/bookings/internal/migrate"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+	"github.com/prayagsingh/bookings/internal/seed"
+)
+
+// commands run instead of the web server when the first argument names one, e.g.
+// "bookings migrate up". Each one parses its own flags from args
+var commands = map[string]func(args []string) error{
+	"migrate": migrateCommand,
+	"seed":    seedCommand,
+	"assets":  assetsCommand,
+	"import":  importCommand,
+}
+
+const migrateUsage = `usage: bookings migrate [flags] up|down|status|redo
+
+  up      apply all pending migrations
+  down    roll back the last applied migration, or -steps of them
+  status  list the migrations and whether they have been applied
+  redo    roll back the last applied migration and apply it again
+
+flags:
+`
+
+// migrateCommand applies or rolls back the migrations embedded in the binary
+func migrateCommand(args []string) error {
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	base := addBaseFlags(fs)
+	steps := fs.Int("steps", 1, "number of migrations to roll back with down")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("migrate needs exactly one of up, down, status or redo")
+	}
+
+	files, err := base.setup()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := base.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db.SQL, files.Migrations)
+	if err != nil {
+		return err
+	}
+	migrator.Logger = app.Logger
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		app.Logger.Info("migrations are up to date", "applied", len(applied))
+
+	case "down":
+		rolledBack, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		app.Logger.Info("migrations rolled back", "count", len(rolledBack))
+
+	case "redo":
+		_, err := migrator.Redo(ctx)
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return printStatus(os.Stdout, status)
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", fs.Arg(0))
+	}
+
+	return nil
+}
+
+// printStatus writes one line per migration
+func printStatus(w io.Writer, status []migrate.Status) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+
+	for _, s := range status {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state = "applied"
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if s.Modified {
+			state = "modified"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+
+	return tw.Flush()
+}
+
+// seedCommand fills the database with demo data, see package seed
+func seedCommand(args []string) error {
+
+	opts := seed.DefaultOptions()
+
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	base := addBaseFlags(fs)
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "seed of the random generator, the same seed gives the same data")
+	fs.IntVar(&opts.PropertyID, "property", opts.PropertyID, "id of the property to create rooms and reservations for")
+	fs.IntVar(&opts.Rooms, "rooms", opts.Rooms, "number of rooms to create")
+	fs.IntVar(&opts.Days, "days", opts.Days, "number of days of reservations")
+	start := fs.String("start", opts.Start.Format("2006-01-02"), "first day of reservations")
+	fs.StringVar(&opts.Password, "password", opts.Password, "password of the generated staff users")
+	reset := fs.Bool("reset", false, "delete the rooms of the property along with their reservations first")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	opts.Start, err = time.Parse("2006-01-02", *start)
+	if err != nil {
+		return fmt.Errorf("invalid -start: %w", err)
+	}
+
+	_, err = base.setup()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := base.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	data := seed.Generate(opts)
+	err = seed.Apply(ctx, db.SQL, opts.PropertyID, data, *reset)
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Info("seeded demo data", "rooms", len(data.Rooms), "users", len(data.Users),
+		"reservations", len(data.Reservations), "owner_blocks", len(data.Blocks))
+	return nil
+}
+
+const assetsUsage = `usage: bookings assets [flags] vendor|compress
+
+  vendor    download the front-end libraries into static/vendor and compress the static files
+  compress  write gzip, and brotli if the brotli command is installed, variants of the static files
+
+Rebuild the binary afterwards to embed the files.
+
+flags:
+`
+
+// assetsCommand vendors the front-end libraries and precompresses the static files below the
+// -assets directory, the repository root by default
+func assetsCommand(args []string) error {
+
+	fs := flag.NewFlagSet("assets", flag.ContinueOnError)
+	base := addBaseFlags(fs)
+	timeout := fs.Duration("timeout", time.Minute, "timeout of the downloads")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), assetsUsage)
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("assets needs exactly one of vendor or compress")
+	}
+
+	_, err = base.setup()
+	if err != nil {
+		return err
+	}
+
+	root := base.assetsDir
+	if root == "" {
+		root = "."
+	}
+	staticDir := filepath.Join(root, "static")
+
+	switch fs.Arg(0) {
+	case "vendor":
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+
+		// every library is tried so that one run reports all the hashes left to pin
+		var errs []error
+		for _, lib := range assets.Vendor {
+			err := assets.Download(ctx, http.DefaultClient, staticDir, lib)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			app.Logger.Info("vendored library", "path", lib.Path, "url", lib.URL)
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+
+	case "compress":
+		// compressed below
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown assets command %q", fs.Arg(0))
+	}
+
+	compressed, brotli, err := assets.CompressDir(staticDir)
+	if err != nil {
+		return err
+	}
+	if !brotli {
+		app.Logger.Warn("brotli is not installed, only gzip variants were written")
+	}
+	app.Logger.Info("compressed static files", "count", len(compressed))
+
+	return nil
+}
+
+const importUsage = `usage: bookings import [flags] file.csv
+
+Imports reservations and owner blocks from a CSV file into a property. Without -commit it is a
+dry run which lists the rows that can't be imported and saves nothing. With -commit the valid rows
+are imported in one transaction and the others are skipped.
+
+flags:
+`
+
+// importCommand imports a CSV file of bookings, see package importer
+func importCommand(args []string) error {
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	base := addBaseFlags(fs)
+	propertyID := fs.Int("property", 1, "id of the property to import the bookings into")
+	commit := fs.Bool("commit", false, "import the valid rows instead of a dry run")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), importUsage)
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import needs exactly one file")
+	}
+
+	_, err = base.setup()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	db, err := base.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo := dbrepo.NewPostgresRepo(db, &app)
+	actor := models.Actor{Type: models.ActorSystem, Label: "import"}
+
+	result, err := importer.Import(repo, actor, *propertyID, f, *commit)
+	if err != nil {
+		return err
+	}
+
+	if err := printImportErrors(os.Stdout, result.Errors); err != nil {
+		return err
+	}
+
+	msg := "dry run, nothing has been imported"
+	if result.Committed {
+		msg = "imported bookings"
+	}
+	app.Logger.Info(msg, "file", fs.Arg(0), "rows", result.Rows, "reservations", result.Reservations,
+		"owner_blocks", result.Blocks, "skipped", len(result.Errors))
+
+	return nil
+}
+
+// printImportErrors writes one line per error of an import
+func printImportErrors(w io.Writer, errs []models.ImportError) error {
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tCOLUMN\tERROR")
+
+	for _, e := range errs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", e.Line, e.Field, e.Message)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/migrate"
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+func TestMigrateCommandUsage(t *testing.T) {
+
+	// no database is needed to reject a missing or unknown sub command
+	for _, args := range [][]string{{}, {"up", "down"}} {
+		err := migrateCommand(args)
+		if err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestAssetsCommandUsage(t *testing.T) {
+
+	for _, args := range [][]string{{}, {"minify"}} {
+		err := assetsCommand(args)
+		if err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestImportCommandUsage(t *testing.T) {
+
+	// no database is needed to reject a missing file
+	for _, args := range [][]string{{}, {"a.csv", "b.csv"}} {
+		err := importCommand(args)
+		if err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestPrintImportErrors(t *testing.T) {
+
+	var buf bytes.Buffer
+	err := printImportErrors(&buf, []models.ImportError{
+		{Line: 2, Field: "email", Message: "Invalid email address"},
+		{Line: 5, Message: "overlaps line 3 in the same room"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 lines but got:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "2 ") || !strings.Contains(lines[1], "Invalid email address") {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+
+	status := []migrate.Status{
+		{Migration: migrate.Migration{Version: "20210902115801", Name: "create_user_table"}, Applied: true, AppliedAt: time.Date(2021, 9, 2, 12, 0, 0, 0, time.UTC)},
+		{Migration: migrate.Migration{Version: "20210902123732", Name: "create_reservation_table"}, Applied: true, Modified: true},
+		{Migration: migrate.Migration{Version: "20210902133052", Name: "create_rooms_table"}},
+	}
+
+	var buf bytes.Buffer
+	err := printStatus(&buf, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 lines but got:\n%s", buf.String())
+	}
+
+	for i, state := range []string{"applied", "modified", "pending"} {
+		if !strings.Contains(lines[i+1], state) {
+			t.Errorf("expected line %d to be %s: %s", i+1, state, lines[i+1])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prayagsingh/bookings"
+	"github.com/prayagsingh/bookings/internal/driver"
+	"github.com/prayagsingh/bookings/internal/logging"
+	"github.com/prayagsingh/bookings/internal/ratelimit"
+)
+
+// baseFlags are the flags shared by the web server and the commands
+type baseFlags struct {
+	assetsDir string
+	logFormat string
+	logLevel  string
+
+	// database connection pool, see driver.Config
+	db driver.Config
+}
+
+// addBaseFlags registers the shared flags on fs
+func addBaseFlags(fs *flag.FlagSet) *baseFlags {
+
+	f := &baseFlags{db: driver.DefaultConfig("")}
+
+	// templates, static files and migrations are embedded in the binary. In development point
+	// -assets to the repository root to pick up changes without rebuilding
+	fs.StringVar(&f.assetsDir, "assets", "", "read templates, static files and migrations from this directory instead of the embedded copies")
+	fs.StringVar(&f.logFormat, "log-format", logging.FormatLogfmt, "log format: json or logfmt")
+	fs.StringVar(&f.logLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+
+	fs.StringVar(&f.db.DSN, "dsn", "host=localhost port=5432 dbname=bookings user=postgres password=postgres", "postgres connection string")
+	fs.IntVar(&f.db.MaxOpenConns, "db-max-open-conns", f.db.MaxOpenConns, "maximum number of open database connections")
+	fs.IntVar(&f.db.MaxIdleConns, "db-max-idle-conns", f.db.MaxIdleConns, "maximum number of idle database connections")
+	fs.DurationVar(&f.db.ConnMaxLifetime, "db-conn-max-lifetime", f.db.ConnMaxLifetime, "maximum time a database connection is reused")
+	fs.DurationVar(&f.db.ConnMaxIdleTime, "db-conn-max-idle-time", f.db.ConnMaxIdleTime, "maximum time a database connection stays idle")
+	fs.DurationVar(&f.db.StatementTimeout, "db-statement-timeout", 10*time.Second, "statement_timeout of every database connection, 0 to keep the server default")
+	fs.IntVar(&f.db.ConnectAttempts, "db-connect-attempts", f.db.ConnectAttempts, "number of attempts to reach the database on startup")
+
+	return f
+}
+
+// setup creates the application logger and returns the assets to use. Call it after parsing
+func (f *baseFlags) setup() (bookings.Assets, error) {
+
+	// structured logger for the whole application. Request handling code should use
+	// helpers.Logger(r) which adds the request id, method, path and user id
+	logger, err := logging.New(os.Stdout, f.logFormat, f.logLevel)
+	if err != nil {
+		return bookings.Assets{}, err
+	}
+	app.Logger = logger
+
+	if f.assetsDir != "" {
+		return bookings.Dir(f.assetsDir), nil
+	}
+	return bookings.Embedded(), nil
+}
+
+// connect connects to the primary database
+func (f *baseFlags) connect(ctx context.Context) (*driver.DB, error) {
+
+	cfg := f.db
+	cfg.Logger = app.Logger
+
+	db, err := driver.Connect(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+
+	return db, nil
+}
+
+// limitFlag is a flag.Value for a rate limit like 30/m:10
+type limitFlag struct {
+	limit *ratelimit.Limit
+}
+
+func (f limitFlag) String() string {
+
+	if f.limit == nil {
+		return ""
+	}
+	return f.limit.String()
+}
+
+func (f limitFlag) Set(s string) error {
+
+	l, err := ratelimit.ParseLimit(s)
+	if err != nil {
+		return err
+	}
+	*f.limit = l
+	return nil
+}
@@ -1,76 +1,217 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/prayagsingh/bookings/internal/assets"
 	"github.com/prayagsingh/bookings/internal/config"
 	"github.com/prayagsingh/bookings/internal/driver"
 	"github.com/prayagsingh/bookings/internal/handlers"
+	"github.com/prayagsingh/bookings/internal/health"
 	"github.com/prayagsingh/bookings/internal/helpers"
+	"github.com/prayagsingh/bookings/internal/mailer"
+	"github.com/prayagsingh/bookings/internal/metrics"
+	"github.com/prayagsingh/bookings/internal/migrate"
 	"github.com/prayagsingh/bookings/internal/models"
 	"github.com/prayagsingh/bookings/internal/render"
+	"github.com/prayagsingh/bookings/internal/sessionstore"
 )
 
 const portNumber = ":8080"
 
 // making AppConfig available to all the files under package main
 var app config.AppConfig
-var infoLog *log.Logger
-var errorLog *log.Logger
 
 // making session available to all the files under package main
 var session *scs.SessionManager
 
+// graceful shutdown settings, see shutdown
+var (
+	shutdownDelay   = flag.Duration("shutdown-delay", 5*time.Second, "time to report not ready before the server stops accepting connections")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "time to wait for in-flight requests during shutdown")
+)
+
 func main() {
 
+	// commands like "bookings migrate up" run instead of the web server
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
+
 	dbDriver, err := run()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	// close the connection once main is executed
-	defer dbDriver.SQL.Close()
+	defer dbDriver.Close()
 
-	fmt.Printf(fmt.Sprintf("Starting application on port %s\n", portNumber))
+	app.Logger.Info("starting application", "port", portNumber)
 
 	srv := http.Server{
 		Addr:    portNumber,
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
+	// serve until SIGINT or SIGTERM is received, then shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		fatal(err)
+	case <-ctx.Done():
+	}
+
+	err = shutdown(&srv)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
 
-func run() (*driver.DB, error) {
+// shutdown flips readiness to not ready and waits for shutdownDelay so that the orchestrator stops
+// sending traffic, then stops accepting connections and waits up to shutdownTimeout for the
+// in-flight requests to finish
+func shutdown(srv *http.Server) error {
+
+	app.Logger.Info("shutting down", "delay", shutdownDelay.String(), "timeout", shutdownTimeout.String())
+	health.Default.SetShuttingDown()
+	time.Sleep(*shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+
+	app.Logger.Info("server stopped")
+	return nil
+}
+
+// fatal logs the error and exits. The application logger is used once it has been set up
+func fatal(err error) {
+
+	if app.Logger != nil {
+		app.Logger.Error("exiting", "error", err.Error())
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(1)
+}
+
+// registerSessionTypes registers the types stored in the session. The session data is gob encoded,
+// so every type put into the session has to be registered
+func registerSessionTypes() {
 
-	// storing info to Session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.RoomRestriction{})
+	gob.Register(models.Property{})
+}
+
+func run() (*driver.DB, error) {
+
+	registerSessionTypes()
 
 	// set it to true when in production
 	app.InProduction = false
 
-	// initialzing logger and printing logs to terminal
-	infoLog = log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
-	// make infoLog app wide variable
-	app.InfoLog = infoLog
+	base := addBaseFlags(flag.CommandLine)
+	replicaDSNs := flag.String("replica-dsn", "", "comma separated connection strings of read replicas used for searches and reports")
+	readAfterWrite := flag.Duration("db-read-after-write", 2*time.Second, "time the reads of a client go to the primary after it wrote")
+	autoMigrate := flag.Bool("migrate", false, "apply pending migrations before serving")
+	sessionStore := flag.String("session-store", "postgres", "where sessions are kept: postgres or memory")
+	flag.Var(limitFlag{&rateLimits.Search}, "rate-limit-search", "limit of availability searches per client IP and session, e.g. 30/m:10 or off")
+	flag.Var(limitFlag{&rateLimits.Booking}, "rate-limit-booking", "limit of reservations per client IP and session")
+	// the limits and the login lockout are kept in memory, with several instances a client gets
+	// the limit of each instance it reaches
+	flag.Var(limitFlag{&rateLimits.Login}, "rate-limit-login", "limit of login attempts per client IP and session")
+	flag.BoolVar(&app.TrustProxyHeaders, "trust-proxy", false, "take the client IP from X-Forwarded-For, only behind a proxy which sets it")
+	smtp := &mailer.SMTPMailer{}
+	flag.StringVar(&smtp.Addr, "smtp-addr", "", "host:port of the SMTP server, emails are only logged if empty")
+	flag.StringVar(&smtp.Username, "smtp-user", "", "SMTP user name")
+	flag.StringVar(&smtp.Password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&smtp.From, "mail-from", "bookings@localhost", "sender address of emails")
+	flag.StringVar(&app.BaseURL, "base-url", "http://localhost"+portNumber, "scheme and host of the links sent by email")
+	secretKey := flag.String("secret-key", os.Getenv("BOOKINGS_SECRET_KEY"), "key signing the links sent by email, at least 32 characters; defaults to $BOOKINGS_SECRET_KEY")
+	flag.Parse()
+
+	files, err := base.setup()
+	if err != nil {
+		return nil, err
+	}
+	app.TemplateFS = files.Templates
+	app.StaticFS = files.Static
+	app.MigrationFS = files.Migrations
 
-	// initializng error logger
-	// Lshotfile will give the info about the error
-	errorLog = log.New(os.Stdout, "Error:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	// make errorLog app wide variable
-	app.ErrorLog = errorLog
+	// embedded static files are fingerprinted and cached by browsers. Files read from disk with
+	// -assets may change at any time, so they are served under their plain names
+	if base.assetsDir != "" {
+		app.Assets = assets.Dev(app.StaticFS)
+	} else {
+		app.Assets, err = assets.New(app.StaticFS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// pages never load libraries from a CDN. A binary without them would serve broken pages, in
+	// development the files can still be added while the server runs
+	if missing := app.Assets.Missing(); len(missing) > 0 {
+		if base.assetsDir == "" {
+			return nil, fmt.Errorf("vendored libraries are missing, run \"bookings assets vendor\" and rebuild: %s",
+				strings.Join(missing, ", "))
+		}
+		app.Logger.Warn("vendored libraries are missing, run \"bookings assets vendor\" to serve them",
+			"missing", strings.Join(missing, ","))
+	}
+
+	app.BaseURL = strings.TrimSuffix(app.BaseURL, "/")
+
+	app.Mailer = &mailer.LogMailer{Logger: app.Logger}
+	if smtp.Addr != "" {
+		app.Mailer = smtp
+	}
+
+	// without a key the links sent before a restart stop working
+	switch {
+	case len(*secretKey) >= 32:
+		app.SecretKey = []byte(*secretKey)
+	case *secretKey != "":
+		return nil, errors.New("-secret-key must be at least 32 characters long")
+	default:
+		app.SecretKey = make([]byte, 32)
+		if _, err := rand.Read(app.SecretKey); err != nil {
+			return nil, err
+		}
+		app.Logger.Warn("no -secret-key set, using a random one")
+	}
 
 	// Intializing a SessionManager
 	session = scs.New()
@@ -82,30 +223,86 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	if *sessionStore != "postgres" && *sessionStore != "memory" {
+		return nil, fmt.Errorf("unknown session store %q", *sessionStore)
+	}
+
 	// connect to DB
-	log.Println("Connection to database...")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=postgres")
+	app.Logger.Info("connecting to database")
+	db, err := base.connect(context.Background())
 	if err != nil {
-		log.Fatal("Unable to connect to DB", err)
+		return nil, err
 	}
 
 	//defer db.Close() we can't close the db connection here since it will close the conn once run func is executed
 	// hence close the db connection
-	log.Println("Successfully connected to database !!!")
+	app.Logger.Info("connected to database")
 
-	tc, err := render.CreateTemplateCache()
+	// read replicas use the pool settings of the primary and are health checked in the background
+	for _, dsn := range strings.Split(*replicaDSNs, ",") {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		replicaConfig := base.db
+		replicaConfig.DSN = dsn
+		err = db.AddReplica(context.Background(), replicaConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to add database replica: %w", err)
+		}
+	}
+	db.SetReadAfterWrite(*readAfterWrite)
+	db.StartReplicaChecks(5 * time.Second)
+
+	// sessions are kept in postgres so that bookings in progress and logins survive a restart.
+	// The in-memory store of scs is used otherwise
+	if *sessionStore == "postgres" {
+		store := sessionstore.NewPostgresStore(db.SQL, 5*time.Minute)
+		store.Logger = app.Logger
+		session.Store = store
+	}
+
+	// database/sql pool statistics are reported on /metrics
+	metrics.RegisterDBStats(db.SQL)
+
+	migrator, err := migrate.New(db.SQL, app.MigrationFS)
 	if err != nil {
-		log.Println(err)
-		log.Fatal("can't create template cache")
 		return nil, err
 	}
+	migrator.Logger = app.Logger
+
+	if *autoMigrate {
+		_, err = migrator.Up(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("can't apply migrations: %w", err)
+		}
+	}
+
+	// readiness checks reported on /readyz
+	health.Default.Register("database", db.Ping)
+	health.Default.Register("templates", func(ctx context.Context) error {
+		return render.Ready()
+	})
+	health.Default.Register("migrations", func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d pending migrations, first is %s", len(pending), pending[0].Version)
+		}
+		return nil
+	})
+
+	tc, err := render.CreateTemplateCacheFS(app.TemplateFS)
+	if err != nil {
+		return nil, fmt.Errorf("can't create template cache: %w", err)
+	}
 
 	app.TemplateCache = tc
-	// set to false if you want to load template from disk in development mode
-	// if it is set to true then any changes made to templates won't reflect dynamically because
-	// it is reading from template cache instead of disk. it is faster in loading when comparing it
-	// to reading it from disk
-	app.UseCache = false
+	// embedded templates never change, so they are parsed once. Templates read from disk with
+	// -assets are parsed again whenever a file below templates/ changes, see render.StartWatcher
+	app.UseCache = base.assetsDir == ""
 
 	// This allow Handler functions to have access to appConfig via repository
 	repo := handlers.NewRepo(&app, db)
@@ -114,5 +311,10 @@ func run() (*driver.DB, error) {
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
+	// with -assets the template cache is rebuilt only when a file below templates/ changes
+	if base.assetsDir != "" {
+		render.StartWatcher(time.Second)
+	}
+
 	return db, nil
 }
@@ -1,9 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/justinas/nosurf"
+	"github.com/prayagsingh/bookings/internal/driver"
+	"github.com/prayagsingh/bookings/internal/handlers"
+	"github.com/prayagsingh/bookings/internal/helpers"
+	"github.com/prayagsingh/bookings/internal/metrics"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/ratelimit"
+	"github.com/prayagsingh/bookings/internal/rbac"
+	"github.com/prayagsingh/bookings/internal/requestctx"
+	"github.com/prayagsingh/bookings/internal/tokens"
 )
 
 // NoSurf add CSRF protection to all the POST requests
@@ -19,10 +41,442 @@ func NoSurf(next http.Handler) http.Handler {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	// browsers send violation reports without a csrf token
+	csrfHandler.ExemptPath(cspReportPath)
+
 	return csrfHandler
 }
 
+// cspReportPath receives the Content Security Policy violation reports
+const cspReportPath = "/csp-report"
+
+// contentSecurityPolicy is the policy of every page. %[1]s is replaced by the nonce of the
+// response, only inline scripts carrying it are run. The front-end libraries are vendored into the
+// static files, see assets.Vendor, so no other origin is allowed
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-%[1]s'; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"font-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'; " +
+	"report-uri " + cspReportPath + "; " +
+	"report-to csp-endpoint"
+
+// newCSPNonce returns a random 16 byte nonce, base64url encoded so templates need not escape it
+func newCSPNonce() string {
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// SecureHeaders sets the Content Security Policy with a nonce per request, which is stored in the
+// request context for the templates, and the headers which keep browsers from sniffing content
+// types, leaking the URL in the referrer, using powerful features and framing the site. HSTS is
+// only sent in production, where the site is served over https
+func SecureHeaders(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		nonce := newCSPNonce()
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", fmt.Sprintf(contentSecurityPolicy, nonce))
+		h.Set("Reporting-Endpoints", `csp-endpoint="`+cspReportPath+`"`)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+		if app.InProduction {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+
+		ctx := requestctx.WithCSPNonce(r.Context(), nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+// lastWriteKey is the session key of the time of the last database write of the client, in
+// nanoseconds since the epoch
+const lastWriteKey = "last_write"
+
+// ReadYourWrites keeps the time of the last database write of a client in its session and puts
+// it into the request context, so that the client reads from the primary for a while after it
+// wrote, see driver.DB.SetReadAfterWrite. Must run after SessionLoad
+func ReadYourWrites(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var lastWrite time.Time
+		if n, ok := session.Get(r.Context(), lastWriteKey).(int64); ok && n != 0 {
+			lastWrite = time.Unix(0, n)
+		}
+
+		client := driver.NewClient(lastWrite)
+		next.ServeHTTP(w, r.WithContext(driver.WithClient(r.Context(), client)))
+
+		// the session is saved after the handler returned, see SessionLoad
+		if t := client.LastWrite(); !t.Equal(lastWrite) {
+			session.Put(r.Context(), lastWriteKey, t.UnixNano())
+		}
+	})
+}
+
+// PropertyLoad resolves the property a request is served for and stores it in the request context.
+// A property is matched by its hostname first, then by a /p/{slug} path prefix which is stripped
+// before routing. The property picked through a path prefix is remembered in the session so that
+// the absolute links in the templates stay on that property. Unknown properties are answered with
+// 404, database errors with 500. Must run after SessionLoad
+func PropertyLoad(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// static files are shared by all the properties
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		db := handlers.Repo.DB
+
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		// only an unknown hostname falls back to the path prefix, a failing database must not
+		// serve the request for another property
+		property, err := db.GetPropertyByHostname(host)
+		if errors.Is(err, sql.ErrNoRows) {
+			property, r, err = propertyFromPath(r)
+			if errors.Is(err, sql.ErrNoRows) {
+				helpers.ClientError(w, r, http.StatusNotFound)
+				return
+			}
+		}
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		if property.ID == 0 {
+			id := session.GetInt(r.Context(), "property_id")
+			if id == 0 {
+				id = handlers.DefaultPropertyID
+			}
+
+			property, err = db.GetPropertyByID(id)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+		}
+
+		ctx := requestctx.WithProperty(r.Context(), property)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// propertyFromPath resolves a property from the /p/{slug} path prefix and returns the request with
+// the prefix removed. A zero property is returned if the path has no prefix
+func propertyFromPath(r *http.Request) (models.Property, *http.Request, error) {
+
+	if !strings.HasPrefix(r.URL.Path, "/p/") {
+		return models.Property{}, r, nil
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/p/")
+	slug := rest
+	path := "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		slug = rest[:i]
+		path = rest[i:]
+	}
+
+	property, err := handlers.Repo.DB.GetPropertyBySlug(slug)
+	if err != nil {
+		return property, r, err
+	}
+
+	session.Put(r.Context(), "property_id", property.ID)
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = path
+	// a mounted chi router routes on RoutePath instead of the URL path
+	if rctx := chi.RouteContext(r2.Context()); rctx != nil {
+		rctx.RoutePath = path
+	}
+	r2.URL.RawPath = ""
+	r2.RequestURI = strings.Replace(r.RequestURI, "/p/"+slug, "", 1)
+	if r2.RequestURI == "" || r2.RequestURI[0] != '/' {
+		r2.RequestURI = "/" + r2.RequestURI
+	}
+
+	return property, r2, nil
+}
+
+// Auth only allows logged in staff users through, everybody else is sent to the login page. The
+// user is loaded on every request and stored in the request context, so that a changed access level
+// applies at once
+func Auth(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !helpers.IsAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		user, err := handlers.Repo.DB.GetUserByID(session.GetInt(r.Context(), "user_id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			// the user has been deleted since logging in
+			_ = session.Destroy(r.Context())
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		// the password has been reset since logging in
+		if session.GetInt(r.Context(), "session_version") != user.SessionVersion {
+			_ = session.Destroy(r.Context())
+			_ = session.RenewToken(r.Context())
+			session.Put(r.Context(), "error", "Your session has expired, please log in again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		ctx := requestctx.WithUser(r.Context(), user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireTwoFactor sends staff users who must log in with a second factor, but haven't set one up
+// yet, to the setup page. Must run after Auth
+func RequireTwoFactor(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if u, ok := requestctx.User(r.Context()); ok && u.TwoFactorRequired && !u.TwoFactorEnabled {
+			session.Put(r.Context(), "warning", "Set up two-factor authentication to continue")
+			http.Redirect(w, r, "/user/two-factor/setup", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GuestAuth only lets logged in guests through, the others are sent to the guest login
+func GuestAuth(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if helpers.GuestID(r) == 0 {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/guest/login", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission only lets staff users with the permission through, the others get a 403.
+// Must run after Auth
+func RequirePermission(p rbac.Permission) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if !helpers.Can(r, p) {
+				helpers.Logger(r).Warn("permission denied", "permission", string(p))
+				helpers.ClientError(w, r, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder remembers the status code and the number of bytes written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController the underlying writer, so that streamed responses like
+// the CSV exports can flush through the recorder
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// requestIDPattern limits the ids accepted from the X-Request-ID header of the client or proxy
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestID returns a random 16 byte hex encoded id
+func newRequestID() string {
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogKey is the context key of the requestLog of a request
+type requestLogKey struct{}
+
+// requestLog holds the logger of a request. SessionLogger adds the attributes known once the
+// session is loaded, RequestLogger logs the request with them when it has been served
+type requestLog struct {
+	logger *slog.Logger
+}
+
+// RequestLogger assigns every request an id, taken from the X-Request-ID header when a proxy set
+// one, and stores a logger in the request context which adds the request id, method, path and the
+// session to every record. The session is identified by a hash of its cookie so that the token
+// itself never ends up in the logs. Once the request is served it is logged with its status and
+// duration. Runs before NoSurf and SessionLoad so that their rejections are logged as well
+func RequestLogger(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		attrs := []interface{}{"request_id", id, "method", r.Method, "path", r.URL.Path}
+		if c, err := r.Cookie(session.Cookie.Name); err == nil && c.Value != "" {
+			attrs = append(attrs, "session", tokens.Hash(c.Value)[:16])
+		}
+		rl := &requestLog{logger: app.Logger.With(attrs...)}
+
+		ctx := requestctx.WithLogger(r.Context(), rl.logger)
+		ctx = requestctx.WithRequestID(ctx, id)
+		ctx = context.WithValue(ctx, requestLogKey{}, rl)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		rl.logger.Info("request",
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+		)
+	})
+}
+
+// SessionLogger adds the id of the logged in user to the logger of the request, see
+// RequestLogger. Must run after SessionLoad
+func SessionLogger(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		rl, ok := r.Context().Value(requestLogKey{}).(*requestLog)
+		if userID := session.GetInt(r.Context(), "user_id"); ok && userID != 0 {
+			rl.logger = rl.logger.With("user_id", userID)
+			r = r.WithContext(requestctx.WithLogger(r.Context(), rl.logger))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Metrics records the count and latency of every request per chi route pattern. The pattern is
+// used instead of the path so that ids in the URL don't create a new series per request
+func Metrics(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		// only the catch-all mount of the site matched, i.e. the route was not found
+		if route == "/*" {
+			route = ""
+		}
+
+		metrics.ObserveHTTP(r.Method, route, rec.status, time.Since(start))
+	})
+}
+
+// rateLimitConfig holds the limits of the rate limited route groups
+type rateLimitConfig struct {
+	Search  ratelimit.Limit
+	Booking ratelimit.Limit
+	Login   ratelimit.Limit
+	// CSPReport keeps a misbehaving page or client from flooding the logs with reports
+	CSPReport ratelimit.Limit
+}
+
+// rateLimits are the limits used by siteRoutes. They are set from flags in run() and enforced by
+// every instance on its own
+var rateLimits = rateLimitConfig{
+	Search:    ratelimit.Limit{Rate: 30.0 / 60, Burst: 10},
+	Booking:   ratelimit.Limit{Rate: 10.0 / 60, Burst: 5},
+	Login:     ratelimit.Limit{Rate: 10.0 / 60, Burst: 5},
+	CSPReport: ratelimit.Limit{Rate: 60.0 / 60, Burst: 20},
+}
+
+// RateLimit limits the requests to the routes it wraps per client IP and per session, with a token
+// bucket each. Use one RateLimit per route group so that the routes of a group share their
+// buckets. Limited requests get a 429 with Retry-After. Must run after SessionLoad
+func RateLimit(limit ratelimit.Limit) func(http.Handler) http.Handler {
+
+	limiter := ratelimit.NewLimiter(limit)
+
+	return func(next http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			keys := []string{"ip:" + helpers.ClientIP(r)}
+			// the session cookie holds the token; clients without one are limited by IP only
+			if c, err := r.Cookie(session.Cookie.Name); err == nil && c.Value != "" {
+				keys = append(keys, "session:"+c.Value)
+			}
+
+			for _, key := range keys {
+				if ok, wait := limiter.Allow(key); !ok {
+					helpers.TooManyRequests(w, r, wait)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
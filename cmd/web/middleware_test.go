@@ -1,9 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/prayagsingh/bookings/internal/driver"
+	"github.com/prayagsingh/bookings/internal/handlers"
+	"github.com/prayagsingh/bookings/internal/helpers"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/ratelimit"
+	"github.com/prayagsingh/bookings/internal/rbac"
+	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+	"github.com/prayagsingh/bookings/internal/requestctx"
+	"github.com/prayagsingh/bookings/internal/tokens"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -37,3 +55,375 @@ func TestSession(t *testing.T) {
 	}
 
 }
+
+var propertyLoadTests = []struct {
+	name               string
+	host               string
+	path               string
+	expectedStatusCode int
+	expectedPropertyID int
+	expectedPath       string
+}{
+	{"hostname", "aisa-fort.test:8080", "/rooms", http.StatusOK, 1, "/rooms"},
+	{"path-prefix", "unknown.test", "/p/aisa-fort/rooms", http.StatusOK, 1, "/rooms"},
+	{"unknown-slug", "unknown.test", "/p/nowhere/rooms", http.StatusNotFound, 0, ""},
+	{"default", "unknown.test", "/rooms", http.StatusOK, 1, "/rooms"},
+	// a failing lookup must not fall back to the path prefix
+	{"database-down", dbrepo.TestUnreachableHostname, "/p/aisa-fort/rooms", http.StatusInternalServerError, 0, ""},
+	{"static-files", dbrepo.TestUnreachableHostname, "/static/css/style.css", http.StatusOK, 0, "/static/css/style.css"},
+}
+
+func TestPropertyLoad(t *testing.T) {
+
+	if session == nil {
+		session = scs.New()
+	}
+	app.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	helpers.NewHelpers(&app)
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+
+	var property models.Property
+	var path string
+	h := PropertyLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		property, _ = requestctx.Property(r.Context())
+		path = r.URL.Path
+	}))
+
+	for _, e := range propertyLoadTests {
+		property, path = models.Property{}, ""
+
+		req := httptest.NewRequest("GET", e.path, nil)
+		req.Host = e.host
+		req.Header.Set("Accept", "application/json")
+		ctx, err := session.Load(req.Context(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if property.ID != e.expectedPropertyID {
+			t.Errorf("failed %s: expected property %d in the context but got %d", e.name, e.expectedPropertyID, property.ID)
+		}
+		if path != e.expectedPath {
+			t.Errorf("failed %s: expected the path %q but got %q", e.name, e.expectedPath, path)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+
+	var myhandler myHandler
+
+	h := Auth(&myhandler)
+
+	switch v := h.(type) {
+
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http Handler but is %T", v))
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+
+	var myhandler myHandler
+
+	h := RequestLogger(&myhandler)
+
+	switch v := h.(type) {
+
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http Handler but is %T", v))
+	}
+}
+
+func TestRequestLoggerAttributes(t *testing.T) {
+
+	if session == nil {
+		session = scs.New()
+	}
+	var buf bytes.Buffer
+	app.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	defer func() { app.Logger = slog.New(slog.NewTextHandler(io.Discard, nil)) }()
+
+	ctx, err := session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Put(ctx, "user_id", 7)
+
+	var handlerLogger *slog.Logger
+	h := RequestLogger(SessionLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerLogger = requestctx.Logger(r.Context())
+	})))
+
+	req := httptest.NewRequest("GET", "/about", nil).WithContext(ctx)
+	req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: "secret-token"})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if handlerLogger == nil {
+		t.Fatal("expected a logger in the request context")
+	}
+	handlerLogger.Info("handled")
+
+	sessionAttr := "session=" + tokens.Hash("secret-token")[:16]
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		for _, attr := range []string{"request_id=", sessionAttr, "user_id=7"} {
+			if !strings.Contains(line, attr) {
+				t.Errorf("expected %s in %s", attr, line)
+			}
+		}
+		if strings.Contains(line, "secret-token") {
+			t.Errorf("expected the session token not to be logged: %s", line)
+		}
+	}
+}
+
+func TestStatusRecorderFlush(t *testing.T) {
+
+	rr := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: rr, status: http.StatusOK}
+
+	// the CSV exports flush through the recorders of RequestLogger and Metrics
+	if err := http.NewResponseController(rec).Flush(); err != nil {
+		t.Errorf("expected the recorder to flush but got %s", err)
+	}
+	if !rr.Flushed {
+		t.Error("expected the underlying writer to be flushed")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+
+	var myhandler myHandler
+
+	h := Metrics(&myhandler)
+
+	switch v := h.(type) {
+
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http Handler but is %T", v))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+
+	if session == nil {
+		session = scs.New()
+	}
+	app.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	helpers.NewHelpers(&app)
+
+	var myhandler myHandler
+	h := RateLimit(ratelimit.Limit{Rate: 1.0 / 60, Burst: 2})(&myhandler)
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/search-availability-json", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the burst is allowed
+	for i := 0; i < 2; i++ {
+		if rr := request("10.0.0.1:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 but got %d", i+1, rr.Code)
+		}
+	}
+
+	// then the client is limited with a Retry-After and a JSON error
+	rr := request("10.0.0.1:5678")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60 but got %q", rr.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rr.Body.String(), `"retry_after": 60`) {
+		t.Errorf("expected retry_after in the JSON error: %s", rr.Body.String())
+	}
+
+	// other clients are not affected
+	if rr := request("10.0.0.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("expected another client to get 200 but got %d", rr.Code)
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+
+	var nonce string
+	h := SecureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = requestctx.CSPNonce(r.Context())
+	}))
+
+	for _, inProduction := range []bool{false, true} {
+		app.InProduction = inProduction
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		if nonce == "" {
+			t.Fatal("expected a nonce in the request context")
+		}
+		csp := rr.Header().Get("Content-Security-Policy")
+		if !strings.Contains(csp, "'nonce-"+nonce+"'") {
+			t.Errorf("expected the nonce %s in the policy: %s", nonce, csp)
+		}
+		if !strings.Contains(csp, "report-uri "+cspReportPath) {
+			t.Errorf("expected a report-uri in the policy: %s", csp)
+		}
+
+		for _, header := range []string{"X-Content-Type-Options", "X-Frame-Options", "Referrer-Policy", "Permissions-Policy"} {
+			if rr.Header().Get(header) == "" {
+				t.Errorf("expected the %s header", header)
+			}
+		}
+
+		// HSTS only in production
+		if hsts := rr.Header().Get("Strict-Transport-Security"); (hsts != "") != inProduction {
+			t.Errorf("unexpected Strict-Transport-Security %q with InProduction %v", hsts, inProduction)
+		}
+	}
+	app.InProduction = false
+
+	// every request gets its own nonce
+	first := nonce
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if nonce == first {
+		t.Error("expected a new nonce for every request")
+	}
+}
+
+var requirePermissionTests = []struct {
+	name               string
+	user               *models.User
+	expectedStatusCode int
+}{
+	{"admin", &models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}, http.StatusOK},
+	{"manager", &models.User{ID: 3, AccessLevel: models.AccessLevelManager}, http.StatusOK},
+	{"front-desk", &models.User{ID: 2, AccessLevel: models.AccessLevelFrontDesk}, http.StatusForbidden},
+	{"no-user", nil, http.StatusForbidden},
+}
+
+func TestRequirePermission(t *testing.T) {
+
+	app.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	helpers.NewHelpers(&app)
+
+	var myhandler myHandler
+	h := RequirePermission(rbac.ViewAuditLog)(&myhandler)
+
+	for _, e := range requirePermissionTests {
+		req := httptest.NewRequest("GET", "/admin/audit", nil)
+		req.Header.Set("Accept", "application/json")
+		if e.user != nil {
+			req = req.WithContext(requestctx.WithUser(req.Context(), *e.user))
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var requireTwoFactorTests = []struct {
+	name               string
+	user               models.User
+	expectedStatusCode int
+}{
+	{"not-required", models.User{ID: 1}, http.StatusOK},
+	{"required-and-enabled", models.User{ID: 3, TwoFactorRequired: true, TwoFactorEnabled: true}, http.StatusOK},
+	{"required-not-set-up", models.User{ID: 3, TwoFactorRequired: true}, http.StatusSeeOther},
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+
+	if session == nil {
+		session = scs.New()
+	}
+
+	var myhandler myHandler
+	h := RequireTwoFactor(&myhandler)
+
+	for _, e := range requireTwoFactorTests {
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, err := session.Load(req.Context(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(requestctx.WithUser(ctx, e.user))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/user/two-factor/setup" {
+			t.Errorf("failed %s: expected a redirect to the setup but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+
+	if session == nil {
+		session = scs.New()
+	}
+
+	db := &driver.DB{}
+	write := false
+	h := ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if write {
+			db.Wrote(r.Context())
+		}
+	}))
+
+	ctx, err := session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/make-reservation", nil).WithContext(ctx)
+
+	// a request without writes leaves the session alone
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if session.Status(ctx) != scs.Unmodified {
+		t.Error("expected the session to be unmodified without a write")
+	}
+
+	// a write is kept in the session of the client
+	write = true
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	n, ok := session.Get(ctx, lastWriteKey).(int64)
+	if !ok || time.Since(time.Unix(0, n)) > time.Minute {
+		t.Fatalf("expected the time of the write in the session but got %v", session.Get(ctx, lastWriteKey))
+	}
+
+	// and put into the context of its next requests
+	write = false
+	var lastWrite time.Time
+	h = ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := driver.ClientFrom(r.Context()); c != nil {
+			lastWrite = c.LastWrite()
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if lastWrite.UnixNano() != n {
+		t.Errorf("expected the last write %d in the request context but got %d", n, lastWrite.UnixNano())
+	}
+}
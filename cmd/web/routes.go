@@ -2,29 +2,72 @@ package main
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/prayagsingh/bookings/internal/assets"
 	"github.com/prayagsingh/bookings/internal/config"
 	"github.com/prayagsingh/bookings/internal/handlers"
+	"github.com/prayagsingh/bookings/internal/health"
+	"github.com/prayagsingh/bookings/internal/metrics"
+	"github.com/prayagsingh/bookings/internal/rbac"
 )
 
 func routes(app *config.AppConfig) http.Handler {
 
 	mux := chi.NewRouter()
 	mux.Use(middleware.Recoverer)
+	// request counts and latency per route pattern
+	mux.Use(Metrics)
 
+	// operational endpoints don't need a session, csrf token or property
+	mux.Method("GET", "/metrics", metrics.Handler())
+	mux.Get("/healthz", health.Default.Liveness)
+	mux.Get("/readyz", health.Default.Readiness)
+
+	mux.Mount("/", siteRoutes(app))
+
+	return mux
+}
+
+// siteRoutes are the routes of the public site and the admin area
+func siteRoutes(app *config.AppConfig) http.Handler {
+
+	mux := chi.NewRouter()
+
+	// chi wraps these handlers with the middlewares registered so far. Set them before Use
+	// because they are already called from within the middleware chain
+	mux.NotFound(handlers.Repo.NotFound)
+	mux.MethodNotAllowed(handlers.Repo.MethodNotAllowed)
+
+	// request id and structured request logging, first so that rejected requests are logged too
+	mux.Use(RequestLogger)
+	// content security policy and the other security headers
+	mux.Use(SecureHeaders)
 	// this will return BAD request if any request don't have a valid csrf token
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	// adds the logged in user to the request logger
+	mux.Use(SessionLogger)
+	// reads of a client go to the primary for a while after it wrote
+	mux.Use(ReadYourWrites)
+	// resolves the property from the hostname or the /p/{slug} path prefix
+	mux.Use(PropertyLoad)
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
 	mux.Get("/villas", handlers.Repo.Villas)
 	mux.Get("/suites", handlers.Repo.Suites)
 
+	// searches, bookings and logins are rate limited per client IP and session, each group
+	// sharing its limits
+	searchLimit := RateLimit(rateLimits.Search)
+	bookingLimit := RateLimit(rateLimits.Booking)
+	loginLimit := RateLimit(rateLimits.Login)
+
 	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.With(searchLimit).Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.With(searchLimit).Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
 	// chi router allows to pass the id in the route
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
@@ -32,12 +75,82 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservations)
-	mux.Post("/make-reservation", handlers.Repo.PostReservations)
+	mux.With(bookingLimit).Post("/make-reservation", handlers.Repo.PostReservations)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
-	// routes for static files
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+	mux.Get("/user/login", handlers.Repo.ShowLogin)
+	mux.With(loginLimit).Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.With(loginLimit).Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", handlers.Repo.ShowResetPassword)
+	mux.With(loginLimit).Post("/user/reset-password", handlers.Repo.PostResetPassword)
+
+	// second factor of staff logins. The code is asked for after the password, the setup is open
+	// to logged in staff who have yet to set one up, even if the admin area requires one
+	mux.Get("/user/two-factor", handlers.Repo.ShowTwoFactor)
+	mux.With(loginLimit).Post("/user/two-factor", handlers.Repo.PostTwoFactor)
+	mux.With(Auth).Get("/user/two-factor/setup", handlers.Repo.ShowTwoFactorSetup)
+	mux.With(Auth, loginLimit).Post("/user/two-factor/setup", handlers.Repo.PostTwoFactorSetup)
+	mux.With(Auth, loginLimit).Post("/user/two-factor/disable", handlers.Repo.PostTwoFactorDisable)
+
+	// guest accounts, separate from the staff users
+	mux.Get("/guest/register", handlers.Repo.ShowGuestRegister)
+	mux.With(loginLimit).Post("/guest/register", handlers.Repo.PostGuestRegister)
+	mux.Get("/guest/login", handlers.Repo.ShowGuestLogin)
+	mux.With(loginLimit).Post("/guest/login", handlers.Repo.PostGuestLogin)
+	mux.Get("/guest/logout", handlers.Repo.GuestLogout)
+	mux.With(GuestAuth).Get("/guest/bookings", handlers.Repo.GuestBookings)
+
+	// content security policy violations reported by browsers
+	mux.With(RateLimit(rateLimits.CSPReport)).Post(cspReportPath, handlers.Repo.CSPReport)
+
+	// admin routes are only available to logged in staff and are scoped to the properties
+	// the user has been granted. Every one of them requires a permission, see adminRoutes
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(Auth)
+		mux.Use(RequireTwoFactor)
+		for _, route := range adminRoutes() {
+			mux.With(RequirePermission(route.permission)).Method(route.method, route.pattern, route.handler)
+		}
+	})
+
+	// routes for static files. they are embedded in the binary unless -assets is set, and
+	// fingerprinted and precompressed then
+	staticAssets := app.Assets
+	if staticAssets == nil {
+		staticAssets = assets.Dev(os.DirFS("./static/"))
+	}
+	mux.Handle("/static/*", staticAssets.Handler())
 
 	return mux
 }
+
+// adminRoute is a route of the admin area and the permission it requires
+type adminRoute struct {
+	method     string
+	pattern    string
+	permission rbac.Permission
+	handler    http.HandlerFunc
+}
+
+// adminRoutes are the routes below /admin. Register admin routes here only, so that none of them
+// is left without a permission check
+func adminRoutes() []adminRoute {
+
+	return []adminRoute{
+		{"GET", "/dashboard", rbac.ViewDashboard, handlers.Repo.AdminDashboard},
+		{"GET", "/reservations-all", rbac.ViewReservations, handlers.Repo.AdminAllReservations},
+		{"GET", "/reservations-json", rbac.ViewReservations, handlers.Repo.AdminReservationsJSON},
+		{"GET", "/export/reservations.csv", rbac.ExportData, handlers.Repo.AdminExportReservations},
+		{"GET", "/export/room-restrictions.csv", rbac.ExportData, handlers.Repo.AdminExportRoomRestrictions},
+		{"GET", "/import", rbac.ImportData, handlers.Repo.AdminImport},
+		{"POST", "/import", rbac.ImportData, handlers.Repo.AdminPostImport},
+		{"GET", "/reports", rbac.ViewReports, handlers.Repo.AdminReports},
+		{"GET", "/reports.csv", rbac.ViewReports, handlers.Repo.AdminReportsCSV},
+		{"GET", "/audit", rbac.ViewAuditLog, handlers.Repo.AdminAuditLog},
+		{"POST", "/switch-property/{id}", rbac.ViewDashboard, handlers.Repo.AdminSwitchProperty},
+		{"GET", "/security", rbac.ManageSecurity, handlers.Repo.AdminSecurity},
+		{"POST", "/security", rbac.ManageSecurity, handlers.Repo.AdminPostSecurity},
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
 	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/rbac"
 )
 
 func TestRoutes(t *testing.T) {
@@ -21,4 +24,47 @@ func TestRoutes(t *testing.T) {
 	default:
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T ", v))
 	}
-}
\ No newline at end of file
+}
+func TestAdminRoutesDeclarePermissions(t *testing.T) {
+
+	var app config.AppConfig
+
+	declared := map[string]rbac.Permission{}
+	for _, route := range adminRoutes() {
+		if !rbac.Valid(route.permission) {
+			t.Errorf("%s /admin%s requires the unknown permission %q", route.method, route.pattern, route.permission)
+		}
+		declared[route.method+" /admin"+route.pattern] = route.permission
+	}
+
+	// every route registered below /admin must come from adminRoutes, which requires a permission
+	found := 0
+	err := chi.Walk(siteRoutes(&app).(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/admin/") {
+			return nil
+		}
+		found++
+		if _, ok := declared[method+" "+route]; !ok {
+			t.Errorf("%s %s doesn't declare a permission in adminRoutes", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found != len(declared) {
+		t.Errorf("expected %d admin routes but found %d", len(declared), found)
+	}
+
+	// a permission no route requires would grant nothing
+	required := map[rbac.Permission]bool{}
+	for _, p := range declared {
+		required[p] = true
+	}
+	for _, p := range rbac.All() {
+		if !required[p] {
+			t.Errorf("no admin route requires the permission %q", p)
+		}
+	}
+}
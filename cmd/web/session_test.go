@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// TestSessionTypesRoundTrip makes sure that everything put into the session survives being
+// encoded for the postgres store and decoded again, e.g. after a restart
+func TestSessionTypesRoundTrip(t *testing.T) {
+
+	registerSessionTypes()
+
+	day := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	property := models.Property{ID: 1, Name: "Aisa Fort", Slug: "aisa-fort", Currency: "USD", CreatedAt: day, UpdatedAt: day}
+	room := models.Room{ID: 2, RoomName: "Suites", PropertyID: 1, Property: property, CreatedAt: day, UpdatedAt: day}
+
+	values := map[string]interface{}{
+		"reservation": models.Reservation{
+			ID:        3,
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			RoomID:    room.ID,
+			StartDate: day,
+			EndDate:   day.AddDate(0, 0, 2),
+			Room:      room,
+		},
+		"user":               models.User{ID: 1, Email: "admin@here.com", AccessLevel: models.AccessLevelAdmin},
+		"room":               room,
+		"restriction":        models.Restriction{ID: models.RestrictionOwnerBlock, RestrictionName: "Owner Block"},
+		"room_restriction":   models.RoomRestriction{ID: 4, RoomID: room.ID, StartDate: day, EndDate: day, Room: room},
+		"property":           property,
+		"user_id":            1,
+		"session_version":    1,
+		"guest_id":           1,
+		"two_factor_user_id": 3,
+		"two_factor_started": 1634558400,
+		"totp_setup_secret":  "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+		"property_id":        1,
+		"admin_property_id":  1,
+		"flash":              "Logged in successfully",
+		"last_write":         int64(1634558400000000000),
+	}
+
+	deadline := day.Add(24 * time.Hour)
+	b, err := scs.GobCodec{}.Encode(deadline, values)
+	if err != nil {
+		t.Fatalf("can't encode session: %s", err)
+	}
+
+	gotDeadline, got, err := scs.GobCodec{}.Decode(b)
+	if err != nil {
+		t.Fatalf("can't decode session: %s", err)
+	}
+
+	if !gotDeadline.Equal(deadline) {
+		t.Errorf("expected deadline %s but got %s", deadline, gotDeadline)
+	}
+
+	for key, expected := range values {
+		if !reflect.DeepEqual(got[key], expected) {
+			t.Errorf("%s didn't round-trip: expected %#v but got %#v", key, expected, got[key])
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package assets serves the static files. Every file gets a fingerprinted name carrying a hash of
+// its content, e.g. css/style.1f2e3d4c5b6a.css, which templates get through the asset function.
+// Fingerprinted names never change their content, so they are cached by browsers for a year.
+// Compressible files are served gzip or brotli encoded, from .gz and .br files next to them when
+// they exist (see the "assets compress" command) and gzipped in memory otherwise
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix is the URL path the static files are served below
+const Prefix = "/static/"
+
+// hashLen is the number of hex digits of the content hash put into the file names
+const hashLen = 12
+
+// minCompressSize is the size below which compressing doesn't pay off
+const minCompressSize = 1024
+
+// compressible are the extensions of the text files worth compressing. Images are compressed already
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".txt":  true,
+	".html": true,
+}
+
+// encodings of the precompressed variants in order of preference
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// file is a static file and its precompressed variants
+type file struct {
+	name        string
+	hashed      string
+	hash        string
+	contentType string
+	// variants holds the encoded content by encoding, i.e. br and gzip
+	variants map[string][]byte
+}
+
+// Manifest maps the static files to their fingerprinted names and serves them
+type Manifest struct {
+	fsys fs.FS
+	dev  bool
+
+	files  map[string]*file
+	hashed map[string]*file
+}
+
+// New hashes every file in fsys, gzips the compressible ones which have no .gz file and loads
+// the precompressed variants
+func New(fsys fs.FS) (*Manifest, error) {
+
+	m := &Manifest{
+		fsys:   fsys,
+		files:  map[string]*file{},
+		hashed: map[string]*file{},
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isVariant(name) {
+			return nil
+		}
+
+		f, err := m.load(name)
+		if err != nil {
+			return fmt.Errorf("can't load static file %s: %w", name, err)
+		}
+		m.files[f.name] = f
+		m.hashed[f.hashed] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Dev returns a manifest for development. Files are read from fsys on every request and served
+// under their plain names without caching, so that changes show up on the next reload
+func Dev(fsys fs.FS) *Manifest {
+	return &Manifest{fsys: fsys, dev: true}
+}
+
+// isVariant reports whether name is a precompressed variant of another file
+func isVariant(name string) bool {
+
+	for _, e := range encodings {
+		if strings.HasSuffix(name, e.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// load hashes the file and loads or creates its compressed variants
+func (m *Manifest) load(name string) (*file, error) {
+
+	b, err := fs.ReadFile(m.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])[:hashLen]
+
+	f := &file{
+		name:        name,
+		hashed:      fingerprint(name, hash),
+		hash:        hash,
+		contentType: contentType(name),
+		variants:    map[string][]byte{},
+	}
+
+	if !compressible[path.Ext(name)] || len(b) < minCompressSize {
+		return f, nil
+	}
+
+	for _, e := range encodings {
+		v, err := fs.ReadFile(m.fsys, name+e.ext)
+		if err == nil {
+			f.variants[e.name] = v
+		}
+	}
+
+	if _, ok := f.variants["gzip"]; !ok {
+		v, err := Gzip(b)
+		if err != nil {
+			return nil, err
+		}
+		f.variants["gzip"] = v
+	}
+
+	return f, nil
+}
+
+// fingerprint inserts hash before the extension of name, e.g. js/app.js becomes js/app.<hash>.js
+func fingerprint(name, hash string) string {
+
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// contentType returns the content type by the extension of name
+func contentType(name string) string {
+
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Gzip compresses b at the best compression level
+func Gzip(b []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Path returns the URL of the static file name, e.g. /static/css/style.<hash>.css. Unknown files
+// get their plain URL
+func (m *Manifest) Path(name string) string {
+
+	name = strings.TrimPrefix(name, "/")
+
+	if m.dev {
+		return Prefix + name
+	}
+
+	if f, ok := m.files[name]; ok {
+		return Prefix + f.hashed
+	}
+	return Prefix + name
+}
+
+// Missing returns the vendored libraries which have not been downloaded into the static files,
+// see Vendor
+func (m *Manifest) Missing() []string {
+
+	var missing []string
+	for _, lib := range Vendor {
+		if _, err := fs.Stat(m.fsys, lib.Path); err != nil {
+			missing = append(missing, lib.Path)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// Handler serves the static files below Prefix. Fingerprinted names are cached for a year, plain
+// names have to be revalidated on every use
+func (m *Manifest) Handler() http.Handler {
+
+	if m.dev {
+		fileServer := http.StripPrefix(strings.TrimSuffix(Prefix, "/"), http.FileServer(http.FS(m.fsys)))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			fileServer.ServeHTTP(w, r)
+		})
+	}
+
+	return http.HandlerFunc(m.serve)
+}
+
+func (m *Manifest) serve(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+
+	f, immutable := m.hashed[name]
+	if !immutable {
+		f = m.files[name]
+	}
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("Content-Type", f.contentType)
+
+	if len(f.variants) > 0 {
+		h.Add("Vary", "Accept-Encoding")
+
+		for _, e := range encodings {
+			v, ok := f.variants[e.name]
+			if !ok || !acceptsEncoding(r.Header.Get("Accept-Encoding"), e.name) {
+				continue
+			}
+			h.Set("Content-Encoding", e.name)
+			h.Set("ETag", `"`+f.hash+"-"+e.name+`"`)
+			http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(v))
+			return
+		}
+	}
+
+	h.Set("ETag", `"`+f.hash+`"`)
+
+	content, err := m.fsys.Open(f.name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	rs, ok := content.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(content)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+
+	http.ServeContent(w, r, f.name, time.Time{}, rs)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows coding with a non-zero
+// quality. The coding is matched by name first, then through *
+func acceptsEncoding(header, coding string) bool {
+
+	star := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if strings.EqualFold(name, coding) {
+			return q > 0
+		}
+		if name == "*" {
+			star = q > 0
+		}
+	}
+
+	return star
+}
@@ -0,0 +1,270 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var appJS = []byte(strings.Repeat("console.log('hello');\n", 100))
+
+var testFS = fstest.MapFS{
+	"js/app.js":         {Data: appJS},
+	"js/app.js.br":      {Data: []byte("brotli bytes")},
+	"css/style.css":     {Data: []byte("body { margin: 0; }")},
+	"images/villas.png": {Data: []byte("\x89PNG")},
+}
+
+func TestPath(t *testing.T) {
+
+	m, err := New(testFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"css/style.css", "/static/css/style." + m.files["css/style.css"].hash + ".css"},
+		{"/js/app.js", "/static/js/app." + m.files["js/app.js"].hash + ".js"},
+		{"unknown.txt", "/static/unknown.txt"},
+		// never loaded from a CDN, even if it is missing
+		{"vendor/notie/notie.min.js", "/static/vendor/notie/notie.min.js"},
+	}
+
+	for _, e := range tests {
+		if got := m.Path(e.name); got != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+
+	if len(m.files["css/style.css"].hash) != hashLen {
+		t.Errorf("expected a hash of %d digits", hashLen)
+	}
+
+	if _, ok := m.files["js/app.js.br"]; ok {
+		t.Error("precompressed variants must not be served as files of their own")
+	}
+
+	if len(m.Missing()) != len(Vendor) {
+		t.Errorf("expected all %d vendored libraries to be missing but got %v", len(Vendor), m.Missing())
+	}
+}
+
+func TestDevPath(t *testing.T) {
+
+	m := Dev(testFS)
+
+	if got := m.Path("css/style.css"); got != "/static/css/style.css" {
+		t.Errorf("expected the plain path in development but got %s", got)
+	}
+	if got := m.Path("vendor/notie/notie.min.js"); got != "/static/vendor/notie/notie.min.js" {
+		t.Errorf("expected the static path of a missing library but got %s", got)
+	}
+}
+
+var serveTests = []struct {
+	name             string
+	path             string
+	acceptEncoding   string
+	expectedStatus   int
+	expectedCache    string
+	expectedEncoding string
+}{
+	{"fingerprinted", "/static/css/style.{css/style.css}.css", "", http.StatusOK, "public, max-age=31536000, immutable", ""},
+	{"plain-name", "/static/css/style.css", "", http.StatusOK, "no-cache", ""},
+	{"brotli-preferred", "/static/js/app.{js/app.js}.js", "gzip, deflate, br", http.StatusOK, "public, max-age=31536000, immutable", "br"},
+	{"gzip", "/static/js/app.{js/app.js}.js", "gzip", http.StatusOK, "public, max-age=31536000, immutable", "gzip"},
+	{"brotli-refused", "/static/js/app.{js/app.js}.js", "br;q=0, *", http.StatusOK, "public, max-age=31536000, immutable", "gzip"},
+	{"identity", "/static/js/app.js", "", http.StatusOK, "no-cache", ""},
+	{"wrong-hash", "/static/css/style.000000000000.css", "", http.StatusNotFound, "", ""},
+	{"variant-not-served", "/static/js/app.js.br", "", http.StatusNotFound, "", ""},
+}
+
+func TestHandler(t *testing.T) {
+
+	m, err := New(testFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler()
+
+	for _, e := range serveTests {
+		path := e.path
+		for name, f := range m.files {
+			path = strings.ReplaceAll(path, "{"+name+"}", f.hash)
+		}
+
+		req := httptest.NewRequest("GET", path, nil)
+		if e.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", e.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatus, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if cc := rr.Header().Get("Cache-Control"); cc != e.expectedCache {
+			t.Errorf("failed %s: expected Cache-Control %q but got %q", e.name, e.expectedCache, cc)
+		}
+		if ce := rr.Header().Get("Content-Encoding"); ce != e.expectedEncoding {
+			t.Errorf("failed %s: expected Content-Encoding %q but got %q", e.name, e.expectedEncoding, ce)
+		}
+	}
+}
+
+func TestHandlerGzipContent(t *testing.T) {
+
+	m, err := New(testFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", m.Path("js/app.js"), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, req)
+
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("expected a javascript content type but got %s", rr.Header().Get("Content-Type"))
+	}
+	if rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Error("expected Vary: Accept-Encoding")
+	}
+
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, appJS) {
+		t.Error("gzip content doesn't match the file")
+	}
+
+	// revalidation by ETag
+	req = httptest.NewRequest("GET", m.Path("js/app.js"), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 but got %d", rr.Code)
+	}
+}
+
+var acceptsEncodingTests = []struct {
+	header   string
+	coding   string
+	expected bool
+}{
+	{"gzip, deflate, br", "br", true},
+	{"gzip", "br", false},
+	{"GZIP", "gzip", true},
+	{"br;q=0, gzip", "br", false},
+	{"*", "br", true},
+	{"*;q=0", "gzip", false},
+	{"gzip;q=0.5", "gzip", true},
+	{"", "gzip", false},
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+
+	for _, e := range acceptsEncodingTests {
+		if got := acceptsEncoding(e.header, e.coding); got != e.expected {
+			t.Errorf("%q accepts %s: expected %v but got %v", e.header, e.coding, e.expected, got)
+		}
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+
+	// echo -n hello | openssl dgst -sha384 -binary | base64
+	const integrity = "sha384-WeF0h3dEjGnea4ANejO7+5/xtGPkQ1TDVTvNucZm+pASWjx5+QOXvfX2oT3oKGhP"
+
+	if err := CheckIntegrity([]byte("hello"), integrity); err != nil {
+		t.Error(err)
+	}
+	if err := CheckIntegrity([]byte("hello!"), integrity); err == nil {
+		t.Error("expected a mismatch")
+	}
+	if err := CheckIntegrity([]byte("hello"), "md5-xyz"); err == nil {
+		t.Error("expected an unsupported algorithm")
+	}
+	if got := Integrity([]byte("hello")); got != integrity {
+		t.Errorf("expected %s but got %s", integrity, got)
+	}
+}
+
+// pinnedVersion matches an exact version in a CDN URL, e.g. @4.3.1
+var pinnedVersion = regexp.MustCompile(`@\d+\.\d+\.\d+/`)
+
+func TestVendorPinned(t *testing.T) {
+
+	for _, lib := range Vendor {
+		if !pinnedVersion.MatchString(lib.URL) {
+			t.Errorf("%s: expected an exact version but got %s", lib.Path, lib.URL)
+		}
+		if !strings.HasPrefix(lib.Path, "vendor/") {
+			t.Errorf("%s: expected the library below vendor/", lib.Path)
+		}
+	}
+}
+
+var downloadTests = []struct {
+	name      string
+	integrity string
+	expectErr string
+}{
+	{"pinned", Integrity([]byte("library")), ""},
+	{"mismatch", Integrity([]byte("other")), "integrity mismatch"},
+	{"not-pinned", "", "pin " + Integrity([]byte("library"))},
+}
+
+func TestDownload(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("library"))
+	}))
+	defer srv.Close()
+
+	for _, e := range downloadTests {
+		dir := t.TempDir()
+		lib := Library{Path: "vendor/lib/lib.js", URL: srv.URL + "/lib.js", Integrity: e.integrity}
+
+		err := Download(context.Background(), srv.Client(), dir, lib)
+		_, statErr := os.Stat(filepath.Join(dir, "vendor", "lib", "lib.js"))
+
+		if e.expectErr == "" {
+			if err != nil || statErr != nil {
+				t.Errorf("failed %s: expected the file to be written but got %v, %v", e.name, err, statErr)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), e.expectErr) {
+			t.Errorf("failed %s: expected an error with %q but got %v", e.name, e.expectErr, err)
+		}
+		if statErr == nil {
+			t.Errorf("failed %s: expected no file to be written", e.name)
+		}
+	}
+}
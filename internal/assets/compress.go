@@ -0,0 +1,57 @@
+package assets
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+)
+
+// CompressDir writes a .gz file next to every compressible file of the static directory dir, and a
+// .br file as well if the brotli command is installed. The standard library has no brotli encoder.
+// It returns the names of the files compressed
+func CompressDir(dir string) (compressed []string, brotli bool, err error) {
+
+	brotliPath, lookErr := exec.LookPath("brotli")
+	brotli = lookErr == nil
+
+	err = fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isVariant(name) || !compressible[path.Ext(name)] {
+			return nil
+		}
+
+		src := filepath.Join(dir, filepath.FromSlash(name))
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if len(b) < minCompressSize {
+			return nil
+		}
+
+		gz, err := Gzip(b)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(src+".gz", gz, 0644); err != nil {
+			return err
+		}
+
+		if brotli {
+			out, err := exec.Command(brotliPath, "--force", "--best", "--output="+src+".br", src).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("brotli %s: %w: %s", name, err, out)
+			}
+		}
+
+		compressed = append(compressed, name)
+		return nil
+	})
+
+	return compressed, brotli, err
+}
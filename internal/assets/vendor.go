@@ -0,0 +1,147 @@
+package assets
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Library is a front-end library served from the static files instead of a third-party CDN
+type Library struct {
+	// Path is the name of the file below the static directory
+	Path string
+	// URL is the exact version the file is downloaded from, never a floating tag like @11
+	URL string
+	// Integrity is the subresource integrity hash of the file. Download refuses files without one
+	Integrity string
+}
+
+// Vendor lists the libraries used by the templates. "bookings assets vendor" downloads them into
+// the static directory, which is committed, so that pages never load code from a CDN. Libraries
+// without an integrity hash can't be downloaded until their hash has been checked and pinned here
+var Vendor = []Library{
+	{
+		Path:      "vendor/bootstrap/bootstrap.min.css",
+		URL:       "https://cdn.jsdelivr.net/npm/bootstrap@5.1.0/dist/css/bootstrap.min.css",
+		Integrity: "sha384-KyZXEAg3QhqLMpG8r+8fhAXLRk2vvoC2f3B09zVXn8CA5QIVfZOJ3BCsw2P0p/We",
+	},
+	{
+		Path:      "vendor/bootstrap/bootstrap.min.js",
+		URL:       "https://cdn.jsdelivr.net/npm/bootstrap@5.1.0/dist/js/bootstrap.min.js",
+		Integrity: "sha384-cn7l7gDp0eyniUwwAZgrzD06kc/tftFf19TOAs2zVinnD/C7E91j9yyk5//jjpt/",
+	},
+	{
+		Path:      "vendor/popper/popper.min.js",
+		URL:       "https://cdn.jsdelivr.net/npm/@popperjs/core@2.9.3/dist/umd/popper.min.js",
+		Integrity: "sha384-eMNCOe7tC1doHpGoWe/6oMVemdAVTMs2xqW4mwXrXsW0L84Iytr2wi5v2QjrP/xp",
+	},
+	{
+		Path: "vendor/vanillajs-datepicker/datepicker-bs4.min.css",
+		URL:  "https://cdn.jsdelivr.net/npm/vanillajs-datepicker@1.1.4/dist/css/datepicker-bs4.min.css",
+	},
+	{
+		Path: "vendor/vanillajs-datepicker/datepicker-full.min.js",
+		URL:  "https://cdn.jsdelivr.net/npm/vanillajs-datepicker@1.1.4/dist/js/datepicker-full.min.js",
+	},
+	{
+		Path: "vendor/notie/notie.min.css",
+		URL:  "https://cdn.jsdelivr.net/npm/notie@4.3.1/dist/notie.min.css",
+	},
+	{
+		Path: "vendor/notie/notie.min.js",
+		URL:  "https://cdn.jsdelivr.net/npm/notie@4.3.1/dist/notie.min.js",
+	},
+	{
+		Path: "vendor/sweetalert2/sweetalert2.all.min.js",
+		URL:  "https://cdn.jsdelivr.net/npm/sweetalert2@11.1.4/dist/sweetalert2.all.min.js",
+	},
+	{
+		// draws the QR code of the two-factor setup page
+		Path: "vendor/qrcode-generator/qrcode.js",
+		URL:  "https://cdn.jsdelivr.net/npm/qrcode-generator@1.4.4/qrcode.js",
+	},
+}
+
+// Download fetches lib into the static directory dir and checks its integrity hash. A library
+// without a hash isn't written, the error gives the hash of the file downloaded so that it can be
+// checked against the published one and pinned
+func Download(ctx context.Context, client *http.Client, dir string, lib Library) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lib.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't download %s: %s", lib.URL, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if lib.Integrity == "" {
+		return fmt.Errorf("%s has no integrity hash, check it and pin %s", lib.URL, Integrity(b))
+	}
+	if err := CheckIntegrity(b, lib.Integrity); err != nil {
+		return fmt.Errorf("%s: %w", lib.URL, err)
+	}
+
+	dst := filepath.Join(dir, filepath.FromSlash(lib.Path))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, b, 0644)
+}
+
+// Integrity returns the sha384 subresource integrity hash of b
+func Integrity(b []byte) string {
+
+	sum := sha512.Sum384(b)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CheckIntegrity checks b against a subresource integrity hash like sha384-<base64>
+func CheckIntegrity(b []byte, integrity string) error {
+
+	algorithm, expected, ok := strings.Cut(integrity, "-")
+	if !ok {
+		return fmt.Errorf("invalid integrity %q", integrity)
+	}
+
+	var sum []byte
+	switch algorithm {
+	case "sha256":
+		s := sha256.Sum256(b)
+		sum = s[:]
+	case "sha384":
+		s := sha512.Sum384(b)
+		sum = s[:]
+	case "sha512":
+		s := sha512.Sum512(b)
+		sum = s[:]
+	default:
+		return fmt.Errorf("unsupported integrity algorithm %q", algorithm)
+	}
+
+	if actual := base64.StdEncoding.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("integrity mismatch, expected %s but got %s-%s", integrity, algorithm, actual)
+	}
+
+	return nil
+}
@@ -2,17 +2,38 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/prayagsingh/bookings/internal/assets"
+	"github.com/prayagsingh/bookings/internal/mailer"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
+	// Logger writes structured logs. Use helpers.Logger(r) in request handling code to get
+	// a logger carrying the request attributes
+	Logger       *slog.Logger
+	InProduction bool
+	Session      *scs.SessionManager
+	// TemplateFS, StaticFS and MigrationFS are embedded in the binary unless the
+	// application is started with -assets pointing to a directory on disk
+	TemplateFS  fs.FS
+	StaticFS    fs.FS
+	MigrationFS fs.FS
+	// Assets serves StaticFS and gives the templates the fingerprinted URLs of the static files
+	Assets *assets.Manifest
+	// TrustProxyHeaders makes helpers.ClientIP use the X-Forwarded-For header. Only enable it
+	// behind a proxy which sets the header, otherwise clients can pick their own address
+	TrustProxyHeaders bool
+	// Mailer sends the emails, e.g. password reset links
+	Mailer mailer.Mailer
+	// BaseURL is the scheme and host put into links sent by email. The Host header of the request
+	// is not used for them because clients choose it
+	BaseURL string
+	// SecretKey signs the links sent by email
+	SecretKey []byte
 }
@@ -1,51 +1,141 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
 	"time"
 
 	// driver to connect to db. don't remove it
-	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 )
 
+// DB holds the database connection pool. Every call of Connect returns its own pool. SQL is the
+// primary which takes all writes, read replicas are added with AddReplica before DB is used
 type DB struct {
 	SQL *sql.DB
+
+	replicasOnce sync.Once
+	replicas     *replicaSet
 }
 
-var dbConn = &DB{}
+// Config configures the connection pool and the retries on startup
+type Config struct {
+	DSN string
 
-const maxOpenDbConn = 10
-const maxIdleDbConn = 5
-const maxDbLifeTime = 5 * time.Minute
+	// pool settings, see the corresponding setters of sql.DB. Zero means no limit
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 
-// ConnectSQL create a db connection-pool for postgres
-func ConnectSQL(dsn string) (*DB, error) {
+	// StatementTimeout is set as statement_timeout on every connection so that the database
+	// cancels queries running longer. Zero keeps the server default
+	StatementTimeout time.Duration
 
-	db, err := NewDatabase(dsn)
-	if err != nil {
-		panic(err)
+	// ConnectAttempts is the number of times the first ping is tried before giving up. The delay
+	// between attempts starts at RetryDelay and doubles up to MaxRetryDelay
+	ConnectAttempts int
+	RetryDelay      time.Duration
+	MaxRetryDelay   time.Duration
+
+	// Logger, if set, logs every failed attempt
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns the config used so far: 10 open and 5 idle connections which are recycled
+// after 5 minutes
+func DefaultConfig(dsn string) Config {
+	return Config{
+		DSN:             dsn,
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		ConnectAttempts: 5,
+		RetryDelay:      500 * time.Millisecond,
+		MaxRetryDelay:   10 * time.Second,
 	}
+}
 
-	// setting parameters to db
-	db.SetMaxOpenConns(maxOpenDbConn)
-	db.SetMaxIdleConns(maxIdleDbConn)
-	db.SetConnMaxLifetime(maxDbLifeTime)
+// ConnectSQL create a db connection-pool for postgres with the default config
+func ConnectSQL(dsn string) (*DB, error) {
+	return Connect(context.Background(), DefaultConfig(dsn))
+}
 
-	dbConn.SQL = db
+// Connect creates a db connection-pool for postgres. The database may not be up yet when the
+// application starts, hence the ping is retried with exponential backoff until it succeeds, the
+// attempts are used up or ctx is done
+func Connect(ctx context.Context, cfg Config) (*DB, error) {
 
-	err = testDb(db)
+	db, err := NewDatabase(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return dbConn, nil
+
+	attempts := cfg.ConnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = testDb(ctx, db)
+		if err == nil {
+			return &DB{SQL: db}, nil
+		}
+		if attempt >= attempts {
+			break
+		}
+
+		delay := backoff(cfg.RetryDelay, cfg.MaxRetryDelay, attempt)
+		if cfg.Logger != nil {
+			cfg.Logger.Warn("can't reach database, retrying", "attempt", attempt, "delay", delay.String(), "error", err.Error())
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("can't reach database: %w", ctx.Err())
+		}
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("can't reach database after %d attempts: %w", attempts, err)
+}
+
+// backoff returns the delay before the next attempt: base doubled for every failed attempt, but
+// never more than max
+func backoff(base, max time.Duration, attempt int) time.Duration {
+
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if max > 0 && delay >= max {
+			return max
+		}
+	}
+	if max > 0 && delay > max {
+		return max
+	}
+
+	return delay
+}
+
+// Ping checks that the database is reachable. It is used by the readiness check
+func (d *DB) Ping(ctx context.Context) error {
+	return testDb(ctx, d.SQL)
 }
 
 // tries to ping the db
-func testDb(d *sql.DB) error {
+func testDb(ctx context.Context, d *sql.DB) error {
 
-	err := d.Ping()
+	err := d.PingContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -53,17 +143,27 @@ func testDb(d *sql.DB) error {
 	return nil
 }
 
-// NewDatabase creates a new db for the application
-func NewDatabase(dsn string) (*sql.DB, error) {
+// NewDatabase creates a new db pool for the application with the pool settings of cfg. No
+// connection is made yet, see Connect
+func NewDatabase(cfg Config) (*sql.DB, error) {
 
-	db, err := sql.Open("pgx", dsn)
+	connConfig, err := pgx.ParseConfig(cfg.DSN)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid database dsn: %w", err)
 	}
 
-	if err = db.Ping(); err != nil {
-		return nil, err
+	if cfg.StatementTimeout > 0 {
+		// postgres expects milliseconds
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
 	}
 
+	db := stdlib.OpenDB(*connConfig)
+
+	// setting parameters to db
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
 	return db, nil
 }
@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+
+	var tests = []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 500 * time.Millisecond},
+		{10, 500 * time.Millisecond},
+	}
+
+	for _, e := range tests {
+		delay := backoff(100*time.Millisecond, 500*time.Millisecond, e.attempt)
+		if delay != e.expected {
+			t.Errorf("attempt %d: expected %s but got %s", e.attempt, e.expected, delay)
+		}
+	}
+}
+
+func TestNewDatabase(t *testing.T) {
+
+	// case 1: invalid dsn returns an error instead of panicking
+	_, err := NewDatabase(Config{DSN: "port=notaport"})
+	if err == nil {
+		t.Error("expected an error for an invalid dsn")
+	}
+
+	// case 2: the statement timeout doesn't need a connection
+	db, err := NewDatabase(Config{DSN: "host=localhost dbname=bookings", StatementTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestConnectRetries(t *testing.T) {
+
+	cfg := DefaultConfig("host=127.0.0.1 port=1 dbname=bookings user=postgres connect_timeout=1")
+	cfg.ConnectAttempts = 3
+	cfg.RetryDelay = time.Millisecond
+
+	// nothing listens on port 1
+	_, err := Connect(context.Background(), cfg)
+	if err == nil {
+		t.Error("expected an error when the database can't be reached")
+	}
+
+	// a cancelled context stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.RetryDelay = time.Hour
+	_, err = Connect(ctx, cfg)
+	if err == nil {
+		t.Error("expected an error when the context is cancelled")
+	}
+}
@@ -0,0 +1,222 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replica is a read-only pool. Only healthy replicas are handed out by Reader
+type replica struct {
+	sql     *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet holds the replicas of a DB, see DB.replicaSet
+type replicaSet struct {
+	mu       sync.RWMutex
+	replicas []*replica
+	next     atomic.Uint64
+
+	// reads of a client go to the primary until its last write + readAfterWrite, see Wrote
+	readAfterWrite atomic.Int64
+
+	logger atomic.Pointer[slog.Logger]
+}
+
+// Client holds the time of the last write of a client, e.g. of a browser session, so that its
+// reads see its own writes while the replicas catch up. Other clients keep reading from the
+// replicas. It is carried by the request context, see WithClient
+type Client struct {
+	lastWrite atomic.Int64
+}
+
+// NewClient returns a client which last wrote at lastWrite, the zero time if it never did
+func NewClient(lastWrite time.Time) *Client {
+
+	c := &Client{}
+	if !lastWrite.IsZero() {
+		c.lastWrite.Store(lastWrite.UnixNano())
+	}
+	return c
+}
+
+// LastWrite returns the time of the last write of the client, the zero time if there was none
+func (c *Client) LastWrite() time.Time {
+
+	n := c.lastWrite.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx carrying c, see Reader and Wrote
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFrom returns the client of ctx, nil if there is none
+func ClientFrom(ctx context.Context) *Client {
+
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
+
+// AddReplica opens a pool for a read replica with the pool settings of cfg. The replica doesn't
+// have to be reachable yet, it is used once a health check succeeds
+func (d *DB) AddReplica(ctx context.Context, cfg Config) error {
+
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	rs := d.replicaSet()
+	if cfg.Logger != nil {
+		rs.logger.Store(cfg.Logger)
+	}
+
+	r := &replica{sql: db}
+	rs.check(ctx, r)
+
+	rs.mu.Lock()
+	rs.replicas = append(rs.replicas, r)
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// SetReadAfterWrite sets how long the reads of a client go to the primary after it wrote, so
+// that a guest who just booked a room doesn't read stale data from a replica that is lagging
+// behind
+func (d *DB) SetReadAfterWrite(window time.Duration) {
+	d.replicaSet().readAfterWrite.Store(int64(window))
+}
+
+// Wrote records a write to the primary by the client of ctx, see SetReadAfterWrite. Writes
+// without a client, e.g. of commands, don't affect any reads
+func (d *DB) Wrote(ctx context.Context) {
+
+	if c := ClientFrom(ctx); c != nil {
+		c.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+// Reader returns the pool for read-only queries of the client of ctx which tolerate replication
+// lag. It is a healthy replica, chosen round robin, or the primary if there is none or the client
+// wrote recently
+func (d *DB) Reader(ctx context.Context) *sql.DB {
+
+	rs := d.replicaSet()
+
+	window := time.Duration(rs.readAfterWrite.Load())
+	if c := ClientFrom(ctx); c != nil && window > 0 && time.Since(c.LastWrite()) < window {
+		return d.SQL
+	}
+
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	n := len(rs.replicas)
+	if n == 0 {
+		return d.SQL
+	}
+
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r.sql
+		}
+	}
+
+	return d.SQL
+}
+
+// CheckReplicas pings every replica and updates its health
+func (d *DB) CheckReplicas(ctx context.Context) {
+
+	rs := d.replicaSet()
+
+	rs.mu.RLock()
+	replicas := append([]*replica(nil), rs.replicas...)
+	rs.mu.RUnlock()
+
+	for _, r := range replicas {
+		rs.check(ctx, r)
+	}
+}
+
+// StartReplicaChecks runs CheckReplicas every interval until the returned func is called
+func (d *DB) StartReplicaChecks(interval time.Duration) func() {
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				d.CheckReplicas(ctx)
+				cancel()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// Close closes the primary and the replica pools
+func (d *DB) Close() error {
+
+	rs := d.replicaSet()
+	rs.mu.RLock()
+	for _, r := range rs.replicas {
+		r.sql.Close()
+	}
+	rs.mu.RUnlock()
+
+	return d.SQL.Close()
+}
+
+// replicaSet returns the replicas of d. It is created once, on first use by any goroutine, so
+// that a DB which isn't made by Connect works as well
+func (d *DB) replicaSet() *replicaSet {
+
+	d.replicasOnce.Do(func() {
+		d.replicas = &replicaSet{}
+	})
+	return d.replicas
+}
+
+// check pings r and logs when its health changes
+func (rs *replicaSet) check(ctx context.Context, r *replica) {
+
+	err := testDb(ctx, r.sql)
+	healthy := err == nil
+
+	logger := rs.logger.Load()
+	if r.healthy.Swap(healthy) != healthy && logger != nil {
+		if healthy {
+			logger.Info("database replica is healthy")
+		} else {
+			logger.Warn("database replica is unhealthy, reading from the primary", "error", err.Error())
+		}
+	}
+}
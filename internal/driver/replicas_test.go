@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+
+	primary, err := NewDatabase(DefaultConfig("host=localhost dbname=primary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DB{SQL: primary}
+	ctx := context.Background()
+
+	// case 1: no replicas, reads go to the primary
+	if d.Reader(ctx) != primary {
+		t.Error("expected the primary without replicas")
+	}
+
+	// nothing listens on port 1, hence the replica starts unhealthy
+	cfg := DefaultConfig("host=127.0.0.1 port=1 dbname=replica connect_timeout=1")
+	err = d.AddReplica(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	replica := d.replicas.replicas[0]
+
+	// case 2: unhealthy replica, reads fail over to the primary
+	if d.Reader(ctx) != primary {
+		t.Error("expected the primary when the replica is unhealthy")
+	}
+
+	// case 3: healthy replica
+	replica.healthy.Store(true)
+	if d.Reader(ctx) != replica.sql {
+		t.Error("expected the replica when it is healthy")
+	}
+
+	// case 4: the reads of a client go to the primary right after it wrote, the reads of other
+	// clients don't
+	d.SetReadAfterWrite(time.Hour)
+	writer := WithClient(ctx, NewClient(time.Time{}))
+	other := WithClient(ctx, NewClient(time.Time{}))
+	if d.Reader(writer) != replica.sql {
+		t.Error("expected the replica before the client wrote")
+	}
+	d.Wrote(writer)
+	if d.Reader(writer) != primary {
+		t.Error("expected the primary right after the client wrote")
+	}
+	if d.Reader(other) != replica.sql || d.Reader(ctx) != replica.sql {
+		t.Error("expected the replica for clients which didn't write")
+	}
+
+	// case 5: a write longer ago than the window
+	old := WithClient(ctx, NewClient(time.Now().Add(-2*time.Hour)))
+	if d.Reader(old) != replica.sql {
+		t.Error("expected the replica after the window")
+	}
+
+	// case 6: a failing health check takes the replica out again
+	d.SetReadAfterWrite(0)
+	d.CheckReplicas(ctx)
+	if d.Reader(ctx) != primary {
+		t.Error("expected the primary after the replica failed its health check")
+	}
+}
+
+func TestClient(t *testing.T) {
+
+	c := NewClient(time.Time{})
+	if !c.LastWrite().IsZero() {
+		t.Errorf("expected no write but got %s", c.LastWrite())
+	}
+
+	// writes without a client in the context are ignored
+	d := &DB{}
+	d.Wrote(context.Background())
+
+	d.Wrote(WithClient(context.Background(), c))
+	if time.Since(c.LastWrite()) > time.Minute {
+		t.Errorf("expected the write to be recorded but got %s", c.LastWrite())
+	}
+
+	at := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	if got := NewClient(at).LastWrite(); !got.Equal(at) {
+		t.Errorf("expected %s but got %s", at, got)
+	}
+}
+
+// TestReaderConcurrent runs the first uses of a DB concurrently, "go test -race" reports it if
+// the replicas are set up unsynchronised
+func TestReaderConcurrent(t *testing.T) {
+
+	primary, err := NewDatabase(DefaultConfig("host=localhost dbname=primary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DB{SQL: primary}
+	defer d.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := WithClient(context.Background(), NewClient(time.Time{}))
+			d.SetReadAfterWrite(time.Second)
+			d.Wrote(ctx)
+			if d.Reader(ctx) != primary {
+				t.Error("expected the primary")
+			}
+			d.CheckReplicas(ctx)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,209 @@
+// Package export writes reservations and room restrictions as CSV files for spreadsheets. Records
+// are written one at a time, so that an export of any size is streamed to the client
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/render"
+	"github.com/prayagsingh/bookings/internal/reports"
+)
+
+// FlushRows is the number of records after which the Writer flushes to the client
+const FlushRows = 100
+
+// Formats of dates and times, which spreadsheets recognize
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ReservationHeader is the header row of the reservations export
+var ReservationHeader = []string{
+	"id", "first_name", "last_name", "email", "phone", "room_id", "room_name",
+	"start_date", "end_date", "nights", "status", "guest_id", "created_at",
+}
+
+// Reservation returns the record of a reservation. Its status is the one on the day today
+func Reservation(res models.Reservation, today time.Time) []string {
+
+	guestID := ""
+	if res.GuestID != 0 {
+		guestID = strconv.Itoa(res.GuestID)
+	}
+
+	return []string{
+		strconv.Itoa(res.ID),
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		strconv.Itoa(res.RoomID),
+		res.Room.RoomName,
+		res.StartDate.Format(dateLayout),
+		res.EndDate.Format(dateLayout),
+		strconv.Itoa(int(res.EndDate.Sub(res.StartDate).Hours() / 24)),
+		res.Status(today),
+		guestID,
+		res.CreatedAt.Format(dateTimeLayout),
+	}
+}
+
+// RoomRestrictionHeader is the header row of the room restrictions export
+var RoomRestrictionHeader = []string{
+	"id", "room_id", "room_name", "restriction_id", "restriction", "reservation_id",
+	"start_date", "end_date", "created_at",
+}
+
+// RoomRestriction returns the record of a room restriction. Blocks have no reservation
+func RoomRestriction(rr models.RoomRestriction) []string {
+
+	reservationID := ""
+	if rr.ReservationID != 0 {
+		reservationID = strconv.Itoa(rr.ReservationID)
+	}
+
+	return []string{
+		strconv.Itoa(rr.ID),
+		strconv.Itoa(rr.RoomID),
+		rr.Room.RoomName,
+		strconv.Itoa(rr.RestrictionID),
+		rr.Restriction.RestrictionName,
+		reservationID,
+		rr.StartDate.Format(dateLayout),
+		rr.EndDate.Format(dateLayout),
+		rr.CreatedAt.Format(dateTimeLayout),
+	}
+}
+
+// ReportHeader is the header row of the occupancy and revenue report. Amounts are in the currency
+// of the property, occupancies are ratios between 0 and 1
+var ReportHeader = []string{
+	"period_start", "period_end", "room_id", "room_name", "available_nights", "sold_nights",
+	"occupancy", "adr", "revpar", "revenue",
+	"last_year_occupancy", "last_year_adr", "last_year_revpar", "last_year_revenue",
+}
+
+// Report returns the records of a period of a report: the whole property first, then every room.
+// The last year columns are empty if there is no period to compare with
+func Report(c reports.Comparison, currency string) [][]string {
+
+	lastYear := map[int]reports.Figures{}
+	for _, room := range c.LastYear.Rooms {
+		lastYear[room.RoomID] = room.Figures
+	}
+
+	records := [][]string{
+		reportRecord(c.Period, "", "All rooms", c.Figures, c.LastYear.Figures, c.HasLastYear, currency),
+	}
+	for _, room := range c.Rooms {
+		ly, ok := lastYear[room.RoomID]
+		records = append(records, reportRecord(c.Period, strconv.Itoa(room.RoomID), room.RoomName,
+			room.Figures, ly, c.HasLastYear && ok, currency))
+	}
+	return records
+}
+
+func reportRecord(p reports.Period, roomID, roomName string, f, lastYear reports.Figures, hasLastYear bool, currency string) []string {
+
+	record := []string{
+		p.Start.Format(dateLayout),
+		p.End.Format(dateLayout),
+		roomID,
+		roomName,
+		strconv.Itoa(f.Available),
+		strconv.Itoa(f.Sold),
+		strconv.FormatFloat(f.Occupancy(), 'f', 4, 64),
+		render.Decimal(f.ADR(), currency),
+		render.Decimal(f.RevPAR(), currency),
+		render.Decimal(f.Revenue, currency),
+	}
+	if !hasLastYear {
+		return append(record, "", "", "", "")
+	}
+	return append(record,
+		strconv.FormatFloat(lastYear.Occupancy(), 'f', 4, 64),
+		render.Decimal(lastYear.ADR(), currency),
+		render.Decimal(lastYear.RevPAR(), currency),
+		render.Decimal(lastYear.Revenue, currency),
+	)
+}
+
+// Cell escapes values which spreadsheets would run as formulas, e.g. a guest named
+// =HYPERLINK(...), by prefixing them with an apostrophe
+func Cell(s string) string {
+
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + s
+	}
+	return s
+}
+
+// Writer writes CSV records to a client
+type Writer struct {
+	csv   *csv.Writer
+	flush func() error
+	rows  int
+}
+
+// NewWriter returns a Writer writing to w. flush sends what has been written so far to the
+// client, it is called every FlushRows records
+func NewWriter(w io.Writer, flush func() error) *Writer {
+
+	return &Writer{csv: csv.NewWriter(w), flush: flush}
+}
+
+// WriteHeader writes a byte order mark, without which Excel doesn't read the file as UTF-8, and
+// the header row
+func (w *Writer) WriteHeader(header []string) error {
+
+	// written through the csv writer, which buffers, at the start of the first field
+	if err := w.csv.Write(append([]string{"\ufeff" + header[0]}, header[1:]...)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Write writes a record with its cells escaped
+func (w *Writer) Write(record []string) error {
+
+	cells := make([]string, len(record))
+	for i, s := range record {
+		cells[i] = Cell(s)
+	}
+	if err := w.csv.Write(cells); err != nil {
+		return err
+	}
+
+	w.rows++
+	if w.rows%FlushRows == 0 {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush sends the buffered records to the client
+func (w *Writer) Flush() error {
+
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	if w.flush != nil {
+		return w.flush()
+	}
+	return nil
+}
+
+// Rows returns the number of records written, without the header
+func (w *Writer) Rows() int {
+	return w.rows
+}
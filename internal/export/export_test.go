@@ -0,0 +1,161 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/reports"
+)
+
+var cellTests = []struct {
+	input    string
+	expected string
+}{
+	{"Smith", "Smith"},
+	{"", ""},
+	{"=HYPERLINK(\"http://evil.example\")", "'=HYPERLINK(\"http://evil.example\")"},
+	{"+1 555 0100", "'+1 555 0100"},
+	{"-2+3", "'-2+3"},
+	{"@SUM(A1)", "'@SUM(A1)"},
+	{"a=b", "a=b"},
+}
+
+func TestCell(t *testing.T) {
+
+	for _, e := range cellTests {
+		if got := Cell(e.input); got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.input, e.expected, got)
+		}
+	}
+}
+
+func TestReservation(t *testing.T) {
+
+	day := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "John",
+		LastName:  "Smith",
+		RoomID:    1,
+		StartDate: day,
+		EndDate:   day.AddDate(0, 0, 3),
+		CreatedAt: day.Add(-36 * time.Hour),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	record := Reservation(res, day.AddDate(0, 0, 1))
+	if len(record) != len(ReservationHeader) {
+		t.Fatalf("expected %d fields but got %d", len(ReservationHeader), len(record))
+	}
+
+	expected := map[string]string{
+		"id":         "7",
+		"room_name":  "General's Quarters",
+		"start_date": "2021-10-01",
+		"nights":     "3",
+		"status":     models.ReservationInHouse,
+		"guest_id":   "",
+		"created_at": "2021-09-29 12:00:00",
+	}
+	for i, name := range ReservationHeader {
+		if want, ok := expected[name]; ok && record[i] != want {
+			t.Errorf("%s: expected %q but got %q", name, want, record[i])
+		}
+	}
+}
+
+func TestRoomRestriction(t *testing.T) {
+
+	record := RoomRestriction(models.RoomRestriction{ID: 1, RestrictionID: 2, Restriction: models.Restriction{RestrictionName: "Owner Block"}})
+	if len(record) != len(RoomRestrictionHeader) {
+		t.Fatalf("expected %d fields but got %d", len(RoomRestrictionHeader), len(record))
+	}
+	if record[4] != "Owner Block" || record[5] != "" {
+		t.Errorf("expected a block without reservation but got %v", record)
+	}
+}
+
+func TestWriter(t *testing.T) {
+
+	var buf bytes.Buffer
+	flushes := 0
+	w := NewWriter(&buf, func() error {
+		flushes++
+		return nil
+	})
+
+	if err := w.WriteHeader([]string{"id", "name"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < FlushRows+1; i++ {
+		if err := w.Write([]string{"1", "=cmd"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// once for the header row and once after FlushRows records
+	if flushes != 2 {
+		t.Errorf("expected 2 flushes but got %d", flushes)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "\ufeffid,name\n") {
+		t.Errorf("expected a byte order mark and the header but got %q", out[:20])
+	}
+
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != FlushRows+2 || w.Rows() != FlushRows+1 {
+		t.Errorf("expected %d records but got %d, %d rows", FlushRows+2, len(records), w.Rows())
+	}
+	if records[1][1] != "'=cmd" {
+		t.Errorf("expected an escaped cell but got %q", records[1][1])
+	}
+}
+
+func TestReport(t *testing.T) {
+
+	day := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	nights := []models.RoomNight{
+		{RoomID: 1, RoomName: "=One", Day: day, Sold: 1, Revenue: 12345},
+		{RoomID: 2, RoomName: "Two", Day: day},
+	}
+	lastYearNights := []models.RoomNight{
+		{RoomID: 1, RoomName: "=One", Day: day.AddDate(-1, 0, 0)},
+	}
+
+	report := reports.Build(nights, day, day, reports.Day)
+	lastYear := reports.Build(lastYearNights, day.AddDate(-1, 0, 0), day.AddDate(-1, 0, 0), reports.Day)
+	records := Report(reports.Compare(report, lastYear)[0], "USD")
+
+	expected := [][]string{
+		{"2021-10-01", "2021-10-01", "", "All rooms", "2", "1", "0.5000", "123.45", "61.73", "123.45", "0.0000", "0.00", "0.00", "0.00"},
+		{"2021-10-01", "2021-10-01", "1", "=One", "1", "1", "1.0000", "123.45", "123.45", "123.45", "0.0000", "0.00", "0.00", "0.00"},
+		// the room didn't exist last year
+		{"2021-10-01", "2021-10-01", "2", "Two", "1", "0", "0.0000", "0.00", "0.00", "0.00", "", "", "", ""},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records but got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if len(record) != len(ReportHeader) {
+			t.Errorf("record %d: expected %d columns but got %d", i, len(ReportHeader), len(record))
+			continue
+		}
+		for j := range record {
+			if record[j] != expected[i][j] {
+				t.Errorf("record %d, %s: expected %q but got %q", i, ReportHeader[j], expected[i][j], record[j])
+			}
+		}
+	}
+}
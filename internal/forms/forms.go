@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -71,3 +73,56 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// minPasswordLength is the length every password must have
+const minPasswordLength = 10
+
+// StrongPassword checks that a password is at least 10 characters long, mixes at least three of
+// lower case letters, upper case letters, digits and other characters, and doesn't contain the
+// user's email address or its local part
+func (f *Form) StrongPassword(field, email string) bool {
+
+	password := f.Get(field)
+
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		f.Errors.Add(field, fmt.Sprintf("The password must be at least %d characters long", minPasswordLength))
+		return false
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < 3 {
+		f.Errors.Add(field, "The password must mix at least three of lower case letters, upper case letters, digits and symbols")
+		return false
+	}
+
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if local != "" && strings.Contains(strings.ToLower(password), local) {
+		f.Errors.Add(field, "The password must not contain your email address")
+		return false
+	}
+
+	return true
+}
+
+// Matches checks that two fields have the same value, e.g. a password and its confirmation
+func (f *Form) Matches(field, other string) bool {
+
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(other, "The values don't match")
+		return false
+	}
+
+	return true
+}
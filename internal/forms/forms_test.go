@@ -144,3 +144,49 @@ func TestForm_IsEmail(t *testing.T) {
 	}
 
 }
+
+var strongPasswordTests = []struct {
+	name     string
+	password string
+	email    string
+	isValid  bool
+}{
+	{"strong", "Correct-Horse-7", "admin@here.com", true},
+	{"three-classes", "correcthorse7!", "admin@here.com", true},
+	{"too-short", "Aa1!", "admin@here.com", false},
+	{"two-classes", "correcthorsebattery", "admin@here.com", false},
+	{"contains-email", "Admin-Password-1", "admin@here.com", false},
+}
+
+func TestForm_StrongPassword(t *testing.T) {
+
+	for _, e := range strongPasswordTests {
+		postedData := url.Values{}
+		postedData.Add("password", e.password)
+		form := New(postedData)
+
+		form.StrongPassword("password", e.email)
+		if form.Valid() != e.isValid {
+			t.Errorf("failed %s: expected valid %v but got %v (%s)", e.name, e.isValid, form.Valid(), form.Errors.Get("password"))
+		}
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+
+	postedData := url.Values{}
+	postedData.Add("password", "Correct-Horse-7")
+	postedData.Add("password_confirm", "Correct-Horse-8")
+	form := New(postedData)
+
+	form.Matches("password", "password_confirm")
+	if form.Valid() || form.Errors.Get("password_confirm") == "" {
+		t.Error("expected an error on the confirmation")
+	}
+
+	postedData.Set("password_confirm", "Correct-Horse-7")
+	form = New(postedData)
+	if !form.Matches("password", "password_confirm") {
+		t.Error("expected matching values to be valid")
+	}
+}
@@ -4,38 +4,64 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
+
 	"github.com/prayagsingh/bookings/internal/config"
 	"github.com/prayagsingh/bookings/internal/driver"
+	"github.com/prayagsingh/bookings/internal/export"
 	"github.com/prayagsingh/bookings/internal/forms"
 	"github.com/prayagsingh/bookings/internal/helpers"
+	"github.com/prayagsingh/bookings/internal/importer"
+	"github.com/prayagsingh/bookings/internal/mailer"
+	"github.com/prayagsingh/bookings/internal/metrics"
 	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/ratelimit"
+	"github.com/prayagsingh/bookings/internal/rbac"
 	"github.com/prayagsingh/bookings/internal/render"
+	"github.com/prayagsingh/bookings/internal/reports"
 	"github.com/prayagsingh/bookings/internal/repository"
 	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+	"github.com/prayagsingh/bookings/internal/requestctx"
+	"github.com/prayagsingh/bookings/internal/tokens"
+	"github.com/prayagsingh/bookings/internal/totp"
 )
 
 // Repo the repository used by the handlers
 var Repo *Repository
 
+// DefaultPropertyID is the property served when neither the hostname nor the path prefix
+// identifies one
+const DefaultPropertyID = 1
+
 // Repository is the repository type
 type Repository struct {
 	App *config.AppConfig
 	// making sure that the db is available to handlers
 	DB repository.DatabaseRepo
+	// LoginLockout locks an email address out for a client IP after repeated failed logins. It is
+	// kept in memory, so every instance counts the failures it has seen on its own
+	LoginLockout *ratelimit.Lockout
 }
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:          a,
+		DB:           dbrepo.NewInstrumentedRepo(dbrepo.NewPostgresRepo(db, a)),
+		LoginLockout: ratelimit.DefaultLockout(),
 	}
 }
 
@@ -43,8 +69,9 @@ func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 func NewTestRepo(a *config.AppConfig) *Repository {
 
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestPostgresRepo(a),
+		App:          a,
+		DB:           dbrepo.NewTestPostgresRepo(a),
+		LoginLockout: ratelimit.DefaultLockout(),
 	}
 }
 
@@ -56,14 +83,18 @@ func NewHandler(r *Repository) {
 // Home is the handler for the home page
 func (m *Repository) Home(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "home.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "home.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 
 }
 
 // About is the handler for the about page
 func (m *Repository) About(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "about.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "about.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // Reservations renders a make a reservation page and displays form
@@ -79,36 +110,47 @@ func (m *Repository) Reservations(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	// get the room by room-id to display it on the page
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, ok, err := m.propertyRoom(r, res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if !ok {
+		helpers.ClientError(rw, r, http.StatusNotFound)
+		return
+	}
 
-	// storing room name to reservation
+	// storing room name to reservation, and the price of the stay at the current rate
 	res.Room.RoomName = room.RoomName
+	res.Amount = render.Nights(res.StartDate, res.EndDate) * room.NightlyRate
+
+	// logged in guests don't have to type their details again
+	if guestID := helpers.GuestID(r); guestID != 0 && res.Email == "" {
+		guest, err := m.db(r).GetGuestByID(guestID)
+		if err != nil {
+			helpers.Logger(r).Warn("can't load guest profile", "guest_id", guestID, "error", err)
+		} else {
+			res.FirstName = guest.FirstName
+			res.LastName = guest.LastName
+			res.Email = guest.Email
+			res.Phone = guest.Phone
+		}
+	}
 
 	// putting room name to session
 	m.App.Session.Put(r.Context(), "reservation", res)
 
-	// converting time.Time formart to string to serve to html page
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
-
-	// passing it as a stringmap in templatedata so that html page can have access
-	dates := make(map[string]string)
-	dates["start_date"] = sd
-	dates["end_date"] = ed
-
+	// dates are formatted in the template using the formatDate and humanDate functions
 	data := make(map[string]interface{})
 	data["reservation"] = res
 
-	render.Template(rw, r, "make-reservation.page.html", &models.TemplateData{
-		Form:      forms.New(nil),
-		StringMap: dates,
-		Data:      data,
-	})
+	if err := render.Template(rw, r, "make-reservation.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // PostReservations handles the posting of a reservation form
@@ -130,10 +172,24 @@ func (m *Repository) PostReservations(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the room was checked when it was put into the session, but the session may have been
+	// started on the host of another property
+	_, ok, err = m.propertyRoom(r, reservation.RoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if !ok {
+		helpers.ClientError(rw, r, http.StatusNotFound)
+		return
+	}
+
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
+	reservation.GuestID = helpers.GuestID(r)
 
 	// creating a form object to check our data
 	form := forms.New(r.PostForm)
@@ -147,15 +203,17 @@ func (m *Repository) PostReservations(rw http.ResponseWriter, r *http.Request) {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
 
-		render.Template(rw, r, "make-reservation.page.html", &models.TemplateData{
+		if err := render.Template(rw, r, "make-reservation.page.html", &models.TemplateData{
 			Form: form,
 			Data: data,
-		})
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
 		return
 	}
 
 	// putting reservation data to DB
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	newReservationID, err := m.db(r).InsertReservation(helpers.Actor(r), reservation)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't insert reservation into DB")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
@@ -165,18 +223,20 @@ func (m *Repository) PostReservations(rw http.ResponseWriter, r *http.Request) {
 	restriction := models.RoomRestriction{
 		RoomID:        reservation.RoomID,
 		ReservationID: newReservationID,
-		RestrictionID: 1,
+		RestrictionID: models.RestrictionReservation,
 		StartDate:     reservation.StartDate,
 		EndDate:       reservation.EndDate,
 	}
 
-	err = m.DB.InserRoomRestriction(restriction)
+	err = m.db(r).InserRoomRestriction(helpers.Actor(r), restriction)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't insert restriction into DB")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
+	metrics.ReservationsCreated.Inc()
+
 	// showing the reservation summary using session.  to do this we have to pass the reservation
 	// object to session and when we get to reservation-sumary page then we will pull out the object
 	// from Session and finally sent it to the template and display the information
@@ -191,19 +251,25 @@ func (m *Repository) PostReservations(rw http.ResponseWriter, r *http.Request) {
 // Villas renders the room page
 func (m *Repository) Villas(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "villas.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "villas.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // Suites renders the room page
 func (m *Repository) Suites(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "suites.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "suites.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // Availability renders the search availability page
 func (m *Repository) Availability(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "search-availability.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "search-availability.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // PostAvailability renders the search availability page
@@ -235,7 +301,9 @@ func (m *Repository) PostAvailability(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	metrics.Searches.Inc()
+
+	rooms, err := m.db(r).SearchAvailabilityForAllRooms(startDate, endDate, m.currentProperty(r).ID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms based on start and end date")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
@@ -243,6 +311,7 @@ func (m *Repository) PostAvailability(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(rooms) == 0 {
+		metrics.SearchesNoAvailability.Inc()
 		// no room available
 		m.App.Session.Put(r.Context(), "error", "No rooms available !!!")
 		// redirecting with 303 status code
@@ -261,9 +330,11 @@ func (m *Repository) PostAvailability(rw http.ResponseWriter, r *http.Request) {
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
-	render.Template(rw, r, "choose-rooms.page.html", &models.TemplateData{
+	if err := render.Template(rw, r, "choose-rooms.page.html", &models.TemplateData{
 		Data: data,
-	})
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // AvailabiltyJSON is using it to build JSON response. Scope is limited
@@ -303,14 +374,27 @@ func (m *Repository) AvailabilityJSON(rw http.ResponseWriter, r *http.Request) {
 
 	startDate, err := time.Parse(layout, sd)
 	if err != nil {
-		helpers.ServerError(rw, err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
 	}
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(rw, err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	_, ok, err := m.propertyRoom(r, roomID)
+	if err == nil && !ok {
+		helpers.ClientError(rw, r, http.StatusNotFound)
+		return
 	}
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	metrics.Searches.Inc()
+
+	available := false
+	if err == nil {
+		available, err = m.db(r).SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	}
 	if err != nil {
 		// can't parse form return appropriate JSON
 		res := jsonResponse{
@@ -323,6 +407,10 @@ func (m *Repository) AvailabilityJSON(rw http.ResponseWriter, r *http.Request) {
 		rw.Write(out)
 		return
 	}
+	if !available {
+		metrics.SearchesNoAvailability.Inc()
+	}
+
 	// making json resp dynamic based on the response we get from the DB .i.e whether room is available for not
 	resp := jsonResponse{
 		OK:        available,
@@ -342,7 +430,9 @@ func (m *Repository) AvailabilityJSON(rw http.ResponseWriter, r *http.Request) {
 // Contact renders the search contact page
 func (m *Repository) Contact(rw http.ResponseWriter, r *http.Request) {
 
-	render.Template(rw, r, "contact.page.html", &models.TemplateData{})
+	if err := render.Template(rw, r, "contact.page.html", &models.TemplateData{}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // ReservationSummary displays the reservation summary page
@@ -351,7 +441,7 @@ func (m *Repository) ReservationSummary(rw http.ResponseWriter, r *http.Request)
 	// doing type assert(added models.Reservation) to identify what type of session it is.
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		//m.App.ErrorLog.Println("get error from session")
+		//helpers.Logger(r).Error("can't get reservation from session")
 		// if a user directly went to /reservation-summary page directly then it will show empty page
 		// because of lack of session hence we have to show them something if they directly went to
 		// reservation-summary page
@@ -367,17 +457,11 @@ func (m *Repository) ReservationSummary(rw http.ResponseWriter, r *http.Request)
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
 
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
-
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
-
-	render.Template(rw, r, "reservation-summary.page.html", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-	})
+	if err := render.Template(rw, r, "reservation-summary.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
 }
 
 // ChooseRoom displays list of available rooms
@@ -402,6 +486,17 @@ func (m *Repository) ChooseRoom(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, ok, err = m.propertyRoom(r, roomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if !ok {
+		helpers.ClientError(rw, r, http.StatusNotFound)
+		return
+	}
+
 	res.RoomID = roomID
 	// putting back the reservation to session after updating the room-id
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -418,6 +513,7 @@ func (m *Repository) BookRoom(rw http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "missing url parameter")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
 	sd := r.URL.Query().Get("s")
 	ed := r.URL.Query().Get("e")
@@ -425,19 +521,26 @@ func (m *Repository) BookRoom(rw http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, sd)
 	if err != nil {
-		helpers.ServerError(rw, err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
 	}
 
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(rw, err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
 	}
 
 	// get the room by room-id to display it on the page
-	room, err := m.DB.GetRoomByID(roomID)
+	room, ok, err := m.propertyRoom(r, roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
 		http.Redirect(rw, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if !ok {
+		helpers.ClientError(rw, r, http.StatusNotFound)
+		return
 	}
 
 	// storing above details in session
@@ -452,3 +555,1656 @@ func (m *Repository) BookRoom(rw http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(rw, r, "/make-reservation", http.StatusSeeOther)
 }
+
+// currentProperty returns the property resolved by the PropertyLoad middleware. Requests which
+// did not pass through the middleware are served for the default property
+func (m *Repository) currentProperty(r *http.Request) models.Property {
+
+	if p, ok := requestctx.Property(r.Context()); ok {
+		return p
+	}
+
+	return models.Property{ID: DefaultPropertyID}
+}
+
+// db returns the repository for the client of the request, see ReadYourWrites in cmd/web: its
+// reads go to the primary for a while after it wrote
+func (m *Repository) db(r *http.Request) repository.DatabaseRepo {
+	return m.DB.WithContext(r.Context())
+}
+
+// propertyRoom loads a room and reports whether it belongs to the property of the request. Room
+// ids come from URLs, forms and the session, a guest on the host of one property must not look up
+// or book the rooms of another
+func (m *Repository) propertyRoom(r *http.Request, roomID int) (models.Room, bool, error) {
+
+	room, err := m.db(r).GetRoomByID(roomID)
+	if err != nil {
+		return room, false, err
+	}
+
+	return room, room.PropertyID == m.currentProperty(r).ID, nil
+}
+
+// ShowLogin shows the staff login screen
+func (m *Repository) ShowLogin(rw http.ResponseWriter, r *http.Request) {
+
+	if err := render.Template(rw, r, "login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostShowLogin handles logging the staff user in
+func (m *Repository) PostShowLogin(rw http.ResponseWriter, r *http.Request) {
+
+	// prevents session fixation attack. it is good practice to renew the token on login or logout
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
+		http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		if err := render.Template(rw, r, "login.page.html", &models.TemplateData{
+			Form: form,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	// repeated failures lock the email address out for a while, see ratelimit.Lockout. Only for the
+	// client IP, so that nobody can lock staff out of their accounts by guessing from elsewhere
+	lockoutKey := strings.ToLower(email) + " " + helpers.ClientIP(r)
+	if wait := m.LoginLockout.Locked(lockoutKey); wait > 0 {
+		helpers.TooManyRequests(rw, r, wait)
+		return
+	}
+
+	id, _, err := m.db(r).Authenticate(email, password)
+	if err != nil {
+		if wait := m.LoginLockout.Failure(lockoutKey); wait > 0 {
+			helpers.Logger(r).Warn("login locked out", "email", email, "duration", wait.String())
+			helpers.TooManyRequests(rw, r, wait)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	m.LoginLockout.Success(lockoutKey)
+
+	user, err := m.db(r).GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	// the password is right, but users with a second factor are only logged in once the code
+	// checks out as well
+	if user.TwoFactorEnabled {
+		m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+		// stored as int, the session codec only knows the types registered with gob
+		m.App.Session.Put(r.Context(), "two_factor_started", int(time.Now().Unix()))
+		http.Redirect(rw, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.logIn(rw, r, user)
+}
+
+// logIn puts the staff user into the session once all factors have been checked
+func (m *Repository) logIn(rw http.ResponseWriter, r *http.Request, user models.User) {
+
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	// a password reset increments the version of the user, which logs out every other session
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+
+	// the admin area is closed to users who must set up a second factor but haven't yet
+	if user.TwoFactorRequired && !user.TwoFactorEnabled {
+		http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(rw, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// twoFactorTimeout is how long the code may be entered after the password
+const twoFactorTimeout = 5 * time.Minute
+
+// twoFactorUser returns the user who entered the right password and has yet to enter the code
+func (m *Repository) twoFactorUser(r *http.Request) (models.User, bool, error) {
+
+	id := m.App.Session.GetInt(r.Context(), "two_factor_user_id")
+	started := time.Unix(int64(m.App.Session.GetInt(r.Context(), "two_factor_started")), 0)
+	if id == 0 || time.Since(started) > twoFactorTimeout {
+		return models.User{}, false, nil
+	}
+
+	user, err := m.db(r).GetUserByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, false, nil
+	} else if err != nil {
+		return user, false, err
+	}
+
+	return user, user.TwoFactorEnabled, nil
+}
+
+// ShowTwoFactor asks for the code of the authenticator app after the password
+func (m *Repository) ShowTwoFactor(rw http.ResponseWriter, r *http.Request) {
+
+	_, ok, err := m.twoFactorUser(r)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := render.Template(rw, r, "two-factor.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostTwoFactor checks the code of the authenticator app, or a recovery code, and logs the user in
+func (m *Repository) PostTwoFactor(rw http.ResponseWriter, r *http.Request) {
+
+	user, ok, err := m.twoFactorUser(r)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	// guessing codes is slowed down like guessing passwords
+	lockoutKey := "two-factor:" + strconv.Itoa(user.ID)
+	if wait := m.LoginLockout.Locked(lockoutKey); wait > 0 {
+		helpers.TooManyRequests(rw, r, wait)
+		return
+	}
+
+	code := strings.TrimSpace(r.Form.Get("code"))
+
+	// the user isn't logged in yet, the audit log records the user anyway
+	actor := models.Actor{Type: models.ActorUser, UserID: user.ID, IP: helpers.ClientIP(r)}
+
+	var valid, recovery bool
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		// a code is good for one login only
+		valid, err = m.db(r).UseTOTPStep(user.ID, step)
+	} else if len(code) > totp.Digits {
+		recovery = true
+		valid, err = m.db(r).UseRecoveryCode(actor, user.ID, tokens.Hash(totp.NormalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	if !valid {
+		if wait := m.LoginLockout.Failure(lockoutKey); wait > 0 {
+			helpers.Logger(r).Warn("two factor locked out", "user_id", user.ID, "duration", wait.String())
+			helpers.TooManyRequests(rw, r, wait)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "Invalid code")
+		http.Redirect(rw, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+	m.LoginLockout.Success(lockoutKey)
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_started")
+
+	if recovery {
+		helpers.Logger(r).Warn("logged in with a recovery code", "user_id", user.ID)
+		m.App.Session.Put(r.Context(), "warning", "You used a recovery code, it can't be used again")
+	}
+
+	m.logIn(rw, r, user)
+}
+
+// twoFactorIssuer names the site in authenticator apps
+const twoFactorIssuer = "Bookings"
+
+// recoveryCodeCount is the number of recovery codes created when enabling a second factor
+const recoveryCodeCount = 10
+
+// provisioningURI returns the otpauth:// URI of secret for the QR code. html/template only lets
+// http, https and mailto URLs through unless they are marked as safe; this one is built here
+func provisioningURI(user models.User, secret string) template.URL {
+	return template.URL(totp.URI(twoFactorIssuer, user.Email, secret))
+}
+
+// ShowTwoFactorSetup shows whether the logged in staff user has a second factor. Without one it
+// shows a new secret as QR code to scan with an authenticator app
+func (m *Repository) ShowTwoFactorSetup(rw http.ResponseWriter, r *http.Request) {
+
+	user, _ := requestctx.User(r.Context())
+
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	if !user.TwoFactorEnabled {
+		// the secret is kept in the session until it has been confirmed with a code
+		secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+		if secret == "" {
+			var err error
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				helpers.ServerError(rw, r, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_setup_secret", secret)
+		}
+		data["secret"] = secret
+		data["uri"] = provisioningURI(user, secret)
+	}
+
+	if err := render.Template(rw, r, "two-factor-setup.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostTwoFactorSetup turns on the second factor once the user entered a code of the new secret, and
+// shows the recovery codes. They are shown this once only
+func (m *Repository) PostTwoFactorSetup(rw http.ResponseWriter, r *http.Request) {
+
+	user, _ := requestctx.User(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+	if user.TwoFactorEnabled || secret == "" {
+		http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	step, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "The code doesn't match, check the time of your device")
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["user"] = user
+		data["secret"] = secret
+		data["uri"] = provisioningURI(user, secret)
+
+		if err := render.Template(rw, r, "two-factor-setup.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	codes, err := totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = tokens.Hash(c)
+	}
+
+	err = m.db(r).EnableTwoFactor(helpers.Actor(r), user.ID, secret, step, hashes)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+	m.App.Session.Remove(r.Context(), "totp_setup_secret")
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+
+	if err := render.Template(rw, r, "two-factor-recovery.page.html", &models.TemplateData{
+		Flash: "Two-factor authentication is on",
+		Data:  data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostTwoFactorDisable turns off the second factor of the logged in staff user after checking a
+// code, unless the policy requires one for the access level of the user
+func (m *Repository) PostTwoFactorDisable(rw http.ResponseWriter, r *http.Request) {
+
+	user, _ := requestctx.User(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+		return
+	}
+	if user.TwoFactorRequired {
+		m.App.Session.Put(r.Context(), "error", "Two-factor authentication is required for your role")
+		http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+		return
+	}
+
+	if _, ok := totp.Validate(user.TOTPSecret, r.Form.Get("code"), time.Now()); !ok {
+		m.App.Session.Put(r.Context(), "error", "Invalid code")
+		http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+		return
+	}
+
+	if err := m.db(r).DisableTwoFactor(helpers.Actor(r), user.ID); err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is off")
+	http.Redirect(rw, r, "/user/two-factor/setup", http.StatusSeeOther)
+}
+
+// Logout logs the staff user out
+func (m *Repository) Logout(rw http.ResponseWriter, r *http.Request) {
+
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+}
+
+// passwordResetTTL is how long a password reset link works
+const passwordResetTTL = time.Hour
+
+// forgotPasswordSent is shown whether or not the email belongs to a user, so that the form can't
+// be used to find out who has an account
+const forgotPasswordSent = "If the email address belongs to an account, a link to reset the password has been sent to it"
+
+// ShowForgotPassword shows the form asking for the email address to send a password reset link to
+func (m *Repository) ShowForgotPassword(rw http.ResponseWriter, r *http.Request) {
+
+	if err := render.Template(rw, r, "forgot-password.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostForgotPassword emails a password reset link to the user with the email address
+func (m *Repository) PostForgotPassword(rw http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		if err := render.Template(rw, r, "forgot-password.page.html", &models.TemplateData{
+			Form: form,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	user, err := m.db(r).GetUserByEmail(form.Get("email"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.Logger(r).Info("password reset for unknown email")
+	case err != nil:
+		helpers.ServerError(rw, r, err)
+		return
+	default:
+		if err := m.sendPasswordReset(r, user); err != nil {
+			helpers.ServerError(rw, r, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", forgotPasswordSent)
+	http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset stores a new reset token for user and emails the link. The link carries the
+// token and its signature, only the hash of the token is stored
+func (m *Repository) sendPasswordReset(r *http.Request, user models.User) error {
+
+	token, err := tokens.New()
+	if err != nil {
+		return err
+	}
+
+	err = m.db(r).InsertPasswordReset(user.ID, tokens.Hash(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+
+	link := m.App.BaseURL + "/user/reset-password?" + url.Values{
+		"token": {token},
+		"sig":   {tokens.Sign(m.App.SecretKey, token)},
+	}.Encode()
+
+	return m.App.Mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"someone, hopefully you, asked to reset the password of your account. Open the link below "+
+			"within an hour to choose a new one:\n\n%s\n\n"+
+			"If you didn't ask for it, ignore this email; your password stays unchanged.\n",
+			user.FirstName, link),
+	})
+}
+
+// resetToken returns the token of a password reset link, or false if it is missing or the
+// signature doesn't match
+func (m *Repository) resetToken(values url.Values) (string, bool) {
+
+	token := values.Get("token")
+	if token == "" || !tokens.Verify(m.App.SecretKey, token, values.Get("sig")) {
+		return "", false
+	}
+	return token, true
+}
+
+// passwordResetFailed sends the user back to the forgot password form to ask for a new link
+func (m *Repository) passwordResetFailed(rw http.ResponseWriter, r *http.Request) {
+
+	m.App.Session.Put(r.Context(), "error", "The link is invalid or has expired, please ask for a new one")
+	http.Redirect(rw, r, "/user/forgot-password", http.StatusSeeOther)
+}
+
+// ShowResetPassword shows the form choosing a new password, reached from a password reset link
+func (m *Repository) ShowResetPassword(rw http.ResponseWriter, r *http.Request) {
+
+	token, ok := m.resetToken(r.URL.Query())
+	if !ok {
+		m.passwordResetFailed(rw, r)
+		return
+	}
+
+	_, err := m.db(r).UserForPasswordReset(tokens.Hash(token))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		m.passwordResetFailed(rw, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	// the token is posted back with the form
+	form := forms.New(url.Values{
+		"token": {token},
+		"sig":   {r.URL.Query().Get("sig")},
+	})
+
+	if err := render.Template(rw, r, "reset-password.page.html", &models.TemplateData{
+		Form: form,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostResetPassword sets the new password, uses up the token and logs out every session of the user
+func (m *Repository) PostResetPassword(rw http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	token, ok := m.resetToken(r.PostForm)
+	if !ok {
+		m.passwordResetFailed(rw, r)
+		return
+	}
+
+	user, err := m.db(r).UserForPasswordReset(tokens.Hash(token))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		m.passwordResetFailed(rw, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.StrongPassword("password", user.Email)
+	form.Matches("password", "password_confirm")
+
+	if !form.Valid() {
+		if err := render.Template(rw, r, "reset-password.page.html", &models.TemplateData{
+			Form: form,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	_, err = m.db(r).ResetPassword(helpers.Actor(r), tokens.Hash(token), form.Get("password"))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		// used or expired since the form was shown
+		m.passwordResetFailed(rw, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	// the session may belong to the user, it is logged out like every other one
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, please log in")
+	http.Redirect(rw, r, "/user/login", http.StatusSeeOther)
+}
+
+// ShowGuestRegister shows the form creating a guest account
+func (m *Repository) ShowGuestRegister(rw http.ResponseWriter, r *http.Request) {
+
+	if err := render.Template(rw, r, "guest-register.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostGuestRegister creates a guest account and logs the guest in
+func (m *Repository) PostGuestRegister(rw http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password", "password_confirm")
+	form.IsEmail("email")
+	form.StrongPassword("password", form.Get("email"))
+	form.Matches("password", "password_confirm")
+
+	guest := models.Guest{
+		FirstName: form.Get("first_name"),
+		LastName:  form.Get("last_name"),
+		Email:     form.Get("email"),
+		Phone:     form.Get("phone"),
+	}
+
+	if form.Valid() {
+		guest.ID, err = m.db(r).InsertGuest(guest, form.Get("password"))
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			form.Errors.Add("email", "This email address has an account already, please log in")
+		} else if err != nil {
+			helpers.ServerError(rw, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		if err := render.Template(rw, r, "guest-register.page.html", &models.TemplateData{
+			Form: form,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	m.guestLoggedIn(rw, r, guest.ID, "Your account has been created")
+}
+
+// ShowGuestLogin shows the login form of guest accounts
+func (m *Repository) ShowGuestLogin(rw http.ResponseWriter, r *http.Request) {
+
+	if err := render.Template(rw, r, "guest-login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// PostGuestLogin logs a guest in
+func (m *Repository) PostGuestLogin(rw http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		if err := render.Template(rw, r, "guest-login.page.html", &models.TemplateData{
+			Form: form,
+		}); err != nil {
+			helpers.ServerError(rw, r, err)
+		}
+		return
+	}
+
+	// guests are locked out separately from staff users with the same email address
+	lockoutKey := "guest:" + strings.ToLower(form.Get("email"))
+	if wait := m.LoginLockout.Locked(lockoutKey); wait > 0 {
+		helpers.TooManyRequests(rw, r, wait)
+		return
+	}
+
+	id, err := m.db(r).AuthenticateGuest(form.Get("email"), form.Get("password"))
+	if err != nil {
+		if wait := m.LoginLockout.Failure(lockoutKey); wait > 0 {
+			helpers.Logger(r).Warn("guest login locked out", "email", form.Get("email"), "duration", wait.String())
+			helpers.TooManyRequests(rw, r, wait)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(rw, r, "/guest/login", http.StatusSeeOther)
+		return
+	}
+	m.LoginLockout.Success(lockoutKey)
+
+	m.guestLoggedIn(rw, r, id, "Logged in successfully")
+}
+
+// guestLoggedIn puts the guest into the session. A guest logging in while booking goes back to the
+// reservation form, which is then filled in from the profile
+func (m *Repository) guestLoggedIn(rw http.ResponseWriter, r *http.Request, guestID int, flash string) {
+
+	// prevents session fixation
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "guest_id", guestID)
+	m.App.Session.Put(r.Context(), "flash", flash)
+
+	if _, booking := m.App.Session.Get(r.Context(), "reservation").(models.Reservation); booking {
+		http.Redirect(rw, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(rw, r, "/guest/bookings", http.StatusSeeOther)
+}
+
+// GuestLogout logs the guest out. Unlike the staff logout it keeps the rest of the session, e.g.
+// a reservation in progress
+func (m *Repository) GuestLogout(rw http.ResponseWriter, r *http.Request) {
+
+	m.App.Session.Remove(r.Context(), "guest_id")
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(rw, r, "/", http.StatusSeeOther)
+}
+
+// GuestBookings shows the upcoming and past stays of the logged in guest
+func (m *Repository) GuestBookings(rw http.ResponseWriter, r *http.Request) {
+
+	guestID := helpers.GuestID(r)
+
+	guest, err := m.db(r).GetGuestByID(guestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the account has been deleted since logging in
+		m.App.Session.Remove(r.Context(), "guest_id")
+		http.Redirect(rw, r, "/guest/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	reservations, err := m.db(r).GuestReservations(guestID)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	// a stay is upcoming until the day of departure. Upcoming stays are listed soonest first, past
+	// ones latest first
+	today := time.Now().Truncate(24 * time.Hour)
+	var upcoming, past []models.Reservation
+	for _, res := range reservations {
+		if res.EndDate.Before(today) {
+			past = append(past, res)
+		} else {
+			upcoming = append([]models.Reservation{res}, upcoming...)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	if err := render.Template(rw, r, "guest-bookings.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// adminProperty returns the property the logged in staff user is currently managing together
+// with every property the user has access to. Admins manage every property, the other staff only
+// ever see data of the properties they have been granted in property_users
+func (m *Repository) adminProperty(r *http.Request) (models.Property, []models.Property, error) {
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	properties, err := m.db(r).PropertiesForUser(userID)
+	if err != nil {
+		return models.Property{}, nil, err
+	}
+
+	if len(properties) == 0 {
+		return models.Property{}, nil, errors.New("user has no access to any property")
+	}
+
+	selected := m.App.Session.GetInt(r.Context(), "admin_property_id")
+	for _, p := range properties {
+		if p.ID == selected {
+			return p, properties, nil
+		}
+	}
+
+	return properties[0], properties, nil
+}
+
+// AdminDashboard shows the admin dashboard for the property the staff user is managing
+func (m *Repository) AdminDashboard(rw http.ResponseWriter, r *http.Request) {
+
+	property, properties, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["properties"] = properties
+
+	if err := render.Template(rw, r, "admin-dashboard.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// reservationSorts are the columns the reservation search can be sorted by
+var reservationSorts = map[string]bool{
+	models.ReservationSortStart:    true,
+	models.ReservationSortEnd:      true,
+	models.ReservationSortLastName: true,
+	models.ReservationSortCreated:  true,
+	models.ReservationSortID:       true,
+}
+
+// reservationStatuses are the statuses the reservation search can filter by
+var reservationStatuses = map[string]bool{
+	models.ReservationUpcoming: true,
+	models.ReservationInHouse:  true,
+	models.ReservationDeparted: true,
+}
+
+// propertyToday returns the current day in the timezone of the property. Like the dates of
+// reservations it is midnight UTC
+func propertyToday(property models.Property) time.Time {
+
+	loc, err := time.LoadLocation(property.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// reservationFilter reads the reservation search from the query string. Unknown sorts and
+// statuses are an error, invalid numbers and dates are ignored like in the audit log
+func reservationFilter(r *http.Request, property models.Property) (models.ReservationFilter, error) {
+
+	query := r.URL.Query()
+	filter := models.ReservationFilter{
+		PropertyID: property.ID,
+		Name:       query.Get("name"),
+		Email:      query.Get("email"),
+		Phone:      query.Get("phone"),
+		Status:     query.Get("status"),
+		Today:      propertyToday(property),
+		Sort:       query.Get("sort"),
+		Desc:       query.Get("desc") == "1",
+		Cursor:     query.Get("cursor"),
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = models.ReservationSortStart
+	}
+	if !reservationSorts[filter.Sort] {
+		return filter, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+	if filter.Status != "" && !reservationStatuses[filter.Status] {
+		return filter, fmt.Errorf("unknown status %q", filter.Status)
+	}
+
+	filter.RoomID, _ = strconv.Atoi(query.Get("room_id"))
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	layout := "2006-01-02"
+	dates := []struct {
+		name string
+		date *time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+	}
+	for _, d := range dates {
+		if t, err := time.Parse(layout, query.Get(d.name)); err == nil {
+			*d.date = t
+		}
+	}
+
+	return filter, nil
+}
+
+// searchReservations runs the reservation search of the request. Bad filters and cursors are
+// answered with 400, the returned bool is false if a response has been written
+func (m *Repository) searchReservations(rw http.ResponseWriter, r *http.Request, property models.Property) (models.ReservationFilter, models.ReservationPage, bool) {
+
+	filter, err := reservationFilter(r, property)
+	if err != nil {
+		helpers.Logger(r).Info("invalid reservation search", "error", err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return filter, models.ReservationPage{}, false
+	}
+
+	page, err := m.db(r).SearchReservations(filter)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return filter, page, false
+	}
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return filter, page, false
+	}
+
+	return filter, page, true
+}
+
+// reservationRow is a reservation with its status, as listed by the reservation search
+type reservationRow struct {
+	models.Reservation
+	Status string
+}
+
+// AdminAllReservations lists the reservations of the property the staff user is managing. They can
+// be filtered by guest, room, dates and status, sorted by the columns of the table and are paged
+// with a cursor. The filters are query parameters so that a search can be bookmarked
+func (m *Repository) AdminAllReservations(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	filter, page, ok := m.searchReservations(rw, r, property)
+	if !ok {
+		return
+	}
+
+	var rows []reservationRow
+	for _, res := range page.Reservations {
+		rows = append(rows, reservationRow{Reservation: res, Status: res.Status(filter.Today)})
+	}
+
+	// the headers sort by their column, ascending first and reversed on the next click. A new
+	// sort starts on the first page
+	sortURLs := map[string]string{}
+	for sort := range reservationSorts {
+		q := r.URL.Query()
+		q.Del("cursor")
+		q.Set("sort", sort)
+		q.Del("desc")
+		if sort == filter.Sort && !filter.Desc {
+			q.Set("desc", "1")
+		}
+		sortURLs[sort] = "/admin/reservations-all?" + q.Encode()
+	}
+
+	first := r.URL.Query()
+	first.Del("cursor")
+	firstURL := "/admin/reservations-all?" + first.Encode()
+
+	// the exports take the filters of the search, the room restrictions only the room and dates
+	restrictions := url.Values{}
+	for _, key := range []string{"room_id", "from", "to"} {
+		if v := first.Get(key); v != "" {
+			restrictions.Set(key, v)
+		}
+	}
+
+	var nextURL string
+	if page.NextCursor != "" {
+		q := r.URL.Query()
+		q.Set("cursor", page.NextCursor)
+		nextURL = "/admin/reservations-all?" + q.Encode()
+	}
+
+	layout := "2006-01-02"
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["reservations"] = rows
+	data["filter"] = filter
+	data["from"] = render.FormatDate(filter.From, layout)
+	data["to"] = render.FormatDate(filter.To, layout)
+	data["created_from"] = render.FormatDate(filter.CreatedFrom, layout)
+	data["created_to"] = render.FormatDate(filter.CreatedTo, layout)
+	data["sortURLs"] = sortURLs
+	data["nextURL"] = nextURL
+	data["exportURL"] = "/admin/export/reservations.csv?" + first.Encode()
+	data["restrictionsExportURL"] = "/admin/export/room-restrictions.csv?" + restrictions.Encode()
+	if filter.Cursor != "" {
+		data["firstURL"] = firstURL
+	}
+
+	if err := render.Template(rw, r, "admin-all-reservations.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// reservationJSON is a reservation as returned by AdminReservationsJSON
+type reservationJSON struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone"`
+	RoomID    int       `json:"room_id"`
+	RoomName  string    `json:"room_name"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// reservationsJSONResponse is a page of the reservation search. NextCursor is passed as the
+// cursor parameter to get the next page, it is empty on the last one
+type reservationsJSONResponse struct {
+	Reservations []reservationJSON `json:"reservations"`
+	NextCursor   string            `json:"next_cursor"`
+}
+
+// AdminReservationsJSON is the reservation search of AdminAllReservations as JSON. It takes the
+// same query parameters
+func (m *Repository) AdminReservationsJSON(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusForbidden)
+		return
+	}
+
+	filter, page, ok := m.searchReservations(rw, r, property)
+	if !ok {
+		return
+	}
+
+	layout := "2006-01-02"
+	resp := reservationsJSONResponse{
+		Reservations: []reservationJSON{},
+		NextCursor:   page.NextCursor,
+	}
+	for _, res := range page.Reservations {
+		resp.Reservations = append(resp.Reservations, reservationJSON{
+			ID:        res.ID,
+			FirstName: res.FirstName,
+			LastName:  res.LastName,
+			Email:     res.Email,
+			Phone:     res.Phone,
+			RoomID:    res.RoomID,
+			RoomName:  res.Room.RoomName,
+			StartDate: res.StartDate.Format(layout),
+			EndDate:   res.EndDate.Format(layout),
+			Status:    res.Status(filter.Today),
+			CreatedAt: res.CreatedAt,
+		})
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "  ")
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(out)
+}
+
+// csvExport streams a CSV attachment. The response headers are sent with the header row, which
+// is written with the first record, so that an error before it still gets an error page
+type csvExport struct {
+	rw       http.ResponseWriter
+	filename string
+	header   []string
+	w        *export.Writer
+}
+
+// write writes a record, starting the response first if needed
+func (e *csvExport) write(record []string) error {
+
+	if e.w == nil {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) start() error {
+
+	e.rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	e.rw.Header().Set("Cache-Control", "no-store")
+
+	rc := http.NewResponseController(e.rw)
+	e.w = export.NewWriter(e.rw, func() error {
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	})
+
+	return e.w.WriteHeader(e.header)
+}
+
+// finish ends the export after the rows have been written or err stopped it. Once rows have been
+// sent the status can't change anymore, the error is only logged and the client gets a file cut
+// short
+func (e *csvExport) finish(r *http.Request, err error) {
+
+	if err != nil {
+		if e.w == nil {
+			helpers.ServerError(e.rw, r, err)
+			return
+		}
+		helpers.Logger(r).Error("export interrupted", "file", e.filename, "rows", e.w.Rows(), "error", err)
+		return
+	}
+
+	// an export without rows still has its header row
+	if e.w == nil {
+		if err := e.start(); err != nil {
+			helpers.Logger(r).Error("export interrupted", "file", e.filename, "error", err)
+			return
+		}
+	}
+	if err := e.w.Flush(); err != nil {
+		helpers.Logger(r).Error("export interrupted", "file", e.filename, "rows", e.w.Rows(), "error", err)
+	}
+}
+
+// exportFilename names an export after its content, the property and the day
+func exportFilename(content string, property models.Property, today time.Time) string {
+	return fmt.Sprintf("%s-%s-%s.csv", content, property.Slug, today.Format("2006-01-02"))
+}
+
+// AdminExportReservations exports the reservations of the reservation search, i.e. with the
+// filters and the sort of AdminAllReservations, as CSV. The export isn't paged
+func (m *Repository) AdminExportReservations(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	filter, err := reservationFilter(r, property)
+	if err != nil {
+		helpers.Logger(r).Info("invalid reservation export", "error", err)
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+	filter.Cursor = ""
+
+	e := &csvExport{
+		rw:       rw,
+		filename: exportFilename("reservations", property, filter.Today),
+		header:   export.ReservationHeader,
+	}
+	err = m.db(r).ExportReservations(filter, func(res models.Reservation) error {
+		return e.write(export.Reservation(res, filter.Today))
+	})
+	e.finish(r, err)
+}
+
+// AdminExportRoomRestrictions exports the room restrictions of the property as CSV, i.e. the
+// reservations and the blocks of the rooms. They can be filtered by room and dates like the
+// reservation search
+func (m *Repository) AdminExportRoomRestrictions(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.RoomRestrictionFilter{PropertyID: property.ID}
+
+	// invalid numbers and dates are ignored like in the reservation search
+	filter.RoomID, _ = strconv.Atoi(query.Get("room_id"))
+	layout := "2006-01-02"
+	if from, err := time.Parse(layout, query.Get("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(layout, query.Get("to")); err == nil {
+		filter.To = to
+	}
+
+	e := &csvExport{
+		rw:       rw,
+		filename: exportFilename("room-restrictions", property, propertyToday(property)),
+		header:   export.RoomRestrictionHeader,
+	}
+	err = m.db(r).ExportRoomRestrictions(filter, func(rr models.RoomRestriction) error {
+		return e.write(export.RoomRestriction(rr))
+	})
+	e.finish(r, err)
+}
+
+// maxReportDays limits the dates of a report, two years of room nights are enough to compare
+const maxReportDays = 731
+
+// reportParams reads the dates and the granularity of a report from the query string. Like in
+// the audit log invalid values are ignored: the report defaults to the current month by day
+func reportParams(r *http.Request, property models.Property) (time.Time, time.Time, reports.Granularity) {
+
+	query := r.URL.Query()
+	today := propertyToday(property)
+
+	from := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, -1)
+
+	layout := "2006-01-02"
+	if t, err := time.Parse(layout, query.Get("from")); err == nil {
+		from = t
+	}
+	if t, err := time.Parse(layout, query.Get("to")); err == nil {
+		to = t
+	}
+	if to.Before(from) {
+		from, to = to, from
+	}
+	if last := from.AddDate(0, 0, maxReportDays-1); to.After(last) {
+		to = last
+	}
+
+	g, ok := reports.ParseGranularity(query.Get("by"))
+	if !ok {
+		g = reports.Day
+	}
+
+	return from, to, g
+}
+
+// buildReports computes the report of the request and the one of the same period last year
+func (m *Repository) buildReports(r *http.Request, property models.Property) (reports.Report, reports.Report, error) {
+
+	from, to, g := reportParams(r, property)
+
+	nights, err := m.db(r).RoomNights(property.ID, from, to)
+	if err != nil {
+		return reports.Report{}, reports.Report{}, err
+	}
+	report := reports.Build(nights, from, to, g)
+
+	lastFrom, lastTo := reports.LastYear(from, to)
+	nights, err = m.db(r).RoomNights(property.ID, lastFrom, lastTo)
+	if err != nil {
+		return report, reports.Report{}, err
+	}
+
+	return report, reports.Build(nights, lastFrom, lastTo, g), nil
+}
+
+// AdminReports shows the occupancy and revenue of the property the staff user is managing, by
+// day, week or month and compared to the same period last year
+func (m *Repository) AdminReports(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	report, lastYear, err := m.buildReports(r, property)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	layout := "2006-01-02"
+	params := url.Values{}
+	params.Set("from", report.From.Format(layout))
+	params.Set("to", report.To.Format(layout))
+	params.Set("by", string(report.Granularity))
+
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["report"] = report
+	data["lastYear"] = lastYear
+	data["comparisons"] = reports.Compare(report, lastYear)
+	data["total"] = reports.Totals(report, lastYear)
+	data["from"] = report.From.Format(layout)
+	data["to"] = report.To.Format(layout)
+	data["by"] = string(report.Granularity)
+	data["csvURL"] = "/admin/reports.csv?" + params.Encode()
+
+	if err := render.Template(rw, r, "admin-reports.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// AdminReportsCSV downloads the report of AdminReports as CSV, every period with the property
+// and every room
+func (m *Repository) AdminReportsCSV(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	report, lastYear, err := m.buildReports(r, property)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	layout := "2006-01-02"
+	e := &csvExport{
+		rw:       rw,
+		filename: fmt.Sprintf("report-%s-%s-%s.csv", property.Slug, report.From.Format(layout), report.To.Format(layout)),
+		header:   export.ReportHeader,
+	}
+write:
+	for _, c := range reports.Compare(report, lastYear) {
+		for _, record := range export.Report(c, property.Currency) {
+			if err = e.write(record); err != nil {
+				break write
+			}
+		}
+	}
+	e.finish(r, err)
+}
+
+// maxImportSize limits the files uploaded to AdminPostImport
+const maxImportSize = 10 << 20
+
+// AdminImport shows the form to upload a CSV file of reservations and owner blocks
+func (m *Repository) AdminImport(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.renderImport(rw, r, property, nil, "")
+}
+
+// AdminPostImport imports the uploaded CSV file into the property the staff user is managing. The
+// check button makes a dry run which only reports what the import would do, the import button
+// imports the valid rows. The rows with errors are listed in both cases
+func (m *Repository) AdminPostImport(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		m.renderImport(rw, r, property, nil, "Choose a CSV file to import")
+		return
+	}
+	defer file.Close()
+
+	commit := r.PostFormValue("action") == "import"
+
+	result, err := importer.Import(m.db(r), helpers.Actor(r), property.ID, file, commit)
+	if errors.Is(err, importer.ErrInvalidFile) {
+		m.renderImport(rw, r, property, nil, fmt.Sprintf("%s: %s", header.Filename, err))
+		return
+	}
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	helpers.Logger(r).Info("import", "file", header.Filename, "commit", commit, "rows", result.Rows,
+		"reservations", result.Reservations, "blocks", result.Blocks, "errors", len(result.Errors))
+
+	m.renderImport(rw, r, property, &result, "")
+}
+
+// renderImport shows the import page with the result of an import, if any, or the error of a file
+// which couldn't be read
+func (m *Repository) renderImport(rw http.ResponseWriter, r *http.Request, property models.Property, result *models.ImportResult, fileError string) {
+
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["columns"] = importer.Columns
+	data["maxRows"] = importer.MaxRows
+	data["fileError"] = fileError
+	if result != nil {
+		data["result"] = result
+	}
+
+	if err := render.Template(rw, r, "admin-import.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// AdminAuditLog shows the audit log of the property the staff user is managing. The events can be
+// filtered by entity, e.g. ?entity_type=reservation&entity_id=5 gives the history of a
+// reservation, by action, by the user who made them and by date
+func (m *Repository) AdminAuditLog(rw http.ResponseWriter, r *http.Request) {
+
+	property, _, err := m.adminProperty(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to any property")
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		PropertyID: property.ID,
+		EntityType: query.Get("entity_type"),
+		Action:     query.Get("action"),
+	}
+
+	// invalid numbers and dates are ignored, the form shows the filter which was applied
+	filter.EntityID, _ = strconv.Atoi(query.Get("entity_id"))
+	filter.ActorUserID, _ = strconv.Atoi(query.Get("user_id"))
+
+	layout := "2006-01-02"
+	from, err := time.Parse(layout, query.Get("from"))
+	if err == nil {
+		filter.From = from
+	}
+	to, err := time.Parse(layout, query.Get("to"))
+	if err == nil {
+		// including the whole day
+		filter.To = to.AddDate(0, 0, 1)
+	}
+
+	events, err := m.db(r).AuditEvents(filter)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["property"] = property
+	data["events"] = events
+	data["filter"] = filter
+	data["from"] = render.FormatDate(filter.From, layout)
+	data["to"] = render.FormatDate(to, layout)
+
+	if err := render.Template(rw, r, "admin-audit.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// roleRequirement is a role and whether the two factor policy requires a second factor of it
+type roleRequirement struct {
+	rbac.Role
+	Required bool
+}
+
+// AdminSecurity shows the two factor policy: the roles which must log in with a second factor
+func (m *Repository) AdminSecurity(rw http.ResponseWriter, r *http.Request) {
+
+	levels, err := m.db(r).TwoFactorPolicy()
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	required := map[int]bool{}
+	for _, level := range levels {
+		required[level] = true
+	}
+
+	var roles []roleRequirement
+	for _, role := range rbac.Roles {
+		roles = append(roles, roleRequirement{Role: role, Required: required[role.AccessLevel]})
+	}
+
+	data := make(map[string]interface{})
+	data["roles"] = roles
+
+	if err := render.Template(rw, r, "admin-security.page.html", &models.TemplateData{
+		Data: data,
+	}); err != nil {
+		helpers.ServerError(rw, r, err)
+	}
+}
+
+// AdminPostSecurity saves the two factor policy. Users of the roles ticked have to set up a second
+// factor before they can use the admin area again
+func (m *Repository) AdminPostSecurity(rw http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	// only known access levels are stored
+	ticked := map[string]bool{}
+	for _, v := range r.PostForm["two_factor_required"] {
+		ticked[v] = true
+	}
+	levels := []int{}
+	for _, role := range rbac.Roles {
+		if ticked[strconv.Itoa(role.AccessLevel)] {
+			levels = append(levels, role.AccessLevel)
+		}
+	}
+
+	if err := m.db(r).SetTwoFactorPolicy(helpers.Actor(r), levels); err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "The two-factor policy has been saved")
+	http.Redirect(rw, r, "/admin/security", http.StatusSeeOther)
+}
+
+// AdminSwitchProperty changes the property the staff user is managing
+func (m *Repository) AdminSwitchProperty(rw http.ResponseWriter, r *http.Request) {
+
+	propertyID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(rw, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	ok, err := m.db(r).UserHasProperty(userID, propertyID)
+	if err != nil {
+		helpers.ServerError(rw, r, err)
+		return
+	}
+
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "You don't have access to that property")
+		http.Redirect(rw, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "admin_property_id", propertyID)
+	http.Redirect(rw, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// maxCSPReportSize limits the body of a CSP violation report
+const maxCSPReportSize = 64 << 10
+
+// cspReport is a violation report as sent to report-uri with Content-Type application/csp-report
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is one report as sent to report-to with Content-Type application/reports+json
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+	} `json:"body"`
+}
+
+// CSPReport logs the Content Security Policy violations reported by browsers. Both the report-uri
+// format and the Reporting API format are accepted
+func (m *Repository) CSPReport(rw http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxCSPReportSize))
+	if err != nil {
+		helpers.ClientError(rw, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logger := helpers.Logger(r)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			helpers.ClientError(rw, r, http.StatusBadRequest)
+			return
+		}
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			logger.Warn("csp violation",
+				"document_uri", report.Body.DocumentURL,
+				"violated_directive", report.Body.EffectiveDirective,
+				"blocked_uri", report.Body.BlockedURL,
+				"source_file", report.Body.SourceFile,
+				"line_number", report.Body.LineNumber,
+			)
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var report cspReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		helpers.ClientError(rw, r, http.StatusBadRequest)
+		return
+	}
+
+	directive := report.Report.EffectiveDirective
+	if directive == "" {
+		directive = report.Report.ViolatedDirective
+	}
+	logger.Warn("csp violation",
+		"document_uri", report.Report.DocumentURI,
+		"violated_directive", directive,
+		"blocked_uri", report.Report.BlockedURI,
+		"source_file", report.Report.SourceFile,
+		"line_number", report.Report.LineNumber,
+	)
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// NotFound shows the 404 page for unknown routes
+func (m *Repository) NotFound(rw http.ResponseWriter, r *http.Request) {
+
+	helpers.ClientError(rw, r, http.StatusNotFound)
+}
+
+// MethodNotAllowed shows the 405 page when a route exists but not for the request method
+func (m *Repository) MethodNotAllowed(rw http.ResponseWriter, r *http.Request) {
+
+	helpers.ClientError(rw, r, http.StatusMethodNotAllowed)
+}
@@ -1,17 +1,29 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/ratelimit"
+	"github.com/prayagsingh/bookings/internal/reports"
+	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+	"github.com/prayagsingh/bookings/internal/requestctx"
+	"github.com/prayagsingh/bookings/internal/tokens"
+	"github.com/prayagsingh/bookings/internal/totp"
 )
 
 // for sending data for POST request
@@ -32,6 +44,13 @@ var theTests = []struct {
 	{"suites", "/suites", "GET", http.StatusOK},
 	{"search-availability", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"login", "/user/login", "GET", http.StatusOK},
+	{"logout", "/user/logout", "GET", http.StatusOK},
+	{"forgot-password", "/user/forgot-password", "GET", http.StatusOK},
+	{"guest-login", "/guest/login", "GET", http.StatusOK},
+	{"guest-register", "/guest/register", "GET", http.StatusOK},
+	{"not-found", "/green-eggs-and-ham", "GET", http.StatusNotFound},
+	{"method-not-allowed", "/search-availability-json", "GET", http.StatusMethodNotAllowed},
 	//{"make-reservation", "/make-reservation", "GET", []postData{}, http.StatusOK},
 
 	// {"post-search-avail", "/search-availability", "POST", []postData{
@@ -820,6 +839,1332 @@ func TestRepository_BookRoom(t *testing.T) {
 	}
 }
 
+var loginTests = []struct {
+	name               string
+	email              string
+	password           string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid-credentials", "admin@here.com", "password", http.StatusSeeOther, "/admin/dashboard"},
+	{"invalid-credentials", "jack@nimble.com", "password", http.StatusSeeOther, "/user/login"},
+	{"invalid-data", "j", "", http.StatusOK, ""},
+}
+
+// foreign is the id of a room of another property than the one of the request
+var foreign = strconv.Itoa(dbrepo.TestForeignRoomID)
+
+var foreignRoomTests = []struct {
+	name        string
+	method      string
+	url         string
+	body        url.Values
+	reservation bool
+	handler     func(*Repository, http.ResponseWriter, *http.Request)
+}{
+	{"book-room", "GET", "/book-room?id=" + foreign + "&s=2050-01-01&e=2050-01-02", nil, false, (*Repository).BookRoom},
+	{"choose-room", "GET", "/choose-room/" + foreign, nil, true, (*Repository).ChooseRoom},
+	{"availability-json", "POST", "/search-availability-json", url.Values{"start": {"2040-01-01"}, "end": {"2040-01-02"}, "room_id": {foreign}}, false, (*Repository).AvailabilityJSON},
+	{"make-reservation", "GET", "/make-reservation", nil, true, (*Repository).Reservations},
+	{"post-reservation", "POST", "/make-reservation", url.Values{"first_name": {"Prayag"}, "last_name": {"Singh"}, "email": {"ps@email.com"}}, true, (*Repository).PostReservations},
+}
+
+// TestRepository_ForeignRoom checks that a room of another property can neither be looked up nor
+// booked, whether its id comes from the URL, the form or the session
+func TestRepository_ForeignRoom(t *testing.T) {
+
+	for _, e := range foreignRoomTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body.Encode()))
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		if e.reservation {
+			// the session of the choose-room case still holds a room of the property
+			roomID := dbrepo.TestForeignRoomID
+			if e.name == "choose-room" {
+				roomID = 1
+			}
+			session.Put(ctx, "reservation", models.Reservation{RoomID: roomID})
+		}
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, http.StatusNotFound, rr.Code)
+		}
+		if e.reservation {
+			if res, _ := session.Get(ctx, "reservation").(models.Reservation); e.name == "choose-room" && res.RoomID != 1 {
+				t.Errorf("failed %s: expected the room of the session to be kept but got %d", e.name, res.RoomID)
+			}
+		}
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+
+	for _, e := range loginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_PostShowLoginLockout(t *testing.T) {
+
+	Repo.LoginLockout = ratelimit.NewLockout(3, time.Minute, time.Minute, time.Hour)
+	defer func() { Repo.LoginLockout = ratelimit.DefaultLockout() }()
+
+	post := func(password, remoteAddr string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("email", "admin@here.com")
+		postedData.Add("password", password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// first and second failure redirect back to the login page
+	for i := 0; i < 2; i++ {
+		if rr := post("wrong", "192.0.2.1:1234"); rr.Code != http.StatusSeeOther {
+			t.Fatalf("failure %d: expected code %d, but got %d", i+1, http.StatusSeeOther, rr.Code)
+		}
+	}
+
+	// the third failure locks the email address out
+	rr := post("wrong", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected code %d after 3 failures, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60, but got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// even the right password is refused while locked out
+	rr = post("password", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected code %d while locked out, but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	// but only for the client which failed, others can still log in
+	rr = post("password", "198.51.100.7:1234")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") == "/user/login" {
+		t.Errorf("expected a login from another address, but got code %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_PostShowLoginSessionVersion(t *testing.T) {
+
+	postedData := url.Values{}
+	postedData.Add("email", "admin@here.com")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostShowLogin)
+	handler.ServeHTTP(rr, req)
+
+	// checked by the Auth middleware against the version of the user
+	if got := session.GetInt(ctx, "session_version"); got != 1 {
+		t.Errorf("expected session version 1 in the session, but got %d", got)
+	}
+}
+
+var forgotPasswordTests = []struct {
+	name               string
+	email              string
+	expectedStatusCode int
+	expectedMails      int
+}{
+	{"known-email", "admin@here.com", http.StatusSeeOther, 1},
+	{"unknown-email", "jack@nimble.com", http.StatusSeeOther, 0},
+	{"invalid-email", "jack", http.StatusOK, 0},
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+
+	for _, e := range forgotPasswordTests {
+		mail.sent = nil
+
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if len(mail.sent) != e.expectedMails {
+			t.Errorf("failed %s: expected %d mails, but got %d", e.name, e.expectedMails, len(mail.sent))
+			continue
+		}
+
+		// known and unknown addresses get the same answer
+		if rr.Code == http.StatusSeeOther {
+			if flash := session.GetString(ctx, "flash"); flash != forgotPasswordSent {
+				t.Errorf("failed %s: expected flash %q, but got %q", e.name, forgotPasswordSent, flash)
+			}
+		}
+
+		if e.expectedMails == 0 {
+			continue
+		}
+
+		// the link in the mail must be accepted by the reset form
+		msg := mail.sent[0]
+		if msg.To != e.email {
+			t.Errorf("failed %s: expected mail to %s, but got %s", e.name, e.email, msg.To)
+		}
+		i := strings.Index(msg.Body, app.BaseURL+"/user/reset-password?")
+		if i < 0 {
+			t.Errorf("failed %s: no reset link in %q", e.name, msg.Body)
+			continue
+		}
+		link, err := url.Parse(strings.Fields(msg.Body[i:])[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := Repo.resetToken(link.Query()); !ok {
+			t.Errorf("failed %s: the signature of the link is rejected", e.name)
+		}
+	}
+}
+
+// resetLink returns the query of a password reset link for token, with a forged signature if asked
+func resetLink(token string, forged bool) url.Values {
+
+	sig := tokens.Sign(app.SecretKey, token)
+	if forged {
+		sig = "forged"
+	}
+	return url.Values{"token": {token}, "sig": {sig}}
+}
+
+var showResetPasswordTests = []struct {
+	name               string
+	token              string
+	forged             bool
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid-token", dbrepo.TestResetToken, false, http.StatusOK, ""},
+	{"unknown-token", "unknown", false, http.StatusSeeOther, "/user/forgot-password"},
+	{"forged-signature", dbrepo.TestResetToken, true, http.StatusSeeOther, "/user/forgot-password"},
+	{"missing-token", "", false, http.StatusSeeOther, "/user/forgot-password"},
+}
+
+func TestRepository_ShowResetPassword(t *testing.T) {
+
+	for _, e := range showResetPasswordTests {
+		req, _ := http.NewRequest("GET", "/user/reset-password?"+resetLink(e.token, e.forged).Encode(), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ShowResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var postResetPasswordTests = []struct {
+	name               string
+	token              string
+	forged             bool
+	password           string
+	confirm            string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid", dbrepo.TestResetToken, false, "Correct-Horse-7", "Correct-Horse-7", http.StatusSeeOther, "/user/login"},
+	{"weak-password", dbrepo.TestResetToken, false, "password", "password", http.StatusOK, ""},
+	{"contains-email", dbrepo.TestResetToken, false, "Admin-1234567", "Admin-1234567", http.StatusOK, ""},
+	{"mismatch", dbrepo.TestResetToken, false, "Correct-Horse-7", "Correct-Horse-8", http.StatusOK, ""},
+	{"forged-signature", dbrepo.TestResetToken, true, "Correct-Horse-7", "Correct-Horse-7", http.StatusSeeOther, "/user/forgot-password"},
+	{"unknown-token", "unknown", false, "Correct-Horse-7", "Correct-Horse-7", http.StatusSeeOther, "/user/forgot-password"},
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+
+	for _, e := range postResetPasswordTests {
+		postedData := resetLink(e.token, e.forged)
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.confirm)
+
+		req, _ := http.NewRequest("POST", "/user/reset-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_PostShowLoginTwoFactor(t *testing.T) {
+
+	postedData := url.Values{}
+	postedData.Add("email", "manager@here.com")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostShowLogin)
+	handler.ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/user/two-factor" {
+		t.Errorf("expected a redirect to /user/two-factor, but got %q", loc)
+	}
+
+	// the password alone doesn't log the user in
+	if session.Exists(ctx, "user_id") {
+		t.Error("expected no user in the session before the second factor")
+	}
+	if got := session.GetInt(ctx, "two_factor_user_id"); got != 3 {
+		t.Errorf("expected user 3 waiting for the second factor, but got %d", got)
+	}
+}
+
+// currentCode returns the code of the authenticator app of test user 3
+func currentCode(t *testing.T) string {
+
+	code, err := totp.Code(dbrepo.TestTOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+var twoFactorTests = []struct {
+	name             string
+	code             string
+	pendingUser      int
+	startedAgo       time.Duration
+	expectedLocation string
+	expectedUserID   int
+}{
+	{"valid-code", "", 3, time.Minute, "/admin/dashboard", 3},
+	{"recovery-code", strings.ToUpper(strings.ReplaceAll(dbrepo.TestRecoveryCode, "-", " ")), 3, time.Minute, "/admin/dashboard", 3},
+	{"wrong-code", "000000", 3, time.Minute, "/user/two-factor", 0},
+	{"used-recovery-code", "aaaa-bbbb-cccc", 3, time.Minute, "/user/two-factor", 0},
+	{"expired", "", 3, 10 * time.Minute, "/user/login", 0},
+	{"no-password", "", 0, time.Minute, "/user/login", 0},
+	{"no-second-factor", "", 1, time.Minute, "/user/login", 0},
+}
+
+func TestRepository_PostTwoFactor(t *testing.T) {
+
+	for _, e := range twoFactorTests {
+		code := e.code
+		if code == "" {
+			code = currentCode(t)
+		}
+
+		postedData := url.Values{}
+		postedData.Add("code", code)
+
+		req, _ := http.NewRequest("POST", "/user/two-factor", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.pendingUser != 0 {
+			session.Put(ctx, "two_factor_user_id", e.pendingUser)
+			session.Put(ctx, "two_factor_started", int(time.Now().Add(-e.startedAgo).Unix()))
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostTwoFactor)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %q", e.name, e.expectedLocation, loc)
+		}
+
+		if got := session.GetInt(ctx, "user_id"); got != e.expectedUserID {
+			t.Errorf("failed %s: expected user %d in the session, but got %d", e.name, e.expectedUserID, got)
+		}
+	}
+}
+
+func TestRepository_TwoFactorSetup(t *testing.T) {
+
+	// user 1 has no second factor yet
+	user, _ := Repo.DB.GetUserByID(1)
+
+	req, _ := http.NewRequest("GET", "/user/two-factor/setup", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(requestctx.WithUser(ctx, user))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ShowTwoFactorSetup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "otpauth://totp/Bookings:admin@here.com?") {
+		t.Error("expected the provisioning URI on the page")
+	}
+
+	secret := session.GetString(ctx, "totp_setup_secret")
+	if secret == "" {
+		t.Fatal("expected the new secret in the session")
+	}
+
+	post := func(code string) *httptest.ResponseRecorder {
+		postedData := url.Values{}
+		postedData.Add("code", code)
+
+		req, _ := http.NewRequest("POST", "/user/two-factor/setup", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(requestctx.WithUser(ctx, user))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostTwoFactorSetup).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a wrong code shows the form again, the secret stays the same
+	rr = post("000000")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The code doesn&#39;t match") {
+		t.Errorf("expected the form with an error for a wrong code, got code %d", rr.Code)
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = post(code)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if n := strings.Count(rr.Body.String(), "<li><code>"); n != recoveryCodeCount {
+		t.Errorf("expected %d recovery codes on the page, but got %d", recoveryCodeCount, n)
+	}
+	if session.Exists(ctx, "totp_setup_secret") {
+		t.Error("expected the secret to be removed from the session")
+	}
+}
+
+var twoFactorDisableTests = []struct {
+	name          string
+	required      bool
+	code          string
+	expectedFlash string
+	expectedError string
+}{
+	{"valid", false, "", "Two-factor authentication is off", ""},
+	{"required", true, "", "", "Two-factor authentication is required for your role"},
+	{"wrong-code", false, "000000", "", "Invalid code"},
+}
+
+func TestRepository_PostTwoFactorDisable(t *testing.T) {
+
+	for _, e := range twoFactorDisableTests {
+		user, _ := Repo.DB.GetUserByID(3)
+		user.TwoFactorRequired = e.required
+
+		code := e.code
+		if code == "" {
+			code = currentCode(t)
+		}
+		postedData := url.Values{}
+		postedData.Add("code", code)
+
+		req, _ := http.NewRequest("POST", "/user/two-factor/disable", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(requestctx.WithUser(ctx, user))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostTwoFactorDisable).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, got)
+		}
+		if got := session.GetString(ctx, "error"); got != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, got)
+		}
+	}
+}
+
+func TestRepository_AdminSecurity(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/admin/security", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminSecurity).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// the test repository requires a second factor of managers only
+	body := rr.Body.String()
+	if n := strings.Count(body, "checked"); n != 1 {
+		t.Errorf("expected one role ticked, but got %d", n)
+	}
+	if !strings.Contains(body, `id="two_factor_required_2" checked`) {
+		t.Error("expected managers to be ticked")
+	}
+
+	postedData := url.Values{}
+	postedData.Add("two_factor_required", "2")
+	postedData.Add("two_factor_required", "4")
+	req, _ = http.NewRequest("POST", "/admin/security", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostSecurity).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/admin/security" {
+		t.Errorf("expected a redirect to /admin/security, but got %q", loc)
+	}
+}
+
+func TestRepository_ReservationGuestProfile(t *testing.T) {
+
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "Villas",
+		},
+	}
+
+	// the form of a logged in guest is filled in from the profile
+	request, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(request)
+	request = request.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "guest_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.Reservations)
+	handler.ServeHTTP(rr, request)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `value="john@smith.com"`) {
+		t.Error("expected the email address of the guest in the form")
+	}
+
+	// the reservation posted by the guest belongs to the account
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "555-0100")
+
+	request, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostReservations)
+	handler.ServeHTTP(rr, request)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if res, _ := session.Get(ctx, "reservation").(models.Reservation); res.GuestID != 1 {
+		t.Errorf("expected the reservation of guest 1, but got guest %d", res.GuestID)
+	}
+}
+
+var guestRegisterTests = []struct {
+	name               string
+	email              string
+	password           string
+	confirm            string
+	booking            bool
+	expectedStatusCode int
+	expectedLocation   string
+	expectedGuestID    int
+}{
+	{"valid", "new@guest.com", "Correct-Horse-7", "Correct-Horse-7", false, http.StatusSeeOther, "/guest/bookings", 2},
+	{"while-booking", "new@guest.com", "Correct-Horse-7", "Correct-Horse-7", true, http.StatusSeeOther, "/make-reservation", 2},
+	{"registered-email", "john@smith.com", "Correct-Horse-7", "Correct-Horse-7", false, http.StatusOK, "", 0},
+	{"weak-password", "new@guest.com", "password", "password", false, http.StatusOK, "", 0},
+	{"mismatch", "new@guest.com", "Correct-Horse-7", "Correct-Horse-8", false, http.StatusOK, "", 0},
+	{"invalid-email", "new", "Correct-Horse-7", "Correct-Horse-7", false, http.StatusOK, "", 0},
+}
+
+func TestRepository_PostGuestRegister(t *testing.T) {
+
+	for _, e := range guestRegisterTests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "New")
+		postedData.Add("last_name", "Guest")
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.confirm)
+
+		req, _ := http.NewRequest("POST", "/guest/register", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.booking {
+			session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostGuestRegister)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if got := session.GetInt(ctx, "guest_id"); got != e.expectedGuestID {
+			t.Errorf("failed %s: expected guest %d in the session, but got %d", e.name, e.expectedGuestID, got)
+		}
+	}
+}
+
+var guestLoginTests = []struct {
+	name               string
+	email              string
+	password           string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedGuestID    int
+}{
+	{"valid-credentials", "john@smith.com", "password", http.StatusSeeOther, "/guest/bookings", 1},
+	{"staff-credentials", "admin@here.com", "password", http.StatusSeeOther, "/guest/login", 0},
+	{"invalid-credentials", "john@smith.com", "wrong", http.StatusSeeOther, "/guest/login", 0},
+	{"invalid-data", "j", "", http.StatusOK, "", 0},
+}
+
+func TestRepository_PostGuestLogin(t *testing.T) {
+
+	for _, e := range guestLoginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/guest/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostGuestLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if got := session.GetInt(ctx, "guest_id"); got != e.expectedGuestID {
+			t.Errorf("failed %s: expected guest %d in the session, but got %d", e.name, e.expectedGuestID, got)
+		}
+
+		// staff users can't use their account on the public site
+		if got := session.GetInt(ctx, "user_id"); got != 0 {
+			t.Errorf("failed %s: expected no staff user in the session, but got %d", e.name, got)
+		}
+	}
+}
+
+func TestRepository_GuestBookings(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/guest/bookings", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.GuestBookings)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// reservation 2 is upcoming, reservation 1 is in the past
+	body := rr.Body.String()
+	upcoming, past := strings.Index(body, "Upcoming stays"), strings.Index(body, "Past stays")
+	if i := strings.Index(body, "#2"); i < upcoming || i > past {
+		t.Error("expected reservation 2 among the upcoming stays")
+	}
+	if i := strings.Index(body, "#1"); i < past {
+		t.Error("expected reservation 1 among the past stays")
+	}
+
+	// the account has been deleted
+	req, _ = http.NewRequest("GET", "/guest/bookings", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_id", 99)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d for a deleted account, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if session.Exists(ctx, "guest_id") {
+		t.Error("expected the deleted guest to be logged out")
+	}
+}
+
+func TestRepository_GuestLogout(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/guest/logout", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_id", 1)
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.GuestLogout)
+	handler.ServeHTTP(rr, req)
+
+	if session.Exists(ctx, "guest_id") {
+		t.Error("expected the guest to be logged out")
+	}
+	if !session.Exists(ctx, "reservation") {
+		t.Error("expected the reservation in progress to be kept")
+	}
+}
+
+func TestRepository_AdminDashboard(t *testing.T) {
+
+	/*****************************************
+	// first case -- user has access to a property
+	*****************************************/
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminDashboard)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminDashboard handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	/*****************************************
+	// second case -- user has no access to any property
+	*****************************************/
+	req, _ = http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 2)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminDashboard handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_AdminAllReservations(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/admin/reservations-all", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminAllReservations handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+var reservationSearchTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+	expectedContains   string
+}{
+	{"first-page", "", http.StatusOK, "Next page"},
+	{"status-shown", "", http.StatusOK, "In house"},
+	{"filters-kept", "?name=smith&status=in_house", http.StatusOK, `value="smith"`},
+	{"sort-reversed", "?sort=last_name", http.StatusOK, "desc=1&amp;sort=last_name"},
+	{"second-page", "?cursor=" + dbrepo.TestCursor, http.StatusOK, "First page"},
+	{"unknown-sort", "?sort=phone", http.StatusBadRequest, ""},
+	{"unknown-status", "?status=cancelled", http.StatusBadRequest, ""},
+	{"invalid-cursor", "?cursor=fish", http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminAllReservationsSearch(t *testing.T) {
+
+	for _, e := range reservationSearchTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-all"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedContains) {
+			t.Errorf("failed %s: expected %q in the page", e.name, e.expectedContains)
+		}
+	}
+}
+
+var reservationsJSONTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+	expectedIDs        []int
+	expectedCursor     string
+}{
+	{"first-page", "", http.StatusOK, []int{1}, dbrepo.TestCursor},
+	{"last-page", "?cursor=" + dbrepo.TestCursor, http.StatusOK, []int{2}, ""},
+	{"invalid-cursor", "?cursor=fish", http.StatusBadRequest, nil, ""},
+	{"unknown-sort", "?sort=phone", http.StatusBadRequest, nil, ""},
+}
+
+func TestRepository_AdminReservationsJSON(t *testing.T) {
+
+	for _, e := range reservationsJSONTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-json"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservationsJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var resp reservationsJSONResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Errorf("failed %s: can't parse JSON: %s", e.name, err)
+			continue
+		}
+
+		var ids []int
+		for _, res := range resp.Reservations {
+			ids = append(ids, res.ID)
+			if res.Status == "" {
+				t.Errorf("failed %s: expected a status for reservation %d", e.name, res.ID)
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(e.expectedIDs) {
+			t.Errorf("failed %s: expected reservations %v but got %v", e.name, e.expectedIDs, ids)
+		}
+		if resp.NextCursor != e.expectedCursor {
+			t.Errorf("failed %s: expected cursor %q but got %q", e.name, e.expectedCursor, resp.NextCursor)
+		}
+	}
+}
+
+var propertyTodayTests = []struct {
+	timezone string
+	offset   time.Duration
+}{
+	{"UTC", 0},
+	{"Pacific/Kiritimati", 14 * time.Hour},
+	{"Pacific/Pago_Pago", -11 * time.Hour},
+	{"Not/AZone", 0},
+}
+
+func TestPropertyToday(t *testing.T) {
+
+	for _, e := range propertyTodayTests {
+		// tzdata may be missing, the fixed zone gives the same day
+		now := time.Now().In(time.FixedZone(e.timezone, int(e.offset.Seconds())))
+		expected := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+		got := propertyToday(models.Property{Timezone: e.timezone})
+		if _, err := time.LoadLocation(e.timezone); err != nil && e.offset != 0 {
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("%s: expected %s but got %s", e.timezone, expected, got)
+		}
+	}
+}
+
+var exportTests = []struct {
+	name               string
+	url                string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedFilename   string
+	expectedRows       int
+}{
+	{"reservations", "/admin/export/reservations.csv?status=in_house", (*Repository).AdminExportReservations, http.StatusOK, "reservations-aisa-fort-", 2},
+	{"reservations-unknown-sort", "/admin/export/reservations.csv?sort=phone", (*Repository).AdminExportReservations, http.StatusBadRequest, "", 0},
+	{"room-restrictions", "/admin/export/room-restrictions.csv?room_id=1&from=fish", (*Repository).AdminExportRoomRestrictions, http.StatusOK, "room-restrictions-aisa-fort-", 1},
+}
+
+func TestRepository_AdminExports(t *testing.T) {
+
+	for _, e := range exportTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+			t.Errorf("failed %s: expected CSV but got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+		if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, e.expectedFilename) {
+			t.Errorf("failed %s: expected the file %s... but got %s", e.name, e.expectedFilename, cd)
+		}
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Errorf("failed %s: can't parse CSV: %s", e.name, err)
+			continue
+		}
+		if len(records) != e.expectedRows+1 {
+			t.Errorf("failed %s: expected a header and %d rows but got %d records", e.name, e.expectedRows, len(records))
+		}
+	}
+}
+
+var importTests = []struct {
+	name             string
+	file             string
+	action           string
+	expectedContains string
+}{
+	{"dry-run", "room_id,start_date,end_date,first_name,last_name,email\n1,2021-10-01,2021-10-03,John,Smith,john@smith.com\n", "check", "Dry run: 1 reservations"},
+	{"import", "type,room_id,start_date,end_date\nblock,1,2021-10-01,2021-10-03\n", "import", "Imported 0 reservations and 1 owner blocks"},
+	{"row-errors", "room_id,start_date,end_date,first_name,last_name,email\n2,2021-10-01,2021-10-03,John,Smith,john@smith.com\n", "check", "the room is booked"},
+	{"invalid-file", "first_name\nJohn\n", "check", "missing the columns"},
+	{"no-file", "", "check", "Choose a CSV file"},
+}
+
+func TestRepository_AdminPostImport(t *testing.T) {
+
+	for _, e := range importTests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("action", e.action)
+		if e.file != "" {
+			fw, _ := mw.CreateFormFile("file", "bookings.csv")
+			_, _ = fw.Write([]byte(e.file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostImport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, http.StatusOK, rr.Code)
+			continue
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedContains) {
+			t.Errorf("failed %s: expected %q in the page", e.name, e.expectedContains)
+		}
+	}
+}
+
+func TestRepository_AdminImport(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/admin/import", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminImport)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminImport handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+var reportParamsTests = []struct {
+	name         string
+	query        string
+	expectedFrom string
+	expectedTo   string
+	expectedBy   reports.Granularity
+}{
+	{"dates", "?from=2021-10-01&to=2021-12-31&by=month", "2021-10-01", "2021-12-31", reports.Month},
+	{"swapped", "?from=2021-12-31&to=2021-10-01&by=week", "2021-10-01", "2021-12-31", reports.Week},
+	{"unknown-granularity", "?from=2021-10-01&to=2021-10-03&by=year", "2021-10-01", "2021-10-03", reports.Day},
+	{"too-long", "?from=2020-01-01&to=2025-01-01", "2020-01-01", "2021-12-31", reports.Day},
+}
+
+func TestReportParams(t *testing.T) {
+
+	for _, e := range reportParamsTests {
+		req, _ := http.NewRequest("GET", "/admin/reports"+e.query, nil)
+
+		from, to, g := reportParams(req, models.Property{Timezone: "UTC"})
+		if got := from.Format("2006-01-02"); got != e.expectedFrom {
+			t.Errorf("failed %s: expected from %s but got %s", e.name, e.expectedFrom, got)
+		}
+		if got := to.Format("2006-01-02"); got != e.expectedTo {
+			t.Errorf("failed %s: expected to %s but got %s", e.name, e.expectedTo, got)
+		}
+		if g != e.expectedBy {
+			t.Errorf("failed %s: expected %s but got %s", e.name, e.expectedBy, g)
+		}
+	}
+
+	// invalid dates give the current month
+	req, _ := http.NewRequest("GET", "/admin/reports?from=fish", nil)
+	from, to, _ := reportParams(req, models.Property{Timezone: "UTC"})
+	if from.Day() != 1 || to.AddDate(0, 0, 1).Day() != 1 || from.Month() != to.Month() {
+		t.Errorf("expected the current month but got %s to %s", from, to)
+	}
+}
+
+var reportTests = []struct {
+	name             string
+	query            string
+	expectedContains []string
+}{
+	// room 1 is sold every night, room 2 is blocked on the first. Last year there was only room 1
+	{"by-day", "?from=2021-10-01&to=2021-10-03", []string{"60.0%", "$100.00", "$60.00", "Fri 01 Oct 2021", "-40.0 pts"}},
+	{"by-month", "?from=2021-10-01&to=2021-11-30&by=month", []string{"October 2021", "November 2021"}},
+}
+
+func TestRepository_AdminReports(t *testing.T) {
+
+	for _, e := range reportTests {
+		req, _ := http.NewRequest("GET", "/admin/reports"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReports)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d but got %d", e.name, http.StatusOK, rr.Code)
+			continue
+		}
+		for _, s := range e.expectedContains {
+			if !strings.Contains(rr.Body.String(), s) {
+				t.Errorf("failed %s: expected %q in the page", e.name, s)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminReportsCSV(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/admin/reports.csv?from=2021-10-01&to=2021-10-10&by=week", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminReportsCSV)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d but got %d", http.StatusOK, rr.Code)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, "report-aisa-fort-2021-10-01-2021-10-10.csv") {
+		t.Errorf("unexpected file name %s", cd)
+	}
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a header, then the property and its two rooms for the weeks of 1-3 and 4-10 October
+	if len(records) != 7 {
+		t.Fatalf("expected 7 records but got %d", len(records))
+	}
+	first := records[1]
+	expected := []string{"2021-10-01", "2021-10-03", "", "All rooms", "5", "3", "0.6000", "100.00", "60.00", "300.00"}
+	for i, v := range expected {
+		if first[i] != v {
+			t.Errorf("column %s: expected %q but got %q", records[0][i], v, first[i])
+		}
+	}
+	if records[2][2] != "1" || records[3][3] != "Major's Suite" {
+		t.Errorf("expected the rooms after the property but got %v and %v", records[2], records[3])
+	}
+}
+
+var auditLogTests = []struct {
+	name             string
+	query            string
+	expectedContains string
+}{
+	{"all-events", "", "reservation #1"},
+	{"reservation-history", "?entity_type=reservation&entity_id=1", "first_name"},
+	{"other-reservation", "?entity_type=reservation&entity_id=2", "No events"},
+	{"invalid-filters-ignored", "?entity_id=fish&from=yesterday", "reservation #1"},
+	{"user-of-property", "?entity_type=user&entity_id=1", "two_factor"},
+	{"user-without-property", "?entity_type=user&entity_id=2", "No events"},
+}
+
+func TestRepository_AdminAuditLog(t *testing.T) {
+
+	for _, e := range auditLogTests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminAuditLog)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedContains) {
+			t.Errorf("failed %s: expected the page to contain %q", e.name, e.expectedContains)
+		}
+	}
+}
+
+var switchPropertyTests = []struct {
+	name               string
+	propertyID         string
+	expectedPropertyID int
+	expectError        bool
+}{
+	{"granted-property", "1", 1, false},
+	{"other-property", "2", 0, true},
+	{"malformed-id", "fish", 0, true},
+}
+
+func TestRepository_AdminSwitchProperty(t *testing.T) {
+
+	for _, e := range switchPropertyTests {
+		req, _ := http.NewRequest("POST", "/admin/switch-property/"+e.propertyID, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+
+		// chi.URLParam reads the id from the route context which is normally set by the router
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.propertyID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminSwitchProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if got := session.GetInt(ctx, "admin_property_id"); got != e.expectedPropertyID {
+			t.Errorf("failed %s: expected admin property %d, but got %d", e.name, e.expectedPropertyID, got)
+		}
+
+		if e.expectError != session.Exists(ctx, "error") {
+			t.Errorf("failed %s: expected error in session to be %t", e.name, e.expectError)
+		}
+	}
+}
+
+var errorPageTests = []struct {
+	name                string
+	url                 string
+	accept              string
+	expectedStatusCode  int
+	expectedContentType string
+}{
+	{"not-found-html", "/green-eggs-and-ham", "text/html", http.StatusNotFound, "text/html; charset=utf-8"},
+	{"not-found-json", "/green-eggs-and-ham", "application/json", http.StatusNotFound, "application/json"},
+	{"method-not-allowed-html", "/search-availability-json", "text/html", http.StatusMethodNotAllowed, "text/html; charset=utf-8"},
+	{"method-not-allowed-json", "/search-availability-json", "application/json", http.StatusMethodNotAllowed, "application/json"},
+	{"bad-dates-json", "/book-room?id=1&s=fish&e=fish", "application/json", http.StatusBadRequest, "application/json"},
+}
+
+func TestErrorPages(t *testing.T) {
+
+	routes := getRoutes()
+
+	for _, e := range errorPageTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		req.Header.Set("Accept", e.accept)
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != e.expectedContentType {
+			t.Errorf("failed %s: expected content type %s, but got %s", e.name, e.expectedContentType, ct)
+		}
+
+		if e.expectedContentType == "application/json" {
+			var j struct {
+				OK     bool `json:"ok"`
+				Status int  `json:"status"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+				t.Errorf("failed %s: can't parse json: %s", e.name, err)
+			}
+			if j.OK || j.Status != e.expectedStatusCode {
+				t.Errorf("failed %s: unexpected json body %s", e.name, rr.Body.String())
+			}
+		}
+	}
+}
+
+var cspReportTests = []struct {
+	name               string
+	contentType        string
+	body               string
+	expectedStatusCode int
+}{
+	{"report-uri", "application/csp-report", `{"csp-report":{"document-uri":"http://localhost/","violated-directive":"script-src","blocked-uri":"inline"}}`, http.StatusNoContent},
+	{"reporting-api", "application/reports+json", `[{"type":"csp-violation","body":{"documentURL":"http://localhost/","effectiveDirective":"script-src-elem","blockedURL":"https://evil.example/x.js"}}]`, http.StatusNoContent},
+	{"invalid-json", "application/csp-report", `{"csp-report":`, http.StatusBadRequest},
+	{"too-large", "application/csp-report", `{"csp-report":{"blocked-uri":"` + strings.Repeat("a", 70000) + `"}}`, http.StatusRequestEntityTooLarge},
+}
+
+func TestRepository_CSPReport(t *testing.T) {
+
+	for _, e := range cspReportTests {
+		req, _ := http.NewRequest("POST", "/csp-report", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", e.contentType)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.CSPReport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
 // helper func for putting the reservation var as a session var into the session of the request
 // and it is possible using context hence creating a getCtx helper func
 func getCtx(r *http.Request) context.Context {
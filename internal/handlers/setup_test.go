@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"html/template"
@@ -16,6 +17,9 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/logging"
+	"github.com/prayagsingh/bookings/internal/helpers"
+	"github.com/prayagsingh/bookings/internal/mailer"
 	"github.com/prayagsingh/bookings/internal/models"
 	"github.com/prayagsingh/bookings/internal/render"
 )
@@ -23,7 +27,19 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = render.Functions()
+var mail testMailer
+
+// testMailer records the messages sent by the handlers
+type testMailer struct {
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
 
 func TestMain(m *testing.M) {
 
@@ -33,16 +49,12 @@ func TestMain(m *testing.M) {
 	// set it to true when in production
 	app.InProduction = false
 
-	// initialzing logger and printing logs to terminal
-	infoLog := log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
-	// make infoLog app wide variable
-	app.InfoLog = infoLog
-
-	// initializng error logger
-	// Lshotfile will give the info about the error
-	errorLog := log.New(os.Stdout, "Error:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	// make errorLog app wide variable
-	app.ErrorLog = errorLog
+	// structured logger printing logs to terminal
+	logger, err := logging.New(os.Stdout, logging.FormatLogfmt, "info")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Logger = logger
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -66,11 +78,17 @@ func TestMain(m *testing.M) {
 	// to reading it from disk
 	app.UseCache = true
 
+	// emails are recorded instead of sent, see testMailer
+	app.Mailer = &mail
+	app.BaseURL = "http://localhost:8080"
+	app.SecretKey = []byte("0123456789abcdef0123456789abcdef")
+
 	// This allow Handler functions to have access to appConfig via repository
 	repo := NewTestRepo(&app)
 	NewHandler(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
@@ -93,12 +111,33 @@ func getRoutes() http.Handler {
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
 
+	mux.Get("/book-room", Repo.BookRoom)
+
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.Reservations)
 	mux.Post("/make-reservation", Repo.PostReservations)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+	mux.Get("/user/two-factor", Repo.ShowTwoFactor)
+	mux.Post("/user/two-factor", Repo.PostTwoFactor)
+	mux.Get("/guest/register", Repo.ShowGuestRegister)
+	mux.Post("/guest/register", Repo.PostGuestRegister)
+	mux.Get("/guest/login", Repo.ShowGuestLogin)
+	mux.Post("/guest/login", Repo.PostGuestLogin)
+	mux.Get("/guest/logout", Repo.GuestLogout)
+	mux.Get("/guest/bookings", Repo.GuestBookings)
+
+	mux.NotFound(Repo.NotFound)
+	mux.MethodNotAllowed(Repo.MethodNotAllowed)
+
 	// routes for static files
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -0,0 +1,146 @@
+// Package health serves the liveness and readiness endpoints used by the orchestrator
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckFunc reports whether a dependency of the application is usable. A nil error means ok
+type CheckFunc func(ctx context.Context) error
+
+// Checker holds the readiness checks and the shutdown state
+type Checker struct {
+	mu     sync.Mutex
+	checks map[string]CheckFunc
+
+	// Timeout bounds the time all checks together may take
+	Timeout time.Duration
+
+	shuttingDown atomic.Bool
+}
+
+// Default is the checker served on /healthz and /readyz
+var Default = NewChecker()
+
+// NewChecker returns a checker without any checks
+func NewChecker() *Checker {
+	return &Checker{checks: map[string]CheckFunc{}, Timeout: 2 * time.Second}
+}
+
+// Register adds a readiness check. A check registered twice under the same name is replaced
+func (c *Checker) Register(name string, check CheckFunc) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// SetShuttingDown marks the application as shutting down. Readiness fails from then on so that
+// no new traffic is sent while the in-flight requests are drained
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the JSON body of /readyz
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Check runs all checks concurrently and reports whether the application is ready
+func (c *Checker) Check(ctx context.Context) (Report, bool) {
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	checks := make([]CheckFunc, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			errs[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ready", Checks: make(map[string]CheckResult, len(names))}
+	ready := true
+	for i, name := range names {
+		if errs[i] != nil {
+			ready = false
+			report.Checks[name] = CheckResult{Status: "failed", Error: errs[i].Error()}
+			continue
+		}
+		report.Checks[name] = CheckResult{Status: "ok"}
+	}
+
+	if c.shuttingDown.Load() {
+		ready = false
+		report.Checks["shutdown"] = CheckResult{Status: "failed", Error: "application is shutting down"}
+	}
+
+	if !ready {
+		report.Status = "not ready"
+	}
+
+	return report, ready
+}
+
+// Liveness responds with 200 as long as the process is able to serve requests. It doesn't look at
+// any dependency, otherwise a database outage would get every instance restarted
+func (c *Checker) Liveness(rw http.ResponseWriter, r *http.Request) {
+
+	writeJSON(rw, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness responds with 200 when every check passes and 503 otherwise, along with the result of
+// each check
+func (c *Checker) Readiness(rw http.ResponseWriter, r *http.Request) {
+
+	report, ready := c.Check(r.Context())
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, report)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	rw.Write(out)
+}
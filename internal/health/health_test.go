@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLiveness(t *testing.T) {
+
+	c := NewChecker()
+	c.Register("database", func(ctx context.Context) error { return errors.New("down") })
+
+	rr := httptest.NewRecorder()
+	c.Liveness(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	// liveness doesn't depend on the checks
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+}
+
+var readinessTests = []struct {
+	name           string
+	checks         map[string]CheckFunc
+	shuttingDown   bool
+	expectedStatus int
+	expectedChecks map[string]string
+}{
+	{
+		"all ok",
+		map[string]CheckFunc{
+			"database":  func(ctx context.Context) error { return nil },
+			"templates": func(ctx context.Context) error { return nil },
+		},
+		false,
+		http.StatusOK,
+		map[string]string{"database": "ok", "templates": "ok"},
+	},
+	{
+		"one failing",
+		map[string]CheckFunc{
+			"database":  func(ctx context.Context) error { return errors.New("connection refused") },
+			"templates": func(ctx context.Context) error { return nil },
+		},
+		false,
+		http.StatusServiceUnavailable,
+		map[string]string{"database": "failed", "templates": "ok"},
+	},
+	{
+		"check exceeding the timeout",
+		map[string]CheckFunc{
+			"database": func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() },
+		},
+		false,
+		http.StatusServiceUnavailable,
+		map[string]string{"database": "failed"},
+	},
+	{
+		"shutting down",
+		map[string]CheckFunc{
+			"database": func(ctx context.Context) error { return nil },
+		},
+		true,
+		http.StatusServiceUnavailable,
+		map[string]string{"database": "ok", "shutdown": "failed"},
+	},
+}
+
+func TestReadiness(t *testing.T) {
+
+	for _, e := range readinessTests {
+		c := NewChecker()
+		c.Timeout = 10 * time.Millisecond
+		for name, check := range e.checks {
+			c.Register(name, check)
+		}
+		if e.shuttingDown {
+			c.SetShuttingDown()
+		}
+
+		rr := httptest.NewRecorder()
+		c.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		var report Report
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: can't parse response: %s", e.name, err)
+		}
+
+		if len(report.Checks) != len(e.expectedChecks) {
+			t.Errorf("%s: expected %d checks but got %d", e.name, len(e.expectedChecks), len(report.Checks))
+		}
+		for name, status := range e.expectedChecks {
+			if report.Checks[name].Status != status {
+				t.Errorf("%s: expected check %s to be %s but got %q", e.name, name, status, report.Checks[name].Status)
+			}
+		}
+	}
+}
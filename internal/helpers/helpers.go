@@ -1,11 +1,21 @@
 package helpers
 
 import (
-	"fmt"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/rbac"
+	"github.com/prayagsingh/bookings/internal/render"
+	"github.com/prayagsingh/bookings/internal/requestctx"
 )
 
 var app *config.AppConfig
@@ -15,19 +25,154 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-// ClientError
-func ClientError(w http.ResponseWriter, status int) {
+// errorResponse is the body of an error sent to clients which asked for JSON
+type errorResponse struct {
+	OK      bool   `json:"ok"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// RetryAfter is the number of seconds to wait before retrying, set with 429
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// Logger returns the logger of the request, which adds the request id, method, path and user id
+// to every record. Outside of a request the application logger is returned
+func Logger(r *http.Request) *slog.Logger {
+
+	if l := requestctx.Logger(r.Context()); l != nil {
+		return l
+	}
+	return app.Logger
+}
+
+// ClientError shows the client the error page, or a JSON error, for status
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
 
-	app.InfoLog.Println("Client error with status of ", status)
-	// showing client the error with status code if something went wrong
-	http.Error(w, http.StatusText(status), status)
+	Logger(r).Info("client error", "status", status)
+	writeError(w, r, status, nil)
 }
 
-// ServerError
-func ServerError(w http.ResponseWriter, err error) {
-	
+// ServerError logs the error with its stack trace and shows the client the 500 error page,
+// or a JSON error
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+
 	// getting the stack trace if something went wrong on the server
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	Logger(r).Error("server error", "error", err.Error(), "stack", string(debug.Stack()))
+	writeError(w, r, http.StatusInternalServerError, err)
+}
+
+// TooManyRequests tells the client to retry after retryAfter, in the Retry-After header and on
+// the error page or in the JSON error
+func TooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	Logger(r).Warn("too many requests", "ip", ClientIP(r), "retry_after", seconds)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusTooManyRequests, nil)
+}
+
+// writeError writes the error body in the format the client asked for. If the error page
+// can't be rendered a plain text error is sent instead
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+
+	if WantsJSON(r) {
+		resp := errorResponse{
+			OK:      false,
+			Status:  status,
+			Message: http.StatusText(status),
+		}
+		if status == http.StatusTooManyRequests {
+			resp.RetryAfter, _ = strconv.Atoi(w.Header().Get("Retry-After"))
+		}
+		out, _ := json.MarshalIndent(resp, "", "  ")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(out)
+		return
+	}
+
+	if renderErr := render.ErrorPage(w, r, status, err); renderErr != nil {
+		Logger(r).Error("can't render error page", "error", renderErr.Error())
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// WantsJSON returns true if the client expects a JSON response: it asked for one in the Accept
+// header, or, without asking for HTML, sent JSON or called one of the -json endpoints
+func WantsJSON(r *http.Request) bool {
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return true
+	}
+
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return true
+	}
+
+	return strings.HasSuffix(r.URL.Path, "-json")
+}
+
+// ClientIP returns the address of the client. Behind a trusted proxy it is the first address of
+// the X-Forwarded-For header
+func ClientIP(r *http.Request) string {
+
+	if app != nil && app.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsAuthenticated returns true if a staff user is logged in
+func IsAuthenticated(r *http.Request) bool {
+
+	return app.Session.Exists(r.Context(), "user_id")
+}
+
+// GuestID returns the id of the guest logged in on the public site, zero if there is none.
+// Guest accounts are separate from the staff users and give no access to the admin area
+func GuestID(r *http.Request) int {
+
+	return app.Session.GetInt(r.Context(), "guest_id")
+}
+
+// Actor returns who makes the request for the audit log: the logged in user, otherwise a guest.
+// Guests with an account are labelled with its id
+func Actor(r *http.Request) models.Actor {
+
+	actor := models.Actor{Type: models.ActorGuest, IP: ClientIP(r)}
+	if userID := app.Session.GetInt(r.Context(), "user_id"); userID != 0 {
+		actor.Type = models.ActorUser
+		actor.UserID = userID
+	} else if guestID := GuestID(r); guestID != 0 {
+		actor.Label = "guest #" + strconv.Itoa(guestID)
+	}
+
+	return actor
+}
+
+// Can reports whether the logged in staff user has the permission. The user is loaded by the Auth
+// middleware, so it is false outside the admin area
+func Can(r *http.Request, p rbac.Permission) bool {
+
+	u, ok := requestctx.User(r.Context())
+	return ok && rbac.Can(u.AccessLevel, p)
 }
@@ -0,0 +1,343 @@
+// Package importer loads bookings from a CSV file, e.g. the spreadsheet kept before the site. A
+// row is a reservation or an owner block. Rows are validated with the rules of the reservation
+// form, checked against each other and against the availability of the rooms. Rows with errors
+// are reported and skipped, the valid ones are imported in one transaction. An import is a dry run
+// unless it is committed
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prayagsingh/bookings/internal/forms"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+)
+
+// MaxRows limits the rows of a file, larger files are split
+const MaxRows = 5000
+
+// maxFieldLength is the length of the text columns of reservations
+const maxFieldLength = 255
+
+const dateLayout = "2006-01-02"
+
+// Values of the type column. Rows without one are reservations
+const (
+	TypeReservation = "reservation"
+	TypeBlock       = "block"
+)
+
+// Columns read from the file. Other columns are ignored, so that a file of the reservations
+// export can be imported. The room is given by room_id or by room_name
+var Columns = []string{"type", "room_id", "room_name", "start_date", "end_date", "first_name", "last_name", "email", "phone"}
+
+// ErrInvalidFile is wrapped by the errors of files which can't be imported at all, e.g. without
+// a header row. Problems with single rows are reported as models.ImportError instead
+var ErrInvalidFile = errors.New("invalid file")
+
+// Parse reads the rows of a CSV file with a header row. Bookings must be in rooms, the rooms of the
+// property. It returns the valid rows, the errors of the others and the number of rows read. The
+// error is set if the file can't be read at all
+func Parse(r io.Reader, rooms []models.Room) ([]models.ImportRow, []models.ImportError, int, error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, 0, fmt.Errorf("%w: the file is empty", ErrInvalidFile)
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			// the byte order mark written by spreadsheets and the export
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var missing []string
+	for _, name := range []string{"start_date", "end_date"} {
+		if _, ok := index[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	_, hasRoomID := index["room_id"]
+	_, hasRoomName := index["room_name"]
+	if !hasRoomID && !hasRoomName {
+		missing = append(missing, "room_id or room_name")
+	}
+	if len(missing) > 0 {
+		return nil, nil, 0, fmt.Errorf("%w: the header row is missing the columns %s", ErrInvalidFile, strings.Join(missing, ", "))
+	}
+
+	var rows []models.ImportRow
+	var errs []models.ImportError
+	count := 0
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, count, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+		}
+		if blank(record) {
+			continue
+		}
+
+		count++
+		if count > MaxRows {
+			return nil, nil, count, fmt.Errorf("%w: the file has more than %d rows, split it into several imports", ErrInvalidFile, MaxRows)
+		}
+
+		line, _ := cr.FieldPos(0)
+		values := url.Values{}
+		for _, name := range Columns {
+			if i, ok := index[name]; ok && i < len(record) {
+				values.Set(name, unescape(strings.TrimSpace(record[i])))
+			}
+		}
+
+		row, rowErrs := parseRow(line, values, rooms)
+		if len(rowErrs) > 0 {
+			errs = append(errs, rowErrs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, errs, count, nil
+}
+
+// parseRow validates a row and turns it into a reservation or a block
+func parseRow(line int, values url.Values, rooms []models.Room) (models.ImportRow, []models.ImportError) {
+
+	row := models.ImportRow{Line: line}
+	form := forms.New(values)
+
+	switch strings.ToLower(values.Get("type")) {
+	case "", TypeReservation:
+		// the rules of the reservation form
+		form.Required("first_name", "last_name", "email")
+		form.MinLength("first_name", 3)
+		form.IsEmail("email")
+	case TypeBlock, "owner block", "owner_block":
+		row.Block = true
+	default:
+		form.Errors.Add("type", fmt.Sprintf("The type must be %s or %s", TypeReservation, TypeBlock))
+	}
+
+	for _, field := range []string{"first_name", "last_name", "email", "phone"} {
+		if utf8.RuneCountInString(values.Get(field)) > maxFieldLength {
+			form.Errors.Add(field, fmt.Sprintf("This field must be at most %d characters long", maxFieldLength))
+		}
+	}
+
+	form.Required("start_date", "end_date")
+	start := parseDate(form, "start_date")
+	end := parseDate(form, "end_date")
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		form.Errors.Add("end_date", "The departure must be after the arrival")
+	}
+
+	room, ok := findRoom(values, rooms)
+	if !ok {
+		form.Errors.Add("room", "Unknown room, use the id or the name of a room of the property")
+	}
+
+	if !form.Valid() {
+		return row, importErrors(line, form)
+	}
+
+	row.Reservation = models.Reservation{
+		FirstName: values.Get("first_name"),
+		LastName:  values.Get("last_name"),
+		Email:     values.Get("email"),
+		Phone:     values.Get("phone"),
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+		Room:      room,
+	}
+	// priced at the current rate of the room, the old spreadsheet has no amounts
+	if !row.Block {
+		row.Reservation.Amount = int(end.Sub(start).Hours()/24) * room.NightlyRate
+	}
+	return row, nil
+}
+
+// parseDate parses a date field, adding an error to the form if it isn't empty but invalid
+func parseDate(form *forms.Form, field string) time.Time {
+
+	value := form.Get(field)
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		form.Errors.Add(field, "Invalid date, use YYYY-MM-DD")
+		return time.Time{}
+	}
+	return t
+}
+
+// findRoom looks the room of a row up by id, or by name ignoring case
+func findRoom(values url.Values, rooms []models.Room) (models.Room, bool) {
+
+	if id, err := strconv.Atoi(values.Get("room_id")); err == nil {
+		for _, room := range rooms {
+			if room.ID == id {
+				return room, true
+			}
+		}
+		return models.Room{}, false
+	}
+
+	name := values.Get("room_name")
+	for _, room := range rooms {
+		if name != "" && strings.EqualFold(room.RoomName, name) {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+// importErrors returns the first error of every invalid field of the form
+func importErrors(line int, form *forms.Form) []models.ImportError {
+
+	var fields []string
+	for field := range form.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var errs []models.ImportError
+	for _, field := range fields {
+		errs = append(errs, models.ImportError{Line: line, Field: field, Message: form.Errors.Get(field)})
+	}
+	return errs
+}
+
+// blank reports whether every field of a record is empty, spreadsheets write such rows at the end
+func blank(record []string) bool {
+
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// unescape removes the apostrophe the export puts before values which look like formulas
+func unescape(s string) string {
+
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// Overlaps returns an error for every row overlapping an earlier row of the same room. The end
+// date is the day of departure, a stay may start on it
+func Overlaps(rows []models.ImportRow) []models.ImportError {
+
+	var errs []models.ImportError
+	var kept []models.ImportRow
+
+	for _, row := range rows {
+		res := row.Reservation
+		overlap := 0
+		for _, other := range kept {
+			o := other.Reservation
+			if o.RoomID == res.RoomID && res.StartDate.Before(o.EndDate) && res.EndDate.After(o.StartDate) {
+				overlap = other.Line
+				break
+			}
+		}
+		if overlap != 0 {
+			errs = append(errs, models.ImportError{Line: row.Line, Message: fmt.Sprintf("overlaps line %d in the same room", overlap)})
+			continue
+		}
+		kept = append(kept, row)
+	}
+
+	return errs
+}
+
+// without returns the rows without an error
+func without(rows []models.ImportRow, errs []models.ImportError) []models.ImportRow {
+
+	failed := map[int]bool{}
+	for _, e := range errs {
+		failed[e.Line] = true
+	}
+
+	var valid []models.ImportRow
+	for _, row := range rows {
+		if !failed[row.Line] {
+			valid = append(valid, row)
+		}
+	}
+	return valid
+}
+
+// Import reads the file r and imports its valid rows into the property. Without commit it is a
+// dry run: nothing is saved, but the result is the one of the import
+func Import(db repository.DatabaseRepo, actor models.Actor, propertyID int, r io.Reader, commit bool) (models.ImportResult, error) {
+
+	var result models.ImportResult
+
+	rooms, err := db.AllRooms(propertyID)
+	if err != nil {
+		return result, err
+	}
+
+	rows, errs, count, err := Parse(r, rooms)
+	if err != nil {
+		return result, err
+	}
+	result.Rows = count
+
+	overlaps := Overlaps(rows)
+	rows = without(rows, overlaps)
+	errs = append(errs, overlaps...)
+
+	if len(rows) > 0 {
+		conflicts, err := db.ImportBookings(actor, propertyID, rows, commit)
+		if err != nil {
+			return result, err
+		}
+		rows = without(rows, conflicts)
+		errs = append(errs, conflicts...)
+	}
+
+	for _, row := range rows {
+		if row.Block {
+			result.Blocks++
+		} else {
+			result.Reservations++
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	result.Errors = errs
+	result.Committed = commit
+
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+)
+
+var testRooms = []models.Room{
+	{ID: 1, RoomName: "General's Quarters", PropertyID: 1},
+	{ID: 2, RoomName: "Major's Suite", PropertyID: 1},
+}
+
+var parseTests = []struct {
+	name           string
+	row            string
+	expectedField  string
+	expectedBlock  bool
+	expectedRoomID int
+}{
+	{"reservation", "reservation,1,,2021-10-01,2021-10-03,John,Smith,john@smith.com,555", "", false, 1},
+	{"default-type", ",1,,2021-10-01,2021-10-03,John,Smith,john@smith.com,555", "", false, 1},
+	{"room-by-name", ",,major's suite,2021-10-01,2021-10-03,John,Smith,john@smith.com,", "", false, 2},
+	{"block-without-guest", "block,2,,2021-10-01,2021-10-03,,,,", "", true, 2},
+	{"formula-escape-removed", ",1,,2021-10-01,2021-10-03,John,Smith,john@smith.com,'+1 555", "", false, 1},
+	{"unknown-type", "cancelled,1,,2021-10-01,2021-10-03,John,Smith,john@smith.com,", "type", false, 0},
+	{"short-first-name", ",1,,2021-10-01,2021-10-03,Jo,Smith,john@smith.com,", "first_name", false, 0},
+	{"invalid-email", ",1,,2021-10-01,2021-10-03,John,Smith,john,", "email", false, 0},
+	{"missing-last-name", ",1,,2021-10-01,2021-10-03,John,,john@smith.com,", "last_name", false, 0},
+	{"invalid-date", ",1,,01/10/2021,2021-10-03,John,Smith,john@smith.com,", "start_date", false, 0},
+	{"departure-before-arrival", ",1,,2021-10-03,2021-10-03,John,Smith,john@smith.com,", "end_date", false, 0},
+	{"unknown-room", ",9,,2021-10-01,2021-10-03,John,Smith,john@smith.com,", "room", false, 0},
+	{"unknown-room-name", ",,Penthouse,2021-10-01,2021-10-03,John,Smith,john@smith.com,", "room", false, 0},
+}
+
+const testHeader = "type,room_id,room_name,start_date,end_date,first_name,last_name,email,phone\n"
+
+func TestParse(t *testing.T) {
+
+	for _, e := range parseTests {
+		rows, errs, count, err := Parse(strings.NewReader(testHeader+e.row+"\n"), testRooms)
+		if err != nil {
+			t.Errorf("failed %s: %s", e.name, err)
+			continue
+		}
+		if count != 1 {
+			t.Errorf("failed %s: expected 1 row but got %d", e.name, count)
+		}
+
+		if e.expectedField != "" {
+			if len(errs) == 0 || errs[0].Field != e.expectedField {
+				t.Errorf("failed %s: expected an error for %s but got %v", e.name, e.expectedField, errs)
+			}
+			if len(rows) != 0 {
+				t.Errorf("failed %s: expected the row to be skipped", e.name)
+			}
+			continue
+		}
+
+		if len(errs) != 0 || len(rows) != 1 {
+			t.Errorf("failed %s: expected a valid row but got %v", e.name, errs)
+			continue
+		}
+		row := rows[0]
+		if row.Line != 2 {
+			t.Errorf("failed %s: expected line 2 but got %d", e.name, row.Line)
+		}
+		if row.Block != e.expectedBlock || row.Reservation.RoomID != e.expectedRoomID {
+			t.Errorf("failed %s: expected block %v in room %d but got %+v", e.name, e.expectedBlock, e.expectedRoomID, row)
+		}
+		if strings.HasPrefix(row.Reservation.Phone, "'") {
+			t.Errorf("failed %s: expected the escape of the export to be removed from %q", e.name, row.Reservation.Phone)
+		}
+	}
+}
+
+var invalidFileTests = []struct {
+	name string
+	file string
+}{
+	{"empty", ""},
+	{"no-room-column", "start_date,end_date\n2021-10-01,2021-10-03\n"},
+	{"no-dates", "room_id,first_name\n1,John\n"},
+	{"bad-quotes", testHeader + `,1,,2021-10-01,2021-10-03,"John,Smith,john@smith.com,` + "\n"},
+	{"too-many-rows", testHeader + strings.Repeat("block,1,,2021-10-01,2021-10-03,,,,\n", MaxRows+1)},
+}
+
+func TestParseInvalidFile(t *testing.T) {
+
+	for _, e := range invalidFileTests {
+		_, _, _, err := Parse(strings.NewReader(e.file), testRooms)
+		if !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("failed %s: expected an invalid file but got %v", e.name, err)
+		}
+	}
+}
+
+func TestParseExport(t *testing.T) {
+
+	// a file of the reservations export, with its byte order mark and columns the import ignores
+	file := "\ufeffid,first_name,last_name,email,phone,room_id,room_name,start_date,end_date,nights,status,guest_id,created_at\n" +
+		"7,John,Smith,john@smith.com,'+1 555,1,General's Quarters,2021-10-01,2021-10-03,2,departed,,2021-09-01 10:00:00\n" +
+		",,,,,,,,,,,,\n"
+
+	rows, errs, count, err := Parse(strings.NewReader(file), testRooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(rows) != 1 || len(errs) != 0 {
+		t.Fatalf("expected one valid row and the blank one skipped but got %d rows, %v", count, errs)
+	}
+	if rows[0].Reservation.Phone != "+1 555" {
+		t.Errorf("expected the phone +1 555 but got %q", rows[0].Reservation.Phone)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+
+	file := testHeader +
+		",1,,2021-10-01,2021-10-05,John,Smith,john@smith.com,\n" +
+		// departs the day the next guest arrives
+		",1,,2021-10-05,2021-10-07,Jane,Doe,jane@doe.com,\n" +
+		"block,1,,2021-10-04,2021-10-06,,,,\n" +
+		",2,,2021-10-04,2021-10-06,Jane,Doe,jane@doe.com,\n"
+
+	rows, _, _, err := Parse(strings.NewReader(file), testRooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := Overlaps(rows)
+	if len(errs) != 1 || errs[0].Line != 4 || !strings.Contains(errs[0].Message, "line 2") {
+		t.Errorf("expected the block on line 4 to overlap line 2 but got %v", errs)
+	}
+}
+
+func TestImport(t *testing.T) {
+
+	db := dbrepo.NewTestPostgresRepo(&config.AppConfig{})
+	actor := models.Actor{Type: models.ActorSystem, Label: "import"}
+
+	file := testHeader +
+		",1,,2021-10-01,2021-10-05,John,Smith,john@smith.com,\n" +
+		"block,1,,2021-10-10,2021-10-12,,,,\n" +
+		// room 2 is booked in the test repository
+		",2,,2021-10-01,2021-10-05,Jane,Doe,jane@doe.com,\n" +
+		",1,,2021-10-02,2021-10-03,Jane,Doe,jane@doe.com,\n" +
+		",1,,2021-10-20,2021-10-22,Jane,Doe,not-an-email,\n"
+
+	for _, commit := range []bool{false, true} {
+		result, err := Import(db, actor, 1, strings.NewReader(file), commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Rows != 5 || result.Reservations != 1 || result.Blocks != 1 || result.Committed != commit {
+			t.Errorf("commit %v: unexpected result %+v", commit, result)
+		}
+
+		var lines []int
+		for _, e := range result.Errors {
+			lines = append(lines, e.Line)
+		}
+		if len(lines) != 3 || lines[0] != 4 || lines[1] != 5 || lines[2] != 6 {
+			t.Errorf("commit %v: expected errors on lines 4, 5 and 6 sorted but got %v", commit, result.Errors)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package logging builds the structured logger the application writes all its logs with
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Formats supported by New
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// New returns a logger writing to w in the given format ("json" or "logfmt") which drops
+// records below level ("debug", "info", "warn" or "error")
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatLogfmt, "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use %s or %s", format, FormatJSON, FormatLogfmt)
+	}
+}
+
+// ParseLevel converts the name of a level to a slog.Level
+func ParseLevel(level string) (slog.Level, error) {
+
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return lvl, fmt.Errorf("unknown log level %q, use debug, info, warn or error", level)
+	}
+
+	return lvl, nil
+}
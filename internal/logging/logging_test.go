@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNew_JSON(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("dropped")
+	logger.Info("request", "status", 200)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected the debug record to be dropped, got %d lines", len(lines))
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal("record is not json", err)
+	}
+
+	if record["msg"] != "request" || record["status"] != float64(200) || record["level"] != "INFO" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestNew_Logfmt(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, "logfmt", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("rebuilt", "path", "/about")
+
+	if !strings.Contains(buf.String(), "level=DEBUG msg=rebuilt path=/about") {
+		t.Errorf("unexpected logfmt output %s", buf.String())
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	if _, err := New(&bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
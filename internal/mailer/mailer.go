@@ -0,0 +1,94 @@
+// Package mailer sends the emails of the application, e.g. password reset links. SMTPMailer sends
+// them through an SMTP server; LogMailer only logs them, which is used in development
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	// From is the sender address
+	From string
+	// Username and Password authenticate with PLAIN auth if set. net/smtp only sends them over
+	// TLS or to localhost
+	Username string
+	Password string
+}
+
+// Send sends msg. The context is checked before the connection is made only, net/smtp has no
+// support for cancelling
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, Format(m.From, msg, time.Now()))
+	if err != nil {
+		return fmt.Errorf("can't send mail to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// Format returns msg as an RFC 5322 message
+func Format(from string, msg Message, date time.Time) []byte {
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return b.Bytes()
+}
+
+// LogMailer logs messages instead of sending them. Links in the body, e.g. to reset a password,
+// can be copied from the log in development
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+// Send logs msg
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+
+	m.Logger.Info("mail not sent, no SMTP server configured", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+
+	date := time.Date(2021, 10, 16, 12, 0, 0, 0, time.UTC)
+	out := string(Format("bookings@here.com", Message{
+		To:      "admin@here.com",
+		Subject: "Réinitialiser",
+		Body:    "line one\nline two",
+	}, date))
+
+	for _, expected := range []string{
+		"From: bookings@here.com\r\n",
+		"To: admin@here.com\r\n",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n",
+		"Date: Sat, 16 Oct 2021 12:00:00 +0000\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the message:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// HTTP metrics, labelled with the chi route pattern instead of the path to keep the number of
+// series bounded
+var (
+	HTTPRequests = Default.NewCounter("http_requests_total",
+		"Number of HTTP requests by method, route pattern and status code.", "method", "route", "status")
+	HTTPDuration = Default.NewHistogram("http_request_duration_seconds",
+		"HTTP request latency by method and route pattern.", DefBuckets, "method", "route")
+)
+
+// Repository metrics, labelled with the DatabaseRepo method
+var (
+	DBCalls = Default.NewCounter("db_repo_calls_total",
+		"Number of DatabaseRepo calls by method.", "method")
+	DBErrors = Default.NewCounter("db_repo_errors_total",
+		"Number of DatabaseRepo calls which returned an error other than no rows by method.", "method")
+	DBDuration = Default.NewHistogram("db_repo_duration_seconds",
+		"DatabaseRepo call latency by method.", DefBuckets, "method")
+)
+
+// Business metrics
+var (
+	Searches = Default.NewCounter("bookings_searches_total",
+		"Number of availability searches.")
+	SearchesNoAvailability = Default.NewCounter("bookings_searches_no_availability_total",
+		"Number of availability searches which found no room.")
+	ReservationsCreated = Default.NewCounter("bookings_reservations_created_total",
+		"Number of reservations created.")
+)
+
+// ObserveHTTP records a served request. route is the chi route pattern, or "unmatched"
+func ObserveHTTP(method, route string, status int, d time.Duration) {
+
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPRequests.Inc(method, route, strconv.Itoa(status))
+	HTTPDuration.Observe(d.Seconds(), method, route)
+}
+
+// ObserveDB records a DatabaseRepo call which started at start and returned err. sql.ErrNoRows
+// isn't an error of the database, e.g. PropertyLoad looks up the hostname of every request and
+// finds nothing on a single host setup, so it is counted as a success
+func ObserveDB(method string, start time.Time, err error) {
+
+	DBCalls.Inc(method)
+	DBDuration.Observe(time.Since(start).Seconds(), method)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		DBErrors.Inc(method)
+	}
+}
+
+// RegisterDBStats reports the database/sql pool statistics of db on every scrape
+func RegisterDBStats(db *sql.DB) {
+
+	Default.RegisterCollector(func() []Gauge {
+		s := db.Stats()
+		return []Gauge{
+			{Name: "db_max_open_connections", Help: "Maximum number of open connections to the database.", Value: float64(s.MaxOpenConnections)},
+			{Name: "db_open_connections", Help: "Number of established connections, in use and idle.", Value: float64(s.OpenConnections)},
+			{Name: "db_in_use_connections", Help: "Number of connections currently in use.", Value: float64(s.InUse)},
+			{Name: "db_idle_connections", Help: "Number of idle connections.", Value: float64(s.Idle)},
+			{Name: "db_wait_count_total", Help: "Total number of connections waited for.", Type: "counter", Value: float64(s.WaitCount)},
+			{Name: "db_wait_duration_seconds_total", Help: "Total time blocked waiting for a new connection.", Type: "counter", Value: s.WaitDuration.Seconds()},
+			{Name: "db_max_idle_closed_total", Help: "Total number of connections closed due to SetMaxIdleConns.", Type: "counter", Value: float64(s.MaxIdleClosed)},
+			{Name: "db_max_idle_time_closed_total", Help: "Total number of connections closed due to SetConnMaxIdleTime.", Type: "counter", Value: float64(s.MaxIdleTimeClosed)},
+			{Name: "db_max_lifetime_closed_total", Help: "Total number of connections closed due to SetConnMaxLifetime.", Type: "counter", Value: float64(s.MaxLifetimeClosed)},
+		}
+	})
+}
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
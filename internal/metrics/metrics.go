@@ -0,0 +1,304 @@
+// Package metrics collects application metrics and exposes them in the Prometheus text format.
+// It is intentionally small so that no metrics library is needed at runtime
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds, suitable for request latencies
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics which are written by its handler
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []collector
+	collectors []func() []Gauge
+}
+
+// collector is implemented by every metric type
+type collector interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Gauge is a sample reported by a collector func, e.g. the state of the database pool
+type Gauge struct {
+	Name  string
+	Help  string
+	Type  string // "gauge" or "counter"
+	Value float64
+}
+
+// NewCounter registers a counter with the given label names
+func (reg *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+
+	c := &Counter{desc: desc{name: name, help: help, labelNames: labelNames}, values: map[string]float64{}}
+	reg.register(c)
+	return c
+}
+
+// NewHistogram registers a histogram with the given buckets and label names
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	h := &Histogram{desc: desc{name: name, help: help, labelNames: labelNames}, buckets: b, values: map[string]*histogramValue{}}
+	reg.register(h)
+	return h
+}
+
+// RegisterCollector registers a func which is called on every scrape to report gauges
+func (reg *Registry) RegisterCollector(f func() []Gauge) {
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.collectors = append(reg.collectors, f)
+}
+
+func (reg *Registry) register(c collector) {
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.metrics = append(reg.metrics, c)
+}
+
+// Write writes all the metrics in the Prometheus text exposition format
+func (reg *Registry) Write(w io.Writer) {
+
+	reg.mu.Lock()
+	metrics := append([]collector(nil), reg.metrics...)
+	collectors := append([]func() []Gauge(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+
+	for _, f := range collectors {
+		for _, g := range f() {
+			typ := g.Type
+			if typ == "" {
+				typ = "gauge"
+			}
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", g.Name, escapeHelp(g.Help), g.Name, typ, g.Name, formatFloat(g.Value))
+		}
+	}
+}
+
+// Handler serves the metrics of the registry
+func (reg *Registry) Handler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+// desc describes a metric
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, typ)
+}
+
+// key joins the label values so they can be used as a map key
+func (d desc) key(labelValues []string) string {
+
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// labels formats the label pairs of key, plus an optional extra pair, as {a="b",c="d"}
+func (d desc) labels(key string, extra ...string) string {
+
+	var pairs []string
+	if len(d.labelNames) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labelNames[i], escapeLabel(v)))
+		}
+	}
+
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[0], escapeLabel(extra[1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value per set of label values
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Inc increments the counter for the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+
+	k := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[k] += v
+}
+
+// Value returns the current value of the counter for the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+
+	k := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[k]
+}
+
+func (c *Counter) write(w io.Writer) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labels(k), formatFloat(c.values[k]))
+	}
+}
+
+// Histogram counts observations in buckets per set of label values
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // not cumulative, one per bucket
+	count  uint64
+	sum    float64
+}
+
+// Observe adds an observation for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+
+	k := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+			break
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Count returns the number of observations for the label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+
+	k := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hv, ok := h.values[k]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hv := h.values[k]
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(k, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(k), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(k), hv.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
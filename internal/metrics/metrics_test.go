@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+
+	reg := NewRegistry()
+	c := reg.NewCounter("requests_total", "Number of requests.", "route")
+
+	c.Inc("/about")
+	c.Add(2, "/about")
+	c.Inc(`/a"b`)
+
+	if c.Value("/about") != 3 {
+		t.Errorf("expected 3 but got %v", c.Value("/about"))
+	}
+
+	var buf bytes.Buffer
+	reg.Write(&buf)
+
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/a\"b"} 1
+requests_total{route="/about"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	reg := NewRegistry()
+	h := reg.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "method")
+
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(5, "GET")
+
+	if h.Count("GET") != 3 {
+		t.Errorf("expected 3 observations but got %d", h.Count("GET"))
+	}
+
+	var buf bytes.Buffer
+	reg.Write(&buf)
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 2
+latency_seconds_bucket{method="GET",le="+Inf"} 3
+latency_seconds_sum{method="GET"} 5.55
+latency_seconds_count{method="GET"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestCollectorAndHandler(t *testing.T) {
+
+	reg := NewRegistry()
+	reg.RegisterCollector(func() []Gauge {
+		return []Gauge{{Name: "db_open_connections", Help: "Open connections.", Value: 4}}
+	})
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	if !strings.Contains(rr.Body.String(), "# TYPE db_open_connections gauge\ndb_open_connections 4\n") {
+		t.Errorf("unexpected output:\n%s", rr.Body.String())
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic when the number of label values is wrong")
+		}
+	}()
+
+	c := NewRegistry().NewCounter("x_total", "x", "a", "b")
+	c.Inc("only-one")
+}
+
+func TestObserveHTTP(t *testing.T) {
+
+	before := HTTPRequests.Value("GET", "unmatched", "404")
+	ObserveHTTP("GET", "", 404, 0)
+
+	if HTTPRequests.Value("GET", "unmatched", "404") != before+1 {
+		t.Error("expected requests without a route pattern to be counted as unmatched")
+	}
+}
+
+func TestObserveDB(t *testing.T) {
+
+	var tests = []struct {
+		name          string
+		err           error
+		expectedError float64
+	}{
+		{"success", nil, 0},
+		{"no-rows", fmt.Errorf("no property: %w", sql.ErrNoRows), 0},
+		{"error", errors.New("connection refused"), 1},
+	}
+
+	for _, e := range tests {
+		method := "Observe_" + e.name
+		ObserveDB(method, time.Now(), e.err)
+
+		if DBCalls.Value(method) != 1 {
+			t.Errorf("failed %s: expected the call to be counted", e.name)
+		}
+		if got := DBErrors.Value(method); got != e.expectedError {
+			t.Errorf("failed %s: expected %v errors but got %v", e.name, e.expectedError, got)
+		}
+	}
+}
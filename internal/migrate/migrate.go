@@ -0,0 +1,454 @@
+// Package migrate applies the SQL migrations of the application. Each migration is a pair of files
+// <version>_<name>.up.sql and <version>_<name>.down.sql, the version being a timestamp. Applied
+// migrations are recorded in the schema_migrations table along with a checksum of the up file
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lockID is the key of the advisory lock which keeps two instances from migrating at the same time
+const lockID = 72157
+
+// Migration is a single migration read from the migrations directory
+type Migration struct {
+	Version  string
+	Name     string
+	Up       string
+	Down     string
+	HasDown  bool
+	Checksum string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set if the up file changed after the migration was applied
+	Modified bool
+}
+
+// Migrator applies the migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	// Logger, if set, logs every applied and rolled back migration
+	Logger *slog.Logger
+}
+
+// New reads the migrations from fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads the migrations from the top directory of fsys, sorted by version
+func Load(fsys fs.FS) ([]Migration, error) {
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations: %w", err)
+	}
+
+	byVersion := map[string]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+
+		var up bool
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			up = true
+		case strings.HasSuffix(name, ".down.sql"):
+		default:
+			continue
+		}
+
+		version, rest, ok := strings.Cut(name, "_")
+		if !ok || strings.Trim(version, "0123456789") != "" {
+			return nil, fmt.Errorf("migration %s doesn't start with a version", name)
+		}
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+
+		if up {
+			if m.Up != "" || m.Checksum != "" {
+				return nil, fmt.Errorf("duplicate up migration for version %s", version)
+			}
+			m.Name = strings.TrimSuffix(rest, ".up.sql")
+			m.Up = string(b)
+			m.Checksum = checksum(b)
+		} else {
+			if m.HasDown {
+				return nil, fmt.Errorf("duplicate down migration for version %s", version)
+			}
+			m.Down = string(b)
+			m.HasDown = true
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %s has a down but no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func checksum(b []byte) string {
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Migrations returns the migrations read from the migrations directory
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies all pending migrations in order and returns the ones applied. It fails without
+// applying anything if an applied migration was modified afterwards
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			if s.Applied && s.Modified {
+				return fmt.Errorf("migration %s_%s was modified after it was applied", s.Version, s.Name)
+			}
+		}
+
+		for _, s := range status {
+			if s.Applied {
+				continue
+			}
+			err = m.apply(ctx, conn, s.Migration, true)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, s.Migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations and returns the ones rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+
+	var rolledBack []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+
+		var err error
+		rolledBack, err = m.down(ctx, conn, steps)
+		return err
+	})
+
+	return rolledBack, err
+}
+
+// Redo rolls back the last applied migration and applies it again. Useful while writing one
+func (m *Migrator) Redo(ctx context.Context) (Migration, error) {
+
+	var redone Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+
+		rolledBack, err := m.down(ctx, conn, 1)
+		if err != nil {
+			return err
+		}
+		if len(rolledBack) == 0 {
+			return errors.New("no migration has been applied")
+		}
+
+		redone = rolledBack[0]
+		return m.apply(ctx, conn, redone, true)
+	})
+
+	return redone, err
+}
+
+// Status returns every migration with its state in the database
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return m.status(ctx, conn)
+}
+
+// Pending returns the migrations which haven't been applied yet. It is used by the readiness check
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// locked runs fn on a single connection holding the migration lock, so that instances starting at
+// the same time with auto-migrate don't apply the same migration twice
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "select pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return fmt.Errorf("can't acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lockID)
+
+	err = m.ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// ensureTable creates the schema_migrations table. A database migrated with soda before has its
+// versions in schema_migration; they are taken over so that nothing is applied twice
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version varchar(14) primary key,
+		name varchar(255) not null,
+		checksum char(64) not null,
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return fmt.Errorf("can't create schema_migrations: %w", err)
+	}
+
+	var tracked int
+	err = conn.QueryRowContext(ctx, "select count(*) from schema_migrations").Scan(&tracked)
+	if err != nil {
+		return err
+	}
+
+	var soda sql.NullString
+	err = conn.QueryRowContext(ctx, "select to_regclass('schema_migration')::text").Scan(&soda)
+	if err != nil {
+		return err
+	}
+	if tracked > 0 || !soda.Valid {
+		return nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "select version from schema_migration")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sodaVersions := map[string]bool{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return err
+		}
+		sodaVersions[strings.TrimSpace(v)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if !sodaVersions[mig.Version] {
+			continue
+		}
+		err = record(ctx, conn, mig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Logger != nil && len(sodaVersions) > 0 {
+		m.Logger.Info("took over migrations applied by soda", "count", len(sodaVersions))
+	}
+
+	return nil
+}
+
+func record(ctx context.Context, q interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, mig Migration) error {
+
+	_, err := q.ExecContext(ctx, `insert into schema_migrations (version, name, checksum, applied_at)
+		values ($1, $2, $3, $4)`, mig.Version, mig.Name, mig.Checksum, time.Now())
+	return err
+}
+
+// status joins the migrations with the rows of schema_migrations
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]Status, error) {
+
+	rows, err := conn.QueryContext(ctx, "select version, checksum, applied_at from schema_migrations")
+	if err != nil {
+		// nothing has been applied if the table doesn't exist yet
+		var exists sql.NullString
+		if qerr := conn.QueryRowContext(ctx, "select to_regclass('schema_migrations')::text").Scan(&exists); qerr == nil && !exists.Valid {
+			return toStatus(m.migrations, nil), nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]Status{}
+	for rows.Next() {
+		var s Status
+		if err := rows.Scan(&s.Version, &s.Checksum, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return toStatus(m.migrations, applied), nil
+}
+
+func toStatus(migrations []Migration, applied map[string]Status) []Status {
+
+	status := make([]Status, len(migrations))
+	for i, mig := range migrations {
+		status[i].Migration = mig
+		if a, ok := applied[mig.Version]; ok {
+			status[i].Applied = true
+			status[i].AppliedAt = a.AppliedAt
+			status[i].Modified = a.Checksum != mig.Checksum
+		}
+	}
+
+	return status
+}
+
+// down rolls back the last steps applied migrations, newest first
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, steps int) ([]Migration, error) {
+
+	status, err := m.status(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(status) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		if !status[i].Applied {
+			continue
+		}
+		err = m.apply(ctx, conn, status[i].Migration, false)
+		if err != nil {
+			return rolledBack, err
+		}
+		rolledBack = append(rolledBack, status[i].Migration)
+	}
+
+	return rolledBack, nil
+}
+
+// apply runs the up or down file of mig and records it in one transaction, so that a failing
+// migration leaves nothing behind
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+
+	if !up && !mig.HasDown {
+		return fmt.Errorf("migration %s_%s has no down file", mig.Version, mig.Name)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := mig.Up
+	if !up {
+		stmt = mig.Down
+	}
+
+	if strings.TrimSpace(stmt) != "" {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("migration %s_%s failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	if up {
+		err = record(ctx, tx, mig)
+	} else {
+		_, err = tx.ExecContext(ctx, "delete from schema_migrations where version = $1", mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if m.Logger != nil {
+		direction := "applied"
+		if !up {
+			direction = "rolled back"
+		}
+		m.Logger.Info("migration "+direction, "version", mig.Version, "name", mig.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"20210902133052_create_rooms_table.up.sql":   {Data: []byte("create table rooms (id serial);")},
+		"20210902133052_create_rooms_table.down.sql": {Data: []byte("drop table rooms;")},
+		"20210902115801_create_user_table.up.sql":    {Data: []byte("create table users (id serial);")},
+		"README.md": {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations but got %d", len(migrations))
+	}
+
+	// sorted by version
+	if migrations[0].Version != "20210902115801" || migrations[0].Name != "create_user_table" {
+		t.Errorf("unexpected first migration %s_%s", migrations[0].Version, migrations[0].Name)
+	}
+	if migrations[0].HasDown {
+		t.Error("expected the first migration to have no down file")
+	}
+	if migrations[1].Down != "drop table rooms;" || !migrations[1].HasDown {
+		t.Error("expected the down file of the second migration to be read")
+	}
+	if migrations[0].Checksum == migrations[1].Checksum || len(migrations[0].Checksum) != 64 {
+		t.Error("expected a sha256 checksum per migration")
+	}
+}
+
+var loadErrorTests = []struct {
+	name string
+	fsys fstest.MapFS
+}{
+	{"no version", fstest.MapFS{"create_user_table.up.sql": {}}},
+	{"down without up", fstest.MapFS{"20210902115801_create_user_table.down.sql": {}}},
+	{"duplicate version", fstest.MapFS{
+		"20210902115801_create_user_table.up.sql": {},
+		"20210902115801_create_users.up.sql":      {},
+	}},
+}
+
+func TestLoadErrors(t *testing.T) {
+
+	for _, e := range loadErrorTests {
+		_, err := Load(e.fsys)
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestLoadMigrationsDir(t *testing.T) {
+
+	migrations, err := Load(os.DirFS("../../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("expected the migrations of the application")
+	}
+
+	for _, m := range migrations {
+		if !m.HasDown {
+			t.Errorf("migration %s_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestToStatus(t *testing.T) {
+
+	migrations := []Migration{
+		{Version: "1", Checksum: "a"},
+		{Version: "2", Checksum: "b"},
+		{Version: "3", Checksum: "c"},
+	}
+	applied := map[string]Status{
+		"1": {Migration: Migration{Checksum: "a"}},
+		"2": {Migration: Migration{Checksum: "changed"}},
+	}
+
+	status := toStatus(migrations, applied)
+
+	if !status[0].Applied || status[0].Modified {
+		t.Error("expected migration 1 to be applied and unmodified")
+	}
+	if !status[1].Applied || !status[1].Modified {
+		t.Error("expected migration 2 to be applied and modified")
+	}
+	if status[2].Applied {
+		t.Error("expected migration 3 to be pending")
+	}
+}
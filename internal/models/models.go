@@ -1,6 +1,25 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Access levels of staff users, stored in users.access_level. Each one is a role, the permissions
+// of the roles are defined in package rbac. The numbers don't rank the roles
+const (
+	AccessLevelFrontDesk = 1
+	AccessLevelManager   = 2
+	AccessLevelAdmin     = 3
+	AccessLevelOwner     = 4
+)
+
+// Restrictions seeded by the migrations, stored in room_restrictions.restriction_id
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+)
 
 // Users is the user model
 type User struct {
@@ -9,19 +28,58 @@ type User struct {
 	LastName    string
 	Email       string
 	Password    string
-	AccessLevel string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	AccessLevel int
+	// SessionVersion is stored in the session on login. Sessions with an older version are no
+	// longer valid, see users.session_version
+	SessionVersion int
+	// TOTPSecret is the base32 secret of the authenticator app, set once enrolment started
+	TOTPSecret string
+	// TwoFactorEnabled is true once the user confirmed the app with a code, logins then ask for one
+	TwoFactorEnabled bool
+	// TwoFactorRequired is true if the two factor policy requires it for the access level of the user
+	TwoFactorRequired bool
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
-// Room is the room model
-type Room struct {
+// Guest is a guest account of the public site, separate from the staff users
+type Guest struct {
 	ID        int
-	RoomName  string
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	Password  string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Property is the property model. Each property is a separate hotel with its own rooms
+type Property struct {
+	ID           int
+	Name         string
+	Slug         string
+	Hostname     string
+	Address      string
+	Timezone     string
+	Currency     string
+	ContactEmail string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Room is the room model
+type Room struct {
+	ID         int
+	RoomName   string
+	PropertyID int
+	// NightlyRate is the price of a night in minor units of the currency of the property
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Property    Property
+}
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -43,6 +101,74 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
+	// GuestID is the guest account which made the reservation, zero for reservations made
+	// without logging in
+	GuestID int
+	// Amount is the price of the stay in minor units, fixed when booking. Zero if unknown
+	Amount int
+}
+
+// Reservation statuses. They follow from the dates of the stay, see Reservation.Status
+const (
+	ReservationUpcoming = "upcoming"
+	ReservationInHouse  = "in_house"
+	ReservationDeparted = "departed"
+)
+
+// Status returns whether the stay is upcoming, going on or over on the day today
+func (r Reservation) Status(today time.Time) string {
+
+	switch {
+	case today.Before(r.StartDate):
+		return ReservationUpcoming
+	case today.Before(r.EndDate):
+		return ReservationInHouse
+	default:
+		return ReservationDeparted
+	}
+}
+
+// Columns reservations can be sorted by
+const (
+	ReservationSortStart    = "start_date"
+	ReservationSortEnd      = "end_date"
+	ReservationSortLastName = "last_name"
+	ReservationSortCreated  = "created_at"
+	ReservationSortID       = "id"
+)
+
+// ReservationFilter selects reservations of a property. Zero fields don't filter
+type ReservationFilter struct {
+	PropertyID int
+	// Name matches words of the first and last name, "smi jo" finds John Smith
+	Name string
+	// Email matches the whole address
+	Email string
+	// Phone matches a part of the number, ignoring everything but digits
+	Phone  string
+	RoomID int
+	// From and To select the stays overlapping the days from From up to To, including To
+	From time.Time
+	To   time.Time
+	// Status is one of the reservation statuses on the day Today, in the timezone of the property.
+	// Today defaults to the current day
+	Status string
+	Today  time.Time
+	// CreatedFrom and CreatedTo select the reservations made on these days, both included
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Sort is one of the sort columns, ReservationSortStart if empty. Ties are sorted by id
+	Sort string
+	Desc bool
+	// Cursor continues the listing after the last reservation of the previous page
+	Cursor string
+	Limit  int
+}
+
+// ReservationPage is a page of reservations. NextCursor is empty on the last page
+type ReservationPage struct {
+	Reservations []Reservation
+	NextCursor   string
 }
 
 // RoomRestriction is the room restriction model
@@ -59,3 +185,159 @@ type RoomRestriction struct {
 	Reservation   Reservation
 	Restriction   Restriction
 }
+
+// RoomRestrictionFilter selects room restrictions of a property. Zero fields don't filter
+type RoomRestrictionFilter struct {
+	PropertyID int
+	RoomID     int
+	// From and To select the restrictions overlapping the days from From up to To, including To
+	From time.Time
+	To   time.Time
+}
+
+// RoomNight is a room on a day of a report. Blocked is set if an owner block takes the room out
+// of the sellable inventory that night, Sold counts the reservations staying that night and
+// Revenue is their share of the night, in minor units
+type RoomNight struct {
+	RoomID   int
+	RoomName string
+	Day      time.Time
+	Blocked  bool
+	Sold     int
+	Revenue  float64
+}
+
+// ImportRow is a row of a bulk import of bookings: a reservation, or an owner block of the room
+// and dates of Reservation if Block is set. Line is the line of the row in the file
+type ImportRow struct {
+	Line        int
+	Block       bool
+	Reservation Reservation
+}
+
+// ImportError is a problem with a row of an import. Field names the column, it is empty for
+// conflicts with other bookings
+type ImportError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+// ImportResult reports an import. The rows with errors are skipped, the counts are the rows
+// imported, or which would be imported if it is a dry run
+type ImportResult struct {
+	Rows         int
+	Reservations int
+	Blocks       int
+	Errors       []ImportError
+	Committed    bool
+}
+
+// Actor types of audit events, stored in audit_events.actor_type
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+	ActorSystem = "system"
+	ActorGuest  = "guest"
+)
+
+// Audit actions, stored in audit_events.action
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Actor is whoever changes data: a logged in user, an API key, a guest on the public site or
+// the system itself, e.g. a command. It is recorded with every audit event
+type Actor struct {
+	Type string
+	// UserID is set for ActorUser
+	UserID int
+	// Label names the API key or the part of the system, e.g. "seed"
+	Label string
+	IP    string
+}
+
+// SystemActor returns the actor for changes made by the application itself
+func SystemActor(label string) Actor {
+	return Actor{Type: ActorSystem, Label: label}
+}
+
+// AuditEvent records one change of a reservation, room restriction, room or user
+type AuditEvent struct {
+	ID         int64
+	Actor      Actor
+	Action     string
+	EntityType string
+	EntityID   int
+	PropertyID int
+	// Before and After hold the changed fields only as JSON objects. Before is empty for
+	// created entities, After for deleted ones
+	Before    []byte
+	After     []byte
+	CreatedAt time.Time
+}
+
+// AuditChange is a field changed by an audit event, formatted for display
+type AuditChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// AuditFilter selects audit events. Zero fields don't filter
+type AuditFilter struct {
+	PropertyID  int
+	EntityType  string
+	EntityID    int
+	Action      string
+	ActorUserID int
+	From        time.Time
+	To          time.Time
+	Limit       int
+}
+
+// Changes returns the changed fields of the event sorted by name
+func (e AuditEvent) Changes() []AuditChange {
+
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+	// the columns are written by the repository, a broken value shows up as an empty change
+	_ = json.Unmarshal(e.Before, &before)
+	_ = json.Unmarshal(e.After, &after)
+
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	var changes []AuditChange
+	for field := range fields {
+		changes = append(changes, AuditChange{
+			Field:  field,
+			Before: formatAuditValue(before[field]),
+			After:  formatAuditValue(after[field]),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+// formatAuditValue formats a JSON value for display, missing values as an empty string
+func formatAuditValue(v interface{}) string {
+
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -13,4 +13,15 @@ type TemplateData struct {
 	Warning   string
 	Error     string
 	Form      *forms.Form
+	// Property is the property the current request is being served for
+	Property        Property
+	IsAuthenticated bool
+	// IsGuest is true if a guest is logged in on the public site
+	IsGuest bool
+	// CSPNonce must be set as nonce attribute on every inline <script>, otherwise the Content
+	// Security Policy blocks it
+	CSPNonce string
+	// AccessLevel of the logged in staff user in the admin area, zero elsewhere. Templates check
+	// permissions with it, e.g. {{if can .AccessLevel "audit:view"}}
+	AccessLevel int
 }
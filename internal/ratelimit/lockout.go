@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout locks a key out after MaxFailures failures within Window. The first lock lasts
+// BaseDelay and every further lock of the same key twice as long, up to MaxDelay. A success
+// resets the key
+type Lockout struct {
+	MaxFailures int
+	Window      time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+	lastSweep time.Time
+
+	// now is replaced in testcases
+	now func() time.Time
+}
+
+type lockoutEntry struct {
+	failures    int
+	firstFailed time.Time
+	locks       int
+	lockedUntil time.Time
+}
+
+// NewLockout returns a lockout with the given policy
+func NewLockout(maxFailures int, window, baseDelay, maxDelay time.Duration) *Lockout {
+
+	return &Lockout{
+		MaxFailures: maxFailures,
+		Window:      window,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		entries:     map[string]*lockoutEntry{},
+		now:         time.Now,
+	}
+}
+
+// DefaultLockout locks out for a minute after 5 failures within 15 minutes, doubling up to an hour
+func DefaultLockout() *Lockout {
+	return NewLockout(5, 15*time.Minute, time.Minute, time.Hour)
+}
+
+// Locked returns how long key is still locked out, zero if it isn't
+func (l *Lockout) Locked(key string) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+
+	if remaining := e.lockedUntil.Sub(l.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Failure records a failure of key and returns how long it is locked out now, zero if it isn't
+func (l *Lockout) Failure(key string) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &lockoutEntry{}
+		l.entries[key] = e
+	}
+
+	if e.failures == 0 || now.Sub(e.firstFailed) > l.Window {
+		e.failures = 0
+		e.firstFailed = now
+	}
+	e.failures++
+
+	if e.failures < l.MaxFailures {
+		return 0
+	}
+
+	delay := l.BaseDelay << e.locks
+	if delay > l.MaxDelay || delay <= 0 {
+		delay = l.MaxDelay
+	}
+	e.locks++
+	e.failures = 0
+	e.lockedUntil = now.Add(delay)
+
+	return delay
+}
+
+// Success resets key
+func (l *Lockout) Success(key string) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// sweep drops keys whose failures and lock are over. A key keeps its lock count while it fails
+// within MaxDelay of its last lock, so that repeated lockouts grow. It runs at most once a minute
+func (l *Lockout) sweep(now time.Time) {
+
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if now.Sub(e.firstFailed) > l.Window && now.Sub(e.lockedUntil) > l.MaxDelay {
+			delete(l.entries, key)
+		}
+	}
+}
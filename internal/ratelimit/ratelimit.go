@@ -0,0 +1,160 @@
+// Package ratelimit limits how often clients may call expensive or sensitive endpoints. Limiter is
+// a token bucket per key, Lockout locks a key out after repeated failures, e.g. failed logins.
+// Both are kept in memory, so every instance of the application enforces its own limits
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the rate tokens are refilled at and the size of the bucket. A zero Limit is off
+type Limit struct {
+	// Rate is the number of requests per second allowed on average
+	Rate float64
+	// Burst is the number of requests allowed at once
+	Burst int
+}
+
+// Off reports whether the limit is disabled
+func (l Limit) Off() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// String formats the limit the way ParseLimit reads it
+func (l Limit) String() string {
+
+	if l.Off() {
+		return "off"
+	}
+	return fmt.Sprintf("%s/m:%d", strconv.FormatFloat(l.Rate*60, 'f', -1, 64), l.Burst)
+}
+
+// ParseLimit parses limits like "30/m:10", i.e. 30 requests per minute with bursts of up to 10.
+// The unit is s, m or h. The burst defaults to the number of requests. "off" disables the limit
+func ParseLimit(s string) (Limit, error) {
+
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	spec, burstStr, hasBurst := strings.Cut(s, ":")
+	countStr, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected e.g. 30/m:10", s)
+	}
+
+	count, err := strconv.ParseFloat(countStr, 64)
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("invalid number of requests in limit %q", s)
+	}
+
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid unit in limit %q, expected s, m or h", s)
+	}
+
+	burst := int(math.Ceil(count))
+	if hasBurst {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid burst in limit %q", s)
+		}
+	}
+
+	return Limit{Rate: count / per.Seconds(), Burst: burst}, nil
+}
+
+// bucket holds the tokens of a key at the time of the last update
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per key
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now is replaced in testcases
+	now func() time.Time
+}
+
+// NewLimiter returns a limiter enforcing limit for every key
+func NewLimiter(limit Limit) *Limiter {
+
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Limit returns the limit enforced
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key. If there is none it returns false and the time
+// until the next token is available
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+
+	if l.limit.Off() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep removes the buckets which are full again, they are the same as a new one. It runs at
+// most once a minute so that the map doesn't grow with every client ever seen
+func (l *Limiter) sweep(now time.Time) {
+
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var parseLimitTests = []struct {
+	in       string
+	expected Limit
+	isError  bool
+}{
+	{"30/m:10", Limit{Rate: 0.5, Burst: 10}, false},
+	{"5/s", Limit{Rate: 5, Burst: 5}, false},
+	{"3600/h:1", Limit{Rate: 1, Burst: 1}, false},
+	{"off", Limit{}, false},
+	{"", Limit{}, false},
+	{"30", Limit{}, true},
+	{"30/d", Limit{}, true},
+	{"x/m", Limit{}, true},
+	{"30/m:0", Limit{}, true},
+}
+
+func TestParseLimit(t *testing.T) {
+
+	for _, e := range parseLimitTests {
+		l, err := ParseLimit(e.in)
+		if e.isError {
+			if err == nil {
+				t.Errorf("%q: expected an error", e.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", e.in, err)
+		}
+		if l != e.expected {
+			t.Errorf("%q: expected %+v but got %+v", e.in, e.expected, l)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	// the burst is allowed at once
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d: expected to be allowed", i+1)
+		}
+	}
+
+	// then the bucket is empty
+	ok, wait := l.Allow("1.2.3.4")
+	if ok {
+		t.Error("expected the third request to be limited")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s but got %s", wait)
+	}
+
+	// other keys have their own bucket
+	if ok, _ := l.Allow("5.6.7.8"); !ok {
+		t.Error("expected another key to be allowed")
+	}
+
+	// a token is refilled every second
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Error("expected a request to be allowed after the refill")
+	}
+
+	// full buckets are swept
+	now = now.Add(time.Hour)
+	l.Allow("9.9.9.9")
+	if len(l.buckets) != 1 {
+		t.Errorf("expected idle buckets to be swept but there are %d", len(l.buckets))
+	}
+}
+
+func TestLimiterOff(t *testing.T) {
+
+	l := NewLimiter(Limit{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("key"); !ok {
+			t.Fatal("expected a disabled limiter to allow everything")
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLockout(3, 10*time.Minute, time.Minute, 3*time.Minute)
+	l.now = func() time.Time { return now }
+
+	// case 1: locked after the third failure
+	for i := 0; i < 2; i++ {
+		if d := l.Failure("admin@here.com"); d != 0 {
+			t.Fatalf("failure %d: expected no lock but got %s", i+1, d)
+		}
+	}
+	if d := l.Failure("admin@here.com"); d != time.Minute {
+		t.Errorf("expected a lock of 1m but got %s", d)
+	}
+	if d := l.Locked("admin@here.com"); d != time.Minute {
+		t.Errorf("expected to be locked for 1m but got %s", d)
+	}
+
+	// case 2: the next lock is twice as long, capped at MaxDelay
+	now = now.Add(time.Minute)
+	if d := l.Locked("admin@here.com"); d != 0 {
+		t.Errorf("expected the lock to be over but got %s", d)
+	}
+	for i := 0; i < 3; i++ {
+		l.Failure("admin@here.com")
+	}
+	if d := l.Locked("admin@here.com"); d != 2*time.Minute {
+		t.Errorf("expected to be locked for 2m but got %s", d)
+	}
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		l.Failure("admin@here.com")
+	}
+	if d := l.Locked("admin@here.com"); d != 3*time.Minute {
+		t.Errorf("expected to be locked for 3m but got %s", d)
+	}
+
+	// case 3: failures outside the window don't add up
+	l.Failure("staff@here.com")
+	l.Failure("staff@here.com")
+	now = now.Add(11 * time.Minute)
+	if d := l.Failure("staff@here.com"); d != 0 {
+		t.Errorf("expected no lock for failures outside the window but got %s", d)
+	}
+
+	// case 4: success resets
+	l.Success("admin@here.com")
+	if d := l.Locked("admin@here.com"); d != 0 {
+		t.Errorf("expected no lock after a success but got %s", d)
+	}
+}
@@ -0,0 +1,113 @@
+// Package rbac maps the access level of a staff user, stored in users.access_level, to a role and
+// the role to its permissions. Routes and handlers check permissions, never access levels, so that
+// the matrix below is the only place deciding who may do what
+package rbac
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// Permission is something a staff user may be allowed to do
+type Permission string
+
+// The permissions checked by the admin area. Add one along with the route which requires it
+const (
+	ViewDashboard    Permission = "dashboard:view"
+	ViewReservations Permission = "reservations:view"
+	ViewAuditLog     Permission = "audit:view"
+	ViewReports      Permission = "reports:view"
+	ExportData       Permission = "data:export"
+	ImportData       Permission = "data:import"
+	ManageSecurity   Permission = "security:manage"
+)
+
+// Role is the name of an access level
+type Role struct {
+	AccessLevel int
+	Name        string
+}
+
+// Roles are the roles in the order shown to users
+var Roles = []Role{
+	{models.AccessLevelFrontDesk, "Front desk"},
+	{models.AccessLevelManager, "Manager"},
+	{models.AccessLevelOwner, "Owner"},
+	{models.AccessLevelAdmin, "Admin"},
+}
+
+// frontDesk handles the guests of the day
+var frontDesk = []Permission{
+	ViewDashboard,
+	ViewReservations,
+}
+
+// manager runs the property
+var manager = append(append([]Permission{}, frontDesk...),
+	ViewAuditLog,
+	ViewReports,
+	ExportData,
+)
+
+// owner also imports data
+var owner = append(append([]Permission{}, manager...),
+	ImportData,
+)
+
+// matrix holds the permissions of every access level. Admins may do everything
+var matrix = map[int]map[Permission]bool{
+	models.AccessLevelFrontDesk: set(frontDesk),
+	models.AccessLevelManager:   set(manager),
+	models.AccessLevelOwner:     set(owner),
+	models.AccessLevelAdmin:     set(All()),
+}
+
+func set(permissions []Permission) map[Permission]bool {
+
+	m := map[Permission]bool{}
+	for _, p := range permissions {
+		m[p] = true
+	}
+	return m
+}
+
+// All returns every permission sorted by name
+func All() []Permission {
+
+	all := []Permission{
+		ViewDashboard,
+		ViewReservations,
+		ViewAuditLog,
+		ViewReports,
+		ExportData,
+		ImportData,
+		ManageSecurity,
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	return all
+}
+
+// Can reports whether users of the access level have the permission. Unknown access levels have
+// no permission at all
+func Can(accessLevel int, p Permission) bool {
+	return matrix[accessLevel][p]
+}
+
+// Valid reports whether p is a known permission
+func Valid(p Permission) bool {
+	return matrix[models.AccessLevelAdmin][p]
+}
+
+// RoleName returns the name of the role of an access level
+func RoleName(accessLevel int) string {
+
+	for _, r := range Roles {
+		if r.AccessLevel == accessLevel {
+			return r.Name
+		}
+	}
+	return fmt.Sprintf("Unknown (%d)", accessLevel)
+}
@@ -0,0 +1,59 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+var canTests = []struct {
+	name        string
+	accessLevel int
+	permission  Permission
+	expected    bool
+}{
+	{"front-desk-reservations", models.AccessLevelFrontDesk, ViewReservations, true},
+	{"front-desk-audit", models.AccessLevelFrontDesk, ViewAuditLog, false},
+	{"front-desk-export", models.AccessLevelFrontDesk, ExportData, false},
+	{"manager-audit", models.AccessLevelManager, ViewAuditLog, true},
+	{"manager-import", models.AccessLevelManager, ImportData, false},
+	{"owner-import", models.AccessLevelOwner, ImportData, true},
+	{"owner-security", models.AccessLevelOwner, ManageSecurity, false},
+	{"admin-security", models.AccessLevelAdmin, ManageSecurity, true},
+	{"unknown-level", 0, ViewDashboard, false},
+	{"unknown-permission", models.AccessLevelAdmin, Permission("fish:eat"), false},
+}
+
+func TestCan(t *testing.T) {
+
+	for _, e := range canTests {
+		if got := Can(e.accessLevel, e.permission); got != e.expected {
+			t.Errorf("failed %s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+
+	// every role can see the dashboard, otherwise its users couldn't use the admin area at all
+	for _, r := range Roles {
+		if !Can(r.AccessLevel, ViewDashboard) {
+			t.Errorf("role %s can't view the dashboard", r.Name)
+		}
+		if RoleName(r.AccessLevel) != r.Name {
+			t.Errorf("expected role name %s but got %s", r.Name, RoleName(r.AccessLevel))
+		}
+	}
+
+	// the roles build on each other
+	for _, p := range All() {
+		if Can(models.AccessLevelFrontDesk, p) && !Can(models.AccessLevelManager, p) ||
+			Can(models.AccessLevelManager, p) && !Can(models.AccessLevelOwner, p) ||
+			Can(models.AccessLevelOwner, p) && !Can(models.AccessLevelAdmin, p) {
+			t.Errorf("permission %s is not inherited by the higher roles", p)
+		}
+		if !Valid(p) {
+			t.Errorf("permission %s is not valid", p)
+		}
+	}
+}
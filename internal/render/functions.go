@@ -0,0 +1,248 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/assets"
+	"github.com/prayagsingh/bookings/internal/rbac"
+)
+
+// functions are available to all the templates. They must be added to the template before parsing
+var functions = template.FuncMap{
+	"humanDate":  HumanDate,
+	"formatDate": FormatDate,
+	"nights":     Nights,
+	"currency":   Currency,
+	"percent":    Percent,
+	"add":        Add,
+	"iterate":    Iterate,
+	"url":        URL,
+	"toJSON":     ToJSON,
+	"asset":      Asset,
+	"can":        Can,
+	"roleName":   rbac.RoleName,
+}
+
+// Functions returns the template functions so that other packages (and their tests) can parse
+// templates the same way CreateTemplateCache does
+func Functions() template.FuncMap {
+	return functions
+}
+
+// HumanDate returns the date in a human readable format like "02 Jan 2006"
+func HumanDate(t time.Time) string {
+
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02 Jan 2006")
+}
+
+// FormatDate returns the date formatted with the given layout, e.g. {{formatDate .StartDate "2006-01-02"}}
+func FormatDate(t time.Time, layout string) string {
+
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// Nights returns the number of nights between the arrival and the departure date. The time of day is
+// ignored so a stay from 2021-10-01 to 2021-10-03 is always 2 nights
+func Nights(start, end time.Time) int {
+
+	sy, sm, sd := start.Date()
+	ey, em, ed := end.Date()
+
+	s := time.Date(sy, sm, sd, 0, 0, 0, 0, time.UTC)
+	e := time.Date(ey, em, ed, 0, 0, 0, 0, time.UTC)
+
+	n := int(e.Sub(s).Hours() / 24)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// currencySymbols maps the ISO 4217 codes stored in properties.currency to their symbol
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"INR": "₹",
+	"JPY": "¥",
+}
+
+// zeroDecimalCurrencies have no minor unit
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+}
+
+// Currency formats an amount given in minor units (cents) for the currency code,
+// e.g. {{currency 123456 "USD"}} gives $1,234.56. Unknown codes are printed after the amount
+func Currency(amount int, code string) string {
+
+	code = strings.ToUpper(code)
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	var number string
+	if zeroDecimalCurrencies[code] {
+		number = groupThousands(amount)
+	} else {
+		number = fmt.Sprintf("%s.%02d", groupThousands(amount/100), amount%100)
+	}
+
+	if symbol, ok := currencySymbols[code]; ok {
+		return sign + symbol + number
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s%s %s", sign, number, code))
+}
+
+// Decimal formats an amount given in minor units as a plain number without grouping or symbol,
+// e.g. 123456 "USD" gives 1234.56, for files read by spreadsheets
+func Decimal(amount int, code string) string {
+
+	if zeroDecimalCurrencies[strings.ToUpper(code)] {
+		return fmt.Sprintf("%d", amount)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// Percent formats a ratio as a percentage with one decimal, e.g. {{percent 0.725}} gives 72.5%
+func Percent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+// groupThousands prints n with a comma between every group of three digits
+func groupThousands(n int) string {
+
+	s := fmt.Sprintf("%d", n)
+	if len(s) <= 3 {
+		return s
+	}
+
+	var b strings.Builder
+	pre := len(s) % 3
+	if pre > 0 {
+		b.WriteString(s[:pre])
+	}
+	for i := pre; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
+
+// Add returns the sum of a and b. Used for calendars and pagination
+func Add(a, b int) int {
+	return a + b
+}
+
+// Iterate returns a slice of ints from 0 to count-1 so templates can range over a number,
+// e.g. {{range $i := iterate 7}}
+func Iterate(count int) []int {
+
+	var items []int
+	for i := 0; i < count; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+// URL builds a URL from a route pattern. Every {param} in the pattern is replaced with the next
+// argument and the remaining arguments are added as key/value query parameters, all escaped,
+// e.g. {{url "/choose-room/{id}" .ID}} or {{url "/book-room" "id" 1 "s" "2021-10-01"}}
+func URL(pattern string, args ...interface{}) (string, error) {
+
+	var b strings.Builder
+	rest := pattern
+	for {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			b.WriteString(rest)
+			break
+		}
+
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("url: unclosed parameter in %q", pattern)
+		}
+
+		if len(args) == 0 {
+			return "", fmt.Errorf("url: missing value for %s in %q", rest[open:open+end+1], pattern)
+		}
+
+		b.WriteString(rest[:open])
+		b.WriteString(url.PathEscape(fmt.Sprint(args[0])))
+		args = args[1:]
+		rest = rest[open+end+1:]
+	}
+
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("url: odd number of query arguments for %q", pattern)
+	}
+
+	if len(args) > 0 {
+		query := url.Values{}
+		for i := 0; i < len(args); i += 2 {
+			query.Add(fmt.Sprint(args[i]), fmt.Sprint(args[i+1]))
+		}
+		b.WriteString("?")
+		b.WriteString(query.Encode())
+	}
+
+	return b.String(), nil
+}
+
+// ToJSON marshals v so it can be embedded safely inside a <script> block,
+// e.g. const rooms = {{toJSON .Data.rooms}}; json.Marshal escapes <, > and & so the value
+// can't close the script element
+func ToJSON(v interface{}) (template.JS, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(b), nil
+}
+
+// Asset returns the URL of a static file, fingerprinted in production so that browsers can cache
+// it for good, e.g. {{asset "css/style.css"}}
+func Asset(name string) string {
+
+	if app == nil || app.Assets == nil {
+		return assets.Prefix + strings.TrimPrefix(name, "/")
+	}
+	return app.Assets.Path(name)
+}
+
+// Can reports whether users of the access level have the permission, e.g. to hide controls they
+// can't use with {{if can .AccessLevel "audit:view"}}. Unknown permissions are an error to catch
+// typos
+func Can(accessLevel int, permission string) (bool, error) {
+
+	p := rbac.Permission(permission)
+	if !rbac.Valid(p) {
+		return false, fmt.Errorf("can: unknown permission %q", permission)
+	}
+	return rbac.Can(accessLevel, p), nil
+}
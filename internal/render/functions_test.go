@@ -0,0 +1,204 @@
+package render
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHumanDate(t *testing.T) {
+
+	d := time.Date(2021, 10, 1, 15, 4, 5, 0, time.UTC)
+	if got := HumanDate(d); got != "01 Oct 2021" {
+		t.Errorf("expected 01 Oct 2021 but got %s", got)
+	}
+
+	if got := HumanDate(time.Time{}); got != "" {
+		t.Errorf("expected empty string for zero time but got %s", got)
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+
+	d := time.Date(2021, 10, 1, 15, 4, 5, 0, time.UTC)
+	if got := FormatDate(d, "2006-01-02"); got != "2021-10-01" {
+		t.Errorf("expected 2021-10-01 but got %s", got)
+	}
+
+	if got := FormatDate(time.Time{}, "2006-01-02"); got != "" {
+		t.Errorf("expected empty string for zero time but got %s", got)
+	}
+}
+
+var nightsTests = []struct {
+	name     string
+	start    time.Time
+	end      time.Time
+	expected int
+}{
+	{"two-nights", time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 3, 0, 0, 0, 0, time.UTC), 2},
+	{"time-of-day-ignored", time.Date(2021, 10, 1, 23, 0, 0, 0, time.UTC), time.Date(2021, 10, 2, 1, 0, 0, 0, time.UTC), 1},
+	{"same-day", time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC), 0},
+	{"end-before-start", time.Date(2021, 10, 3, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC), 0},
+	{"across-dst", time.Date(2021, 3, 27, 0, 0, 0, 0, time.Local), time.Date(2021, 3, 29, 0, 0, 0, 0, time.Local), 2},
+}
+
+func TestNights(t *testing.T) {
+
+	for _, e := range nightsTests {
+		if got := Nights(e.start, e.end); got != e.expected {
+			t.Errorf("failed %s: expected %d nights but got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+var currencyTests = []struct {
+	amount   int
+	code     string
+	expected string
+}{
+	{123456, "USD", "$1,234.56"},
+	{5, "usd", "$0.05"},
+	{-1999, "EUR", "-€19.99"},
+	{123456789, "INR", "₹1,234,567.89"},
+	{1500, "JPY", "¥1,500"},
+	{1000, "CHF", "10.00 CHF"},
+}
+
+func TestCurrency(t *testing.T) {
+
+	for _, e := range currencyTests {
+		if got := Currency(e.amount, e.code); got != e.expected {
+			t.Errorf("currency %d %s: expected %s but got %s", e.amount, e.code, e.expected, got)
+		}
+	}
+}
+
+var decimalTests = []struct {
+	amount   int
+	code     string
+	expected string
+}{
+	{123456, "USD", "1234.56"},
+	{-1999, "EUR", "-19.99"},
+	{5, "usd", "0.05"},
+	{1500, "JPY", "1500"},
+}
+
+func TestDecimal(t *testing.T) {
+
+	for _, e := range decimalTests {
+		if got := Decimal(e.amount, e.code); got != e.expected {
+			t.Errorf("decimal %d %s: expected %s but got %s", e.amount, e.code, e.expected, got)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+
+	if got := Percent(0.7254); got != "72.5%" {
+		t.Errorf("expected 72.5%% but got %s", got)
+	}
+	if got := Percent(0); got != "0.0%" {
+		t.Errorf("expected 0.0%% but got %s", got)
+	}
+}
+
+func TestAddAndIterate(t *testing.T) {
+
+	if Add(2, 3) != 5 {
+		t.Error("expected 2 + 3 to be 5")
+	}
+
+	items := Iterate(3)
+	if len(items) != 3 || items[0] != 0 || items[2] != 2 {
+		t.Errorf("expected [0 1 2] but got %v", items)
+	}
+
+	if len(Iterate(0)) != 0 {
+		t.Error("expected no items for iterate 0")
+	}
+}
+
+var urlTests = []struct {
+	name     string
+	pattern  string
+	args     []interface{}
+	expected string
+	isError  bool
+}{
+	{"no-params", "/about", nil, "/about", false},
+	{"path-param", "/choose-room/{id}", []interface{}{1}, "/choose-room/1", false},
+	{"escaped-path-param", "/p/{slug}/about", []interface{}{"a b"}, "/p/a%20b/about", false},
+	{"query", "/book-room", []interface{}{"id", 1, "s", "2021-10-01"}, "/book-room?id=1&s=2021-10-01", false},
+	{"path-and-query", "/choose-room/{id}", []interface{}{1, "s", "a&b"}, "/choose-room/1?s=a%26b", false},
+	{"missing-param", "/choose-room/{id}", nil, "", true},
+	{"unclosed-param", "/choose-room/{id", []interface{}{1}, "", true},
+	{"odd-query", "/book-room", []interface{}{"id"}, "", true},
+}
+
+func TestURL(t *testing.T) {
+
+	for _, e := range urlTests {
+		got, err := URL(e.pattern, e.args...)
+		if e.isError {
+			if err == nil {
+				t.Errorf("failed %s: expected an error", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+
+		if got != e.expected {
+			t.Errorf("failed %s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+
+	tmpl := template.Must(template.New("t").Funcs(functions).Parse(`<script>const v = {{toJSON .}};</script>`))
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]string{"name": "</script><b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "</script><b>") {
+		t.Errorf("script element was not escaped: %s", out)
+	}
+
+	if !strings.Contains(out, `{"name":"\u003c/script\u003e\u003cb\u003e"}`) {
+		t.Errorf("unexpected json output: %s", out)
+	}
+}
+
+func TestAsset(t *testing.T) {
+
+	// without a manifest the plain path is used
+	if got := Asset("css/style.css"); got != "/static/css/style.css" {
+		t.Errorf("expected /static/css/style.css but got %s", got)
+	}
+}
+
+func TestCan(t *testing.T) {
+
+	if ok, err := Can(3, "audit:view"); err != nil || !ok {
+		t.Errorf("expected admins to view the audit log, got %v %v", ok, err)
+	}
+
+	if ok, _ := Can(1, "audit:view"); ok {
+		t.Error("expected the front desk not to view the audit log")
+	}
+
+	if _, err := Can(3, "audit:veiw"); err == nil {
+		t.Error("expected an error for an unknown permission")
+	}
+}
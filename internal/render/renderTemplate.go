@@ -5,16 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
-	"path/filepath"
+	"os"
+	"path"
 
 	"github.com/justinas/nosurf"
 	"github.com/prayagsingh/bookings/internal/config"
 	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/requestctx"
 )
 
-var functions = template.FuncMap{}
-
 var app *config.AppConfig
 
 // testcases can access the templates from root folder
@@ -37,78 +38,211 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 
 	td.CSRFToken = nosurf.Token(r)
 
+	if app.Session.Exists(r.Context(), "user_id") {
+		td.IsAuthenticated = true
+	}
+	td.IsGuest = app.Session.Exists(r.Context(), "guest_id")
+
+	// the staff user is loaded by the Auth middleware in the admin area
+	if u, ok := requestctx.User(r.Context()); ok {
+		td.AccessLevel = u.AccessLevel
+	}
+
+	// set by the SecureHeaders middleware
+	td.CSPNonce = requestctx.CSPNonce(r.Context())
+
+	// the property is resolved by the PropertyLoad middleware from the hostname or path prefix
+	if p, ok := requestctx.Property(r.Context()); ok {
+		td.Property = p
+	}
+
 	return td
 }
 
 // Template for rendering the template using html/template
 func Template(rw http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
 
-	var templateCache map[string]*template.Template
+	return renderTemplate(rw, r, tmpl, td, http.StatusOK)
+}
+
+// ErrorPage renders the error page for status, e.g. 404.page.html. Statuses without their own
+// page use error.page.html. Outside production err is shown on the page to help debugging
+func ErrorPage(rw http.ResponseWriter, r *http.Request, status int, err error) error {
+
+	data := make(map[string]interface{})
+	data["status"] = status
+	data["status_text"] = http.StatusText(status)
+	if err != nil && !app.InProduction {
+		data["error"] = err.Error()
+	}
+
+	tmpl := fmt.Sprintf("%d.page.html", status)
+	if _, ok := templateCache()[tmpl]; !ok {
+		tmpl = "error.page.html"
+	}
+
+	return renderTemplate(rw, r, tmpl, &models.TemplateData{Data: data}, status)
+}
+
+// Ready reports whether pages can be rendered, i.e. the template cache is loaded and holds at
+// least one page. It is used by the readiness check
+func Ready() error {
+
+	if app == nil {
+		return errors.New("renderer is not configured")
+	}
+
+	tc, _, err := currentTemplateCache()
+	if err != nil {
+		return err
+	}
+	if len(tc) == 0 {
+		return errors.New("template cache is empty")
+	}
+
+	return nil
+}
+
+// templateCache returns the template cache to render from. Errors are ignored here, they are
+// reported by renderTemplate
+func templateCache() map[string]*template.Template {
+
+	tc, _, _ := currentTemplateCache()
+	return tc
+}
+
+// currentTemplateCache returns the template cache to render from. parseErr is set in development
+// when the templates on disk don't parse; the last good cache is still returned then
+func currentTemplateCache() (tc map[string]*template.Template, parseErr error, err error) {
+
 	// In Production load template from template Cache
 	if app.UseCache {
-		templateCache = app.TemplateCache
-	} else {
-		templateCache, _ = CreateTemplateCache()
+		return app.TemplateCache, nil, nil
+	}
+
+	if templateWatcher != nil {
+		tc, parseErr = templateWatcher.get()
+		return tc, parseErr, nil
 	}
-	// ok is used to check if the template exists or not
-	// if template found, ok wil return true else false
-	t, ok := templateCache[tmpl]
 
-	//fmt.Println("t is ", t.DefinedTemplates())
+	tc, err = CreateTemplateCache()
+	return tc, nil, err
+}
+
+// renderTemplate executes the template into a buffer first so that nothing is sent to the client
+// if it fails, and the caller can still respond with an error page
+func renderTemplate(rw http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData, status int) error {
 
+	tc, parseErr, err := currentTemplateCache()
+	if err != nil {
+		return fmt.Errorf("can't create template cache: %w", err)
+	}
+
+	// ok is used to check if the template exists or not
+	// if template found, ok wil return true else false
+	t, ok := tc[tmpl]
 	if !ok {
-		return errors.New("can't get template from cache")
+		if parseErr != nil {
+			return parseErr
+		}
+		return fmt.Errorf("can't get template %s from cache", tmpl)
 	}
 
 	buf := new(bytes.Buffer)
 	td = AddDefaultData(td, r)
-	err := t.Execute(buf, td)
+	err = t.Execute(buf, td)
 	if err != nil {
-		fmt.Println("Error writing parsed template to buffer", err)
-		return nil
+		return fmt.Errorf("can't execute template %s: %w", tmpl, err)
+	}
+
+	out := buf.Bytes()
+	if parseErr != nil {
+		out = injectParseError(out, parseErr)
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if status != http.StatusOK {
+		rw.WriteHeader(status)
 	}
-	_, err = buf.WriteTo(rw)
 
+	_, err = rw.Write(out)
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
-		return nil
+		return fmt.Errorf("can't write template %s to browser: %w", tmpl, err)
 	}
 
 	return nil
 }
 
+// parseErrorBanner is shown on top of the page in development when a template doesn't parse.
+// The error of html/template contains the file name and line number
+const parseErrorBanner = `<div style="position:fixed;top:0;left:0;right:0;z-index:100000;padding:1em;` +
+	`background:#842029;color:#fff;font-family:monospace;white-space:pre-wrap">` +
+	`<strong>Template error, showing the last good version:</strong>
+%s</div>`
+
+// injectParseError adds the parse error banner to the page just before </body>
+func injectParseError(page []byte, parseErr error) []byte {
+
+	banner := []byte(fmt.Sprintf(parseErrorBanner, template.HTMLEscapeString(parseErr.Error())))
+
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, banner...)
+	}
+
+	out := make([]byte, 0, len(page)+len(banner))
+	out = append(out, page[:i]...)
+	out = append(out, banner...)
+	return append(out, page[i:]...)
+}
+
+// templateFS returns the file system the templates are read from. It is the one set on the
+// AppConfig (embedded or a directory on disk) and falls back to pathToTemplates for testcases
+func templateFS() fs.FS {
+
+	if app != nil && app.TemplateFS != nil {
+		return app.TemplateFS
+	}
+	return os.DirFS(pathToTemplates)
+}
+
 // CreateTemplateCache creates a template cache as a map
 func CreateTemplateCache() (map[string]*template.Template, error) {
 
+	return CreateTemplateCacheFS(templateFS())
+}
+
+// CreateTemplateCacheFS creates a template cache as a map from the templates in fsys
+func CreateTemplateCacheFS(fsys fs.FS) (map[string]*template.Template, error) {
+
 	// store the template found during parsing
 	myCache := map[string]*template.Template{}
 
 	// find all the pages in template dir which ends with page.html
-	pages, err := filepath.Glob(fmt.Sprintf("%s/*.page.html", pathToTemplates))
+	pages, err := fs.Glob(fsys, "*.page.html")
 	if err != nil {
 		return myCache, err
 	}
 
 	for _, page := range pages {
-		name := filepath.Base(page)
-		//fmt.Printf("Page is currently %s and name is %s \n", page, name)
+		name := path.Base(page)
 		// New allocates the new template with a given name
 		// Funcs adds the elements of the argument map to the template's function map. It must be
 		// 	 called before the template is parsed.
-		// ParseFiles parses the named files and associates the resulting templates with t. If an
+		// ParseFS parses the named files and associates the resulting templates with t. If an
 		//   error occurs, parsing stops and the returned template is nil otherwise it is t
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(functions).ParseFS(fsys, page)
 		if err != nil {
 			return myCache, err
 		}
 
-		matches, err := filepath.Glob(fmt.Sprintf("%s/*.layout.html", pathToTemplates))
+		matches, err := fs.Glob(fsys, "*.layout.html")
 		if err != nil {
 			return myCache, err
 		}
 
 		if len(matches) > 0 {
-			ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.html", pathToTemplates))
+			ts, err = ts.ParseFS(fsys, "*.layout.html")
 			if err != nil {
 				return myCache, err
 			}
@@ -116,8 +250,6 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 		// Adding templates to cache
 		myCache[name] = ts
-		//fmt.Println("Defined templates stored in myCache is ", myCache[name].DefinedTemplates())
-		//fmt.Println("template stored in myCache is ", myCache[name].Tree.Name)
 	}
 
 	return myCache, nil
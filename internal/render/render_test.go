@@ -2,6 +2,7 @@ package render
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/prayagsingh/bookings/internal/models"
@@ -95,3 +96,76 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestErrorPage(t *testing.T) {
+
+	pathToTemplates = "./../../templates"
+
+	r, err := getSession()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// 404 has its own page, 418 falls back to error.page.html
+	for _, status := range []int{http.StatusNotFound, http.StatusTeapot} {
+		rr := httptest.NewRecorder()
+		err = ErrorPage(rr, r, status, nil)
+		if err != nil {
+			t.Errorf("can't render error page for %d: %s", status, err)
+		}
+
+		if rr.Code != status {
+			t.Errorf("expected status %d but got %d", status, rr.Code)
+		}
+	}
+}
+
+func TestRenderTemplateExecuteError(t *testing.T) {
+
+	pathToTemplates = "./../../templates"
+
+	r, err := getSession()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// make-reservation.page.html needs a form, executing it without one must fail
+	// and nothing must be written to the client
+	rr := httptest.NewRecorder()
+	err = Template(rr, r, "make-reservation.page.html", &models.TemplateData{})
+	if err == nil {
+		t.Error("expected an error when executing the template fails")
+	}
+
+	if rr.Body.Len() != 0 {
+		t.Error("expected nothing to be written when executing the template fails")
+	}
+}
+
+func TestReady(t *testing.T) {
+
+	pathToTemplates = "./../../templates"
+
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.UseCache = true
+	defer func() {
+		app.UseCache = false
+		app.TemplateCache = nil
+	}()
+
+	// case 1: template cache isn't loaded
+	app.TemplateCache = nil
+	if Ready() == nil {
+		t.Error("expected an error when the template cache is empty")
+	}
+
+	// case 2: template cache is loaded
+	app.TemplateCache = tc
+	if err := Ready(); err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/logging"
 	"github.com/prayagsingh/bookings/internal/models"
 )
 
@@ -24,16 +25,12 @@ func TestMain(t *testing.M) {
 	// set it to true when in production
 	testApp.InProduction = false
 
-	// initialzing logger and printing logs to terminal
-	infoLog := log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
-	// make infoLog app wide variable
-	testApp.InfoLog = infoLog
-
-	// initializng error logger
-	// Lshotfile will give the info about the error
-	errorLog := log.New(os.Stdout, "Error:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	// make errorLog app wide variable
-	testApp.ErrorLog = errorLog
+	// structured logger printing logs to terminal
+	logger, err := logging.New(os.Stdout, logging.FormatLogfmt, "info")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testApp.Logger = logger
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -53,7 +50,7 @@ func TestMain(t *testing.M) {
 type myWriter struct{}
 
 func (tw *myWriter) Header() http.Header {
-	var h http.Header
+	h := http.Header{}
 	return h
 }
 
@@ -0,0 +1,180 @@
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watcher rebuilds the template cache when a file below the template directory changes. It is used
+// in development mode (UseCache = false) instead of parsing every template on every request. If a
+// rebuild fails the last good cache keeps being served together with the parse error.
+//
+// It polls instead of subscribing to file system events: it works on any fs.FS, needs no
+// dependency and also sees changes on bind mounts and network shares, which often don't deliver
+// events. Stating the few dozen templates once a second costs next to nothing
+type watcher struct {
+	fsys     fs.FS
+	interval time.Duration
+
+	mu          sync.RWMutex
+	cache       map[string]*template.Template
+	err         error
+	fingerprint string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// the watcher used by Template when the cache is disabled
+var templateWatcher *watcher
+
+// StartWatcher builds the template cache and starts polling the template directory for changes every
+// interval, see watcher. The returned func stops the watcher
+func StartWatcher(interval time.Duration) func() {
+
+	w := newWatcher(templateFS(), interval)
+	w.start()
+	templateWatcher = w
+
+	return func() {
+		w.close()
+		if templateWatcher == w {
+			templateWatcher = nil
+		}
+	}
+}
+
+func newWatcher(fsys fs.FS, interval time.Duration) *watcher {
+
+	w := &watcher{
+		fsys:     fsys,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.check()
+
+	return w
+}
+
+func (w *watcher) start() {
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.check()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (w *watcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+// check rebuilds the cache if any template was added, removed or modified since the last check.
+// It returns true if the cache was rebuilt
+func (w *watcher) check() bool {
+
+	fingerprint, err := w.scan()
+	if err != nil {
+		w.setError(err)
+		return false
+	}
+
+	w.mu.RLock()
+	unchanged := fingerprint == w.fingerprint
+	w.mu.RUnlock()
+
+	if unchanged {
+		return false
+	}
+
+	tc, err := CreateTemplateCacheFS(w.fsys)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.fingerprint = fingerprint
+	if err != nil {
+		// keep the last good cache
+		w.err = err
+		logError("template cache not rebuilt", err)
+		return false
+	}
+
+	w.cache = tc
+	w.err = nil
+	logInfo("template cache rebuilt")
+	return true
+}
+
+// scan returns a fingerprint of the name, size and modification time of every file
+func (w *watcher) scan() (string, error) {
+
+	var entries []string
+	err := fs.WalkDir(w.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(entries)
+	return strings.Join(entries, "\n"), nil
+}
+
+func (w *watcher) setError(err error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// get returns the last good cache together with the error of the latest rebuild, if any
+func (w *watcher) get() (map[string]*template.Template, error) {
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cache, w.err
+}
+
+func logInfo(msg string) {
+	if app != nil && app.Logger != nil {
+		app.Logger.Info(msg)
+	}
+}
+
+func logError(msg string, err error) {
+	if app != nil && app.Logger != nil {
+		app.Logger.Error(msg, "error", err.Error())
+	}
+}
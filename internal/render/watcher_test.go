@@ -0,0 +1,85 @@
+package render
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string) {
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+
+	dir := t.TempDir()
+	writeTemplate(t, dir, "base.layout.html", `{{define "base"}}<body>{{block "content" .}}{{end}}</body>{{end}}`)
+	writeTemplate(t, dir, "home.page.html", `{{template "base" .}}{{define "content"}}home{{end}}`)
+
+	w := newWatcher(os.DirFS(dir), time.Hour)
+
+	tc, err := w.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tc["home.page.html"]; !ok {
+		t.Fatal("expected home.page.html in the cache")
+	}
+
+	// nothing changed, the cache must not be rebuilt
+	if w.check() {
+		t.Error("cache rebuilt although no template changed")
+	}
+
+	// a broken template keeps the last good cache and reports the error
+	writeTemplate(t, dir, "home.page.html", `{{template "base" .}}{{define "content"}}{{.Broken}{{end}}`)
+	if w.check() {
+		t.Error("cache rebuilt from a broken template")
+	}
+
+	tc2, err := w.get()
+	if err == nil {
+		t.Error("expected a parse error")
+	} else if !strings.Contains(err.Error(), "home.page.html:1") {
+		t.Errorf("expected the parse error to contain the file and line but got %s", err)
+	}
+	if tc2["home.page.html"] != tc["home.page.html"] {
+		t.Error("expected the last good template to be kept")
+	}
+
+	// a new page is picked up and clears the error
+	writeTemplate(t, dir, "home.page.html", `{{template "base" .}}{{define "content"}}home{{end}}`)
+	writeTemplate(t, dir, "about.page.html", `{{template "base" .}}{{define "content"}}about{{end}}`)
+	if !w.check() {
+		t.Error("expected the cache to be rebuilt")
+	}
+
+	tc3, err := w.get()
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := tc3["about.page.html"]; !ok {
+		t.Error("expected about.page.html in the rebuilt cache")
+	}
+}
+
+func TestInjectParseError(t *testing.T) {
+
+	page := []byte("<html><body><p>hello</p></body></html>")
+	out := string(injectParseError(page, errors.New(`template: home.page.html:3: unexpected "<"`)))
+
+	if !strings.HasSuffix(out, "</body></html>") {
+		t.Errorf("expected the banner before </body> but got %s", out)
+	}
+
+	if !strings.Contains(out, "home.page.html:3: unexpected &#34;&lt;&#34;") {
+		t.Errorf("expected the escaped error in the page but got %s", out)
+	}
+}
@@ -0,0 +1,245 @@
+// Package reports computes the occupancy and revenue figures of a property from its room nights:
+// the occupancy rate, the room nights sold, the average daily rate (ADR) and the revenue per
+// available room (RevPAR), per room and for the whole property, by day, week or month. Nights a
+// room is blocked by its owner aren't sellable and don't count as available
+package reports
+
+import (
+	"math"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// Granularity is the length of the periods of a report
+type Granularity string
+
+// Granularities of reports. Weeks start on Monday, the first and last period are cut to the dates
+// of the report
+const (
+	Day   Granularity = "day"
+	Week  Granularity = "week"
+	Month Granularity = "month"
+)
+
+// ParseGranularity returns the granularity named s
+func ParseGranularity(s string) (Granularity, bool) {
+
+	switch g := Granularity(s); g {
+	case Day, Week, Month:
+		return g, true
+	}
+	return "", false
+}
+
+// Figures are the totals of a set of room nights. Revenue, ADR and RevPAR are in minor units of
+// the currency of the property
+type Figures struct {
+	// Available is the number of sellable room nights
+	Available int
+	// Sold is the number of room nights sold. A room counts once a night, even if it is overbooked
+	Sold    int
+	Revenue int
+
+	revenue float64
+}
+
+// add counts a room night. A blocked room which has been sold anyway is available
+func (f *Figures) add(n models.RoomNight) {
+
+	if !n.Blocked || n.Sold > 0 {
+		f.Available++
+	}
+	if n.Sold > 0 {
+		f.Sold++
+	}
+	f.revenue += n.Revenue
+	f.Revenue = int(math.Round(f.revenue))
+}
+
+// Occupancy is the share of the available room nights which have been sold, between 0 and 1
+func (f Figures) Occupancy() float64 {
+
+	if f.Available == 0 {
+		return 0
+	}
+	return float64(f.Sold) / float64(f.Available)
+}
+
+// ADR is the average daily rate, the revenue per room night sold
+func (f Figures) ADR() int {
+
+	if f.Sold == 0 {
+		return 0
+	}
+	return int(math.Round(f.revenue / float64(f.Sold)))
+}
+
+// RevPAR is the revenue per available room night
+func (f Figures) RevPAR() int {
+
+	if f.Available == 0 {
+		return 0
+	}
+	return int(math.Round(f.revenue / float64(f.Available)))
+}
+
+// RoomFigures are the figures of a room
+type RoomFigures struct {
+	RoomID   int
+	RoomName string
+	Figures
+}
+
+// Period is a day, week or month of a report with the figures of the property and of every room.
+// End is the last day of the period
+type Period struct {
+	Start time.Time
+	End   time.Time
+	Figures
+	Rooms []RoomFigures
+}
+
+// Report holds the figures of a property from From to To, both included
+type Report struct {
+	From        time.Time
+	To          time.Time
+	Granularity Granularity
+	Periods     []Period
+	// Total and Rooms are the figures of the whole report
+	Total Figures
+	Rooms []RoomFigures
+}
+
+// Periods splits the days from from to to into periods of the granularity
+func Periods(from, to time.Time, g Granularity) []Period {
+
+	var periods []Period
+	for start := from; !start.After(to); {
+		var next time.Time
+		switch g {
+		case Week:
+			// days since Monday
+			offset := (int(start.Weekday()) + 6) % 7
+			next = start.AddDate(0, 0, 7-offset)
+		case Month:
+			next = time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location())
+		default:
+			next = start.AddDate(0, 0, 1)
+		}
+
+		end := next.AddDate(0, 0, -1)
+		if end.After(to) {
+			end = to
+		}
+		periods = append(periods, Period{Start: start, End: end})
+		start = next
+	}
+	return periods
+}
+
+// Build computes the report of the room nights from from to to. Nights outside the dates are
+// ignored
+func Build(nights []models.RoomNight, from, to time.Time, g Granularity) Report {
+
+	report := Report{From: from, To: to, Granularity: g, Periods: Periods(from, to, g)}
+
+	// the rooms in the order of their first night
+	roomIndex := map[int]int{}
+	for _, n := range nights {
+		if _, ok := roomIndex[n.RoomID]; !ok {
+			roomIndex[n.RoomID] = len(report.Rooms)
+			report.Rooms = append(report.Rooms, RoomFigures{RoomID: n.RoomID, RoomName: n.RoomName})
+		}
+	}
+	for i := range report.Periods {
+		report.Periods[i].Rooms = make([]RoomFigures, len(report.Rooms))
+		copy(report.Periods[i].Rooms, report.Rooms)
+	}
+
+	for _, n := range nights {
+		p := periodOf(report.Periods, n.Day)
+		if p < 0 {
+			continue
+		}
+		r := roomIndex[n.RoomID]
+
+		report.Periods[p].Figures.add(n)
+		report.Periods[p].Rooms[r].Figures.add(n)
+		report.Rooms[r].Figures.add(n)
+		report.Total.add(n)
+	}
+
+	return report
+}
+
+// periodOf returns the index of the period of day, -1 if it is outside of all of them
+func periodOf(periods []Period, day time.Time) int {
+
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	// periods are sorted, a binary search would do but reports are small
+	for i, p := range periods {
+		if !day.Before(p.Start) && !day.After(p.End) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Comparison is a period of a report next to the same period a year before
+type Comparison struct {
+	Period
+	LastYear Period
+	// HasLastYear is false if the report of last year has fewer periods, e.g. a week cut short
+	HasLastYear bool
+}
+
+// OccupancyChange is the change of the occupancy since last year in percentage points
+func (c Comparison) OccupancyChange() float64 {
+	return (c.Occupancy() - c.LastYear.Occupancy()) * 100
+}
+
+// RevPARChange is the change of the RevPAR since last year in percent, 0 if there was none
+func (c Comparison) RevPARChange() float64 {
+
+	if c.LastYear.RevPAR() == 0 {
+		return 0
+	}
+	return float64(c.RevPAR()-c.LastYear.RevPAR()) / float64(c.LastYear.RevPAR()) * 100
+}
+
+// LastYear returns the dates of the same period a year before
+func LastYear(from, to time.Time) (time.Time, time.Time) {
+	return from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0)
+}
+
+// Compare puts the periods of report next to the ones of lastYear, by position
+func Compare(report, lastYear Report) []Comparison {
+
+	var comparisons []Comparison
+	for i, p := range report.Periods {
+		c := Comparison{Period: p}
+		if i < len(lastYear.Periods) {
+			c.LastYear = lastYear.Periods[i]
+			c.HasLastYear = true
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// Totals puts the figures of the whole report next to the ones of lastYear
+func Totals(report, lastYear Report) Comparison {
+
+	return Comparison{
+		Period: Period{Start: report.From, End: report.To, Figures: report.Total, Rooms: report.Rooms},
+		LastYear: Period{
+			Start:   lastYear.From,
+			End:     lastYear.To,
+			Figures: lastYear.Total,
+			Rooms:   lastYear.Rooms,
+		},
+		HasLastYear: true,
+	}
+}
@@ -0,0 +1,182 @@
+package reports
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var periodsTests = []struct {
+	name     string
+	from     string
+	to       string
+	g        Granularity
+	expected [][2]string
+}{
+	{"days", "2021-10-30", "2021-11-01", Day, [][2]string{{"2021-10-30", "2021-10-30"}, {"2021-10-31", "2021-10-31"}, {"2021-11-01", "2021-11-01"}}},
+	// the 1st of October 2021 is a Friday
+	{"weeks", "2021-10-01", "2021-10-12", Week, [][2]string{{"2021-10-01", "2021-10-03"}, {"2021-10-04", "2021-10-10"}, {"2021-10-11", "2021-10-12"}}},
+	{"week-from-monday", "2021-10-04", "2021-10-10", Week, [][2]string{{"2021-10-04", "2021-10-10"}}},
+	{"months", "2021-10-15", "2022-01-10", Month, [][2]string{{"2021-10-15", "2021-10-31"}, {"2021-11-01", "2021-11-30"}, {"2021-12-01", "2021-12-31"}, {"2022-01-01", "2022-01-10"}}},
+	{"leap-february", "2024-02-01", "2024-02-29", Month, [][2]string{{"2024-02-01", "2024-02-29"}}},
+	{"one-day", "2021-10-01", "2021-10-01", Month, [][2]string{{"2021-10-01", "2021-10-01"}}},
+}
+
+func TestPeriods(t *testing.T) {
+
+	for _, e := range periodsTests {
+		periods := Periods(date(e.from), date(e.to), e.g)
+		if len(periods) != len(e.expected) {
+			t.Errorf("failed %s: expected %d periods but got %d", e.name, len(e.expected), len(periods))
+			continue
+		}
+		for i, p := range periods {
+			start, end := p.Start.Format("2006-01-02"), p.End.Format("2006-01-02")
+			if start != e.expected[i][0] || end != e.expected[i][1] {
+				t.Errorf("failed %s: expected %v but got %s to %s", e.name, e.expected[i], start, end)
+			}
+		}
+	}
+}
+
+func TestParseGranularity(t *testing.T) {
+
+	for _, s := range []string{"day", "week", "month"} {
+		if g, ok := ParseGranularity(s); !ok || string(g) != s {
+			t.Errorf("expected %s to be valid", s)
+		}
+	}
+	if _, ok := ParseGranularity("year"); ok {
+		t.Error("expected year to be invalid")
+	}
+}
+
+var figuresTests = []struct {
+	name              string
+	figures           Figures
+	expectedOccupancy float64
+	expectedADR       int
+	expectedRevPAR    int
+}{
+	{"empty", Figures{}, 0, 0, 0},
+	{"nothing-sold", Figures{Available: 10}, 0, 0, 0},
+	{"half-sold", Figures{Available: 4, Sold: 2, revenue: 25000}, 0.5, 12500, 6250},
+	{"rounded", Figures{Available: 3, Sold: 3, revenue: 10000}, 1, 3333, 3333},
+}
+
+func TestFigures(t *testing.T) {
+
+	for _, e := range figuresTests {
+		if got := e.figures.Occupancy(); got != e.expectedOccupancy {
+			t.Errorf("failed %s: expected occupancy %v but got %v", e.name, e.expectedOccupancy, got)
+		}
+		if got := e.figures.ADR(); got != e.expectedADR {
+			t.Errorf("failed %s: expected ADR %d but got %d", e.name, e.expectedADR, got)
+		}
+		if got := e.figures.RevPAR(); got != e.expectedRevPAR {
+			t.Errorf("failed %s: expected RevPAR %d but got %d", e.name, e.expectedRevPAR, got)
+		}
+	}
+}
+
+// nights returns the nights of two rooms from 2021-10-01 to 2021-10-04. Room 1 is sold on the
+// first two nights for 150.00 in total, room 2 is blocked on the 1st and sold on the 4th anyway
+// with a second reservation on top
+func nights() []models.RoomNight {
+
+	return []models.RoomNight{
+		{RoomID: 1, RoomName: "One", Day: date("2021-10-01"), Sold: 1, Revenue: 7500},
+		{RoomID: 1, RoomName: "One", Day: date("2021-10-02"), Sold: 1, Revenue: 7500},
+		{RoomID: 1, RoomName: "One", Day: date("2021-10-03")},
+		{RoomID: 1, RoomName: "One", Day: date("2021-10-04")},
+		{RoomID: 2, RoomName: "Two", Day: date("2021-10-01"), Blocked: true},
+		{RoomID: 2, RoomName: "Two", Day: date("2021-10-02")},
+		{RoomID: 2, RoomName: "Two", Day: date("2021-10-03")},
+		{RoomID: 2, RoomName: "Two", Day: date("2021-10-04"), Blocked: true, Sold: 2, Revenue: 20000},
+		// outside the report
+		{RoomID: 2, RoomName: "Two", Day: date("2021-10-05"), Sold: 1, Revenue: 99999},
+	}
+}
+
+func TestBuild(t *testing.T) {
+
+	report := Build(nights(), date("2021-10-01"), date("2021-10-04"), Week)
+
+	// the blocked night of room 2 isn't available, the overbooked one counts once
+	if report.Total.Available != 7 || report.Total.Sold != 3 || report.Total.Revenue != 35000 {
+		t.Errorf("unexpected totals %+v", report.Total)
+	}
+
+	if len(report.Rooms) != 2 || report.Rooms[0].RoomName != "One" || report.Rooms[1].RoomID != 2 {
+		t.Fatalf("expected the two rooms in order but got %+v", report.Rooms)
+	}
+	if one := report.Rooms[0]; one.Available != 4 || one.Sold != 2 || one.ADR() != 7500 || one.RevPAR() != 3750 {
+		t.Errorf("unexpected figures of room 1 %+v", one.Figures)
+	}
+	if two := report.Rooms[1]; two.Available != 3 || two.Sold != 1 || two.Revenue != 20000 {
+		t.Errorf("unexpected figures of room 2 %+v", two.Figures)
+	}
+
+	// Friday to Sunday, then Monday
+	if len(report.Periods) != 2 {
+		t.Fatalf("expected 2 periods but got %d", len(report.Periods))
+	}
+	if p := report.Periods[0]; p.Available != 5 || p.Sold != 2 || len(p.Rooms) != 2 || p.Rooms[1].Available != 2 {
+		t.Errorf("unexpected first week %+v", p)
+	}
+	if p := report.Periods[1]; p.Available != 2 || p.Sold != 1 || p.Rooms[0].Sold != 0 {
+		t.Errorf("unexpected second week %+v", p)
+	}
+}
+
+func TestCompare(t *testing.T) {
+
+	report := Build(nights(), date("2021-10-01"), date("2021-10-04"), Day)
+
+	from, to := LastYear(report.From, report.To)
+	if from.Format("2006-01-02") != "2020-10-01" || to.Format("2006-01-02") != "2020-10-04" {
+		t.Fatalf("unexpected last year %s to %s", from, to)
+	}
+
+	lastYearNights := []models.RoomNight{
+		{RoomID: 1, RoomName: "One", Day: date("2020-10-01"), Sold: 1, Revenue: 5000},
+		{RoomID: 1, RoomName: "One", Day: date("2020-10-02")},
+	}
+	lastYear := Build(lastYearNights, from, from.AddDate(0, 0, 1), Day)
+
+	comparisons := Compare(report, lastYear)
+	if len(comparisons) != 4 {
+		t.Fatalf("expected 4 comparisons but got %d", len(comparisons))
+	}
+
+	// 1 October: one of one room sold for 75.00, last year one of one for 50.00
+	first := comparisons[0]
+	if !first.HasLastYear || first.OccupancyChange() != 0 || first.RevPARChange() != 50 {
+		t.Errorf("unexpected change %v points, %v%%", first.OccupancyChange(), first.RevPARChange())
+	}
+
+	// 2 October: half of the rooms sold, none last year
+	second := comparisons[1]
+	if second.OccupancyChange() != 50 || second.RevPARChange() != 0 {
+		t.Errorf("unexpected change %v points, %v%%", second.OccupancyChange(), second.RevPARChange())
+	}
+
+	if comparisons[3].HasLastYear {
+		t.Error("expected no last year for the 4th of October")
+	}
+
+	total := Totals(report, lastYear)
+	if total.Sold != 3 || total.LastYear.Sold != 1 || !total.HasLastYear {
+		t.Errorf("unexpected totals %+v", total)
+	}
+	if change := total.OccupancyChange(); math.Abs(change-(3.0/7-0.5)*100) > 1e-9 {
+		t.Errorf("unexpected occupancy change %v", change)
+	}
+}
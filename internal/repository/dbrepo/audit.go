@@ -0,0 +1,325 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// Entity types of audit events, stored in audit_events.entity_type
+const (
+	auditReservation     = "reservation"
+	auditRoomRestriction = "room_restriction"
+	auditRoom            = "room"
+	auditUser            = "user"
+	auditTwoFactorPolicy = "two_factor_policy"
+)
+
+// auditRecord is an audit event about to be written. before and after are snapshots of the
+// whole entity, nil when it is created or deleted. Only the changed fields are stored
+type auditRecord struct {
+	actor      models.Actor
+	action     string
+	entityType string
+	entityID   int
+	propertyID int
+	before     map[string]interface{}
+	after      map[string]interface{}
+}
+
+// insertAuditEvent writes an audit event within the transaction of the change it records, so
+// that there is no change without an event and no event without a change
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, e auditRecord) error {
+
+	before, after, err := auditDiff(e.before, e.after)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into audit_events (actor_type, actor_user_id, actor_label, action, entity_type,
+			entity_id, property_id, before, after, ip, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		e.actor.Type,
+		nullInt(e.actor.UserID),
+		e.actor.Label,
+		e.action,
+		e.entityType,
+		e.entityID,
+		nullInt(e.propertyID),
+		before,
+		after,
+		e.actor.IP,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("can't write audit event: %w", err)
+	}
+
+	return nil
+}
+
+// auditDiff returns the fields of before and after which differ as JSON objects. A nil snapshot
+// gives a nil result, i.e. a SQL null
+func auditDiff(before, after map[string]interface{}) ([]byte, []byte, error) {
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+
+	for k, v := range before {
+		if w, ok := after[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedBefore[k] = v
+		}
+	}
+	for k, v := range after {
+		if w, ok := before[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedAfter[k] = v
+		}
+	}
+
+	var b, a []byte
+	var err error
+	if before != nil {
+		b, err = json.Marshal(changedBefore)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if after != nil {
+		a, err = json.Marshal(changedAfter)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return b, a, nil
+}
+
+// nullInt stores zero as null
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+// auditDate formats dates in snapshots
+const auditDate = "2006-01-02"
+
+// reservationSnapshot returns the audited fields of a reservation
+func reservationSnapshot(res models.Reservation) map[string]interface{} {
+
+	return map[string]interface{}{
+		"first_name": res.FirstName,
+		"last_name":  res.LastName,
+		"email":      res.Email,
+		"phone":      res.Phone,
+		"room_id":    res.RoomID,
+		"start_date": res.StartDate.Format(auditDate),
+		"end_date":   res.EndDate.Format(auditDate),
+		"guest_id":   res.GuestID,
+		"amount":     res.Amount,
+	}
+}
+
+// roomRestrictionSnapshot returns the audited fields of a room restriction
+func roomRestrictionSnapshot(r models.RoomRestriction) map[string]interface{} {
+
+	return map[string]interface{}{
+		"room_id":        r.RoomID,
+		"reservation_id": r.ReservationID,
+		"restriction_id": r.RestrictionID,
+		"start_date":     r.StartDate.Format(auditDate),
+		"end_date":       r.EndDate.Format(auditDate),
+	}
+}
+
+// roomSnapshot returns the audited fields of a room
+func roomSnapshot(room models.Room) map[string]interface{} {
+
+	return map[string]interface{}{
+		"room_name":    room.RoomName,
+		"property_id":  room.PropertyID,
+		"nightly_rate": room.NightlyRate,
+	}
+}
+
+// userSnapshot returns the audited fields of a user, never the password
+func userSnapshot(u models.User) map[string]interface{} {
+
+	return map[string]interface{}{
+		"first_name":   u.FirstName,
+		"last_name":    u.LastName,
+		"email":        u.Email,
+		"access_level": u.AccessLevel,
+	}
+}
+
+// TxAudit writes the audit events of changes made outside of the repository, e.g. the demo data
+// of the seed command, within the transaction of the change. action is models.AuditCreate,
+// models.AuditUpdate or models.AuditDelete; the entity is the state after a create or an update,
+// before a delete
+type TxAudit struct {
+	tx         *sql.Tx
+	actor      models.Actor
+	propertyID int
+}
+
+// NewTxAudit returns a TxAudit for changes to the rooms of a property
+func NewTxAudit(tx *sql.Tx, actor models.Actor, propertyID int) TxAudit {
+	return TxAudit{tx: tx, actor: actor, propertyID: propertyID}
+}
+
+// record writes the event of a change of the entity with the given snapshot
+func (a TxAudit) record(ctx context.Context, action, entityType string, entityID, propertyID int, snapshot map[string]interface{}) error {
+
+	e := auditRecord{
+		actor:      a.actor,
+		action:     action,
+		entityType: entityType,
+		entityID:   entityID,
+		propertyID: propertyID,
+	}
+	if action == models.AuditDelete {
+		e.before = snapshot
+	} else {
+		e.after = snapshot
+	}
+
+	return insertAuditEvent(ctx, a.tx, e)
+}
+
+// Room records a change of a room
+func (a TxAudit) Room(ctx context.Context, action string, room models.Room) error {
+	return a.record(ctx, action, auditRoom, room.ID, a.propertyID, roomSnapshot(room))
+}
+
+// User records a change of a user. Users belong to no property
+func (a TxAudit) User(ctx context.Context, action string, u models.User) error {
+	return a.record(ctx, action, auditUser, u.ID, 0, userSnapshot(u))
+}
+
+// Reservation records a change of a reservation
+func (a TxAudit) Reservation(ctx context.Context, action string, res models.Reservation) error {
+	return a.record(ctx, action, auditReservation, res.ID, a.propertyID, reservationSnapshot(res))
+}
+
+// RoomRestriction records a change of a room restriction
+func (a TxAudit) RoomRestriction(ctx context.Context, action string, rr models.RoomRestriction) error {
+	return a.record(ctx, action, auditRoomRestriction, rr.ID, a.propertyID, roomRestrictionSnapshot(rr))
+}
+
+// roomPropertyID returns the property of a room, audit events are filtered by property
+func roomPropertyID(ctx context.Context, tx *sql.Tx, roomID int) (int, error) {
+
+	var propertyID int
+	err := tx.QueryRowContext(ctx, `select property_id from rooms where id = $1`, roomID).Scan(&propertyID)
+	if err != nil {
+		return 0, fmt.Errorf("can't get property of room %d: %w", roomID, err)
+	}
+
+	return propertyID, nil
+}
+
+// auditEventsQuery returns the query of AuditEvents and its arguments. Events of users and of
+// the two factor policy have no property, they are part of the log of a property if the user
+// has access to it, which admins have to every property, respectively always
+func auditEventsQuery(filter models.AuditFilter) (string, []interface{}) {
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.PropertyID != 0 {
+		add(`(property_id = $%[1]d or property_id is null and (
+				entity_type = '`+auditTwoFactorPolicy+`' or
+				entity_type = '`+auditUser+`' and (
+					entity_id in (select user_id from property_users where property_id = $%[1]d) or
+					entity_id in (select id from users where access_level = `+strconv.Itoa(models.AccessLevelAdmin)+`))))`,
+			filter.PropertyID)
+	}
+	if filter.EntityType != "" {
+		add("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		add("entity_id = $%d", filter.EntityID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.ActorUserID != 0 {
+		add("actor_user_id = $%d", filter.ActorUserID)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	query := `select id, actor_type, coalesce(actor_user_id, 0), actor_label, action, entity_type, entity_id,
+			coalesce(property_id, 0), before, after, ip, created_at
+		from audit_events`
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, ` and `)
+	}
+	query += fmt.Sprintf(` order by created_at desc, id desc limit %d`, limit)
+
+	return query, args
+}
+
+// AuditEvents returns the audit events matching filter, newest first. It reads from a replica
+func (m *postgresDBRepo) AuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query, args := auditEventsQuery(filter)
+
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.Actor.Type,
+			&e.Actor.UserID,
+			&e.Actor.Label,
+			&e.Action,
+			&e.EntityType,
+			&e.EntityID,
+			&e.PropertyID,
+			&e.Before,
+			&e.After,
+			&e.Actor.IP,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+	return events, nil
+}
@@ -0,0 +1,97 @@
+package dbrepo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+var auditDiffTests = []struct {
+	name           string
+	before         map[string]interface{}
+	after          map[string]interface{}
+	expectedBefore string
+	expectedAfter  string
+}{
+	{"create", nil, map[string]interface{}{"room_id": 1}, "", `{"room_id":1}`},
+	{"delete", map[string]interface{}{"room_id": 1}, nil, `{"room_id":1}`, ""},
+	{
+		"update-keeps-changed-fields",
+		map[string]interface{}{"room_id": 1, "last_name": "Smith"},
+		map[string]interface{}{"room_id": 1, "last_name": "Jones"},
+		`{"last_name":"Smith"}`,
+		`{"last_name":"Jones"}`,
+	},
+	{"no-change", map[string]interface{}{"room_id": 1}, map[string]interface{}{"room_id": 1}, `{}`, `{}`},
+}
+
+func TestAuditDiff(t *testing.T) {
+
+	for _, e := range auditDiffTests {
+		before, after, err := auditDiff(e.before, e.after)
+		if err != nil {
+			t.Errorf("failed %s: %s", e.name, err)
+			continue
+		}
+
+		if string(before) != e.expectedBefore {
+			t.Errorf("failed %s: expected before %s but got %s", e.name, e.expectedBefore, before)
+		}
+		if string(after) != e.expectedAfter {
+			t.Errorf("failed %s: expected after %s but got %s", e.name, e.expectedAfter, after)
+		}
+	}
+}
+
+var auditEventsQueryTests = []struct {
+	name             string
+	filter           models.AuditFilter
+	expectedArgs     int
+	expectedContains []string
+}{
+	{"no-filter", models.AuditFilter{}, 0, []string{"limit 100"}},
+	{
+		"property-includes-its-users-and-admins",
+		models.AuditFilter{PropertyID: 1},
+		1,
+		[]string{
+			"property_id = $1 or property_id is null",
+			"from property_users where property_id = $1",
+			"from users where access_level = 3",
+		},
+	},
+	{
+		"numbered-after-property",
+		models.AuditFilter{PropertyID: 1, EntityType: "user", EntityID: 2},
+		3,
+		[]string{"entity_type = $2", "entity_id = $3"},
+	},
+}
+
+func TestAuditEventsQuery(t *testing.T) {
+
+	for _, e := range auditEventsQueryTests {
+		query, args := auditEventsQuery(e.filter)
+
+		if len(args) != e.expectedArgs {
+			t.Errorf("failed %s: expected %d arguments but got %d", e.name, e.expectedArgs, len(args))
+		}
+		for _, c := range e.expectedContains {
+			if !strings.Contains(query, c) {
+				t.Errorf("failed %s: expected the query to contain %q, got %s", e.name, c, query)
+			}
+		}
+	}
+}
+
+func TestUserSnapshot(t *testing.T) {
+
+	snapshot := userSnapshot(models.User{Email: "me@here.com", Password: "secret"})
+	if _, ok := snapshot["password"]; ok {
+		t.Error("expected the snapshot of a user not to contain the password")
+	}
+	if snapshot["email"] != "me@here.com" {
+		t.Errorf("expected the email in the snapshot, got %v", snapshot)
+	}
+}
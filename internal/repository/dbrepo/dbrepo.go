@@ -1,15 +1,22 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/prayagsingh/bookings/internal/config"
+	"github.com/prayagsingh/bookings/internal/driver"
 	"github.com/prayagsingh/bookings/internal/repository"
 )
 
 type postgresDBRepo struct {
 	App *config.AppConfig
-	DB  *sql.DB
+	// DB is the primary. Writes, and reads which have to see the latest writes, use it
+	DB *sql.DB
+	// Conn routes read-only queries to the replicas, see reader
+	Conn *driver.DB
+	// ctx carries the client whose reads and writes are routed, nil for none, see WithContext
+	ctx context.Context
 }
 
 // for testcases
@@ -19,14 +26,36 @@ type testPostgresDBRepo struct {
 }
 
 // NewPostgresRepo creates a new instance of PostgresDbRepo
-func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+func NewPostgresRepo(conn *driver.DB, a *config.AppConfig) repository.DatabaseRepo {
 
 	return &postgresDBRepo{
-		App: a,
-		DB:  conn,
+		App:  a,
+		DB:   conn.SQL,
+		Conn: conn,
 	}
 }
 
+// WithContext returns a copy of the repository for the client of ctx
+func (m *postgresDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+// reader returns the pool for queries which tolerate replication lag, like availability searches
+// and reports. It is a healthy replica if there is one and the client didn't write recently,
+// otherwise the primary
+func (m *postgresDBRepo) reader() *sql.DB {
+	return m.Conn.Reader(m.ctx)
+}
+
+// wrote sends the reads of the client to the primary for a while after a write, see
+// driver.DB.SetReadAfterWrite
+func (m *postgresDBRepo) wrote() {
+	m.Conn.Wrote(m.ctx)
+}
+
 // NewTestPostgresRepo creates a new instance of PostgressDbRepo for testcases
 func NewTestPostgresRepo(a *config.AppConfig) repository.DatabaseRepo {
 
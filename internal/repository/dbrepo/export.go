@@ -0,0 +1,143 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// exportTimeout limits an export. It is longer than the timeout of other queries, the rows are
+// streamed to the client while the query runs
+const exportTimeout = 5 * time.Minute
+
+// reservationExportQuery builds the query of ExportReservations: the search of the admin list in
+// the same order, without a cursor or a limit
+func reservationExportQuery(filter models.ReservationFilter) (string, []interface{}, error) {
+
+	_, sort, err := reservationSort(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args, err := reservationWhere(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir := "asc"
+	if filter.Desc {
+		dir = "desc"
+	}
+
+	query := `select ` + reservationColumns + `
+		from
+			reservations r
+			join rooms rm on (r.room_id = rm.id)
+		where ` + strings.Join(where, ` and `) +
+		fmt.Sprintf(` order by %s %s, r.id %s`, sort.expr, dir, dir)
+
+	return query, args, nil
+}
+
+// ExportReservations calls fn for every reservation matching filter. The rows are read one at a
+// time from a replica, so that an export doesn't hold all of them in memory
+func (m *postgresDBRepo) ExportReservations(filter models.ReservationFilter, fn func(models.Reservation) error) error {
+
+	query, args, err := reservationExportQuery(filter)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		res, err := scanReservation(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ExportRoomRestrictions calls fn for every room restriction matching filter, with its room and
+// restriction, ordered by start date. Like ExportReservations it streams from a replica
+func (m *postgresDBRepo) ExportRoomRestrictions(filter models.RoomRestrictionFilter, fn func(models.RoomRestriction) error) error {
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	add("rm.property_id = $%d", filter.PropertyID)
+	if filter.RoomID != 0 {
+		add("rr.room_id = $%d", filter.RoomID)
+	}
+	// uses room_restrictions_start_date_end_date_idx
+	if !filter.From.IsZero() {
+		add("rr.end_date > $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("rr.start_date <= $%d", filter.To)
+	}
+
+	query := `select rr.id, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.start_date,
+			rr.end_date, rr.created_at, rr.updated_at, rm.id, rm.room_name, rm.property_id, rs.id, rs.restriction_name
+		from
+			room_restrictions rr
+			join rooms rm on (rr.room_id = rm.id)
+			join restrictions rs on (rr.restriction_id = rs.id)
+		where ` + strings.Join(where, ` and `) + `
+		order by rr.start_date, rr.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.RoomID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.CreatedAt,
+			&rr.UpdatedAt,
+			&rr.Room.ID,
+			&rr.Room.RoomName,
+			&rr.Room.PropertyID,
+			&rr.Restriction.ID,
+			&rr.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(rr); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,179 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// importTimeout limits the transaction of an import
+const importTimeout = time.Minute
+
+// ImportBookings inserts the reservations and owner blocks of an import in one transaction, along
+// with their room restrictions and audit events. Rows whose room isn't available, because of a
+// booking made before the import, are skipped and returned as errors. The rows are checked against
+// each other by the importer. Without commit the transaction is rolled back, so that a dry run
+// reports exactly what the import would do
+func (m *postgresDBRepo) ImportBookings(actor models.Actor, propertyID int, rows []models.ImportRow, commit bool) ([]models.ImportError, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var conflicts []models.ImportError
+	for _, row := range rows {
+		res := row.Reservation
+
+		conflict, err := importConflict(ctx, tx, propertyID, res)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+		if conflict != "" {
+			conflicts = append(conflicts, models.ImportError{Line: row.Line, Message: conflict})
+			continue
+		}
+
+		if row.Block {
+			err = importBlock(ctx, tx, actor, propertyID, res)
+		} else {
+			err = importReservation(ctx, tx, actor, propertyID, res)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+	}
+
+	if !commit {
+		return conflicts, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.wrote()
+	return conflicts, nil
+}
+
+// importConflict describes why the room of res can't be booked for its dates, or returns an empty
+// string if it can. Rooms of other properties are rejected as well
+func importConflict(ctx context.Context, tx *sql.Tx, propertyID int, res models.Reservation) (string, error) {
+
+	var roomPropertyID int
+	err := tx.QueryRowContext(ctx, `select property_id from rooms where id = $1`, res.RoomID).Scan(&roomPropertyID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if roomPropertyID != propertyID {
+		return fmt.Sprintf("room %d doesn't exist", res.RoomID), nil
+	}
+
+	query := `select coalesce(reservation_id, 0), rs.restriction_name, rr.start_date, rr.end_date
+		from
+			room_restrictions rr
+			join restrictions rs on (rr.restriction_id = rs.id)
+		where
+			rr.room_id = $1 and $2 < rr.end_date and $3 > rr.start_date
+		order by rr.start_date
+		limit 1`
+
+	var reservationID int
+	var restriction string
+	var start, end time.Time
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&reservationID, &restriction, &start, &end)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if reservationID != 0 {
+		return fmt.Sprintf("the room is booked from %s to %s by reservation %d",
+			start.Format("2006-01-02"), end.Format("2006-01-02"), reservationID), nil
+	}
+	return fmt.Sprintf("the room has a %s from %s to %s", restriction,
+		start.Format("2006-01-02"), end.Format("2006-01-02")), nil
+}
+
+// importReservation inserts a reservation and its room restriction
+func importReservation(ctx context.Context, tx *sql.Tx, actor models.Actor, propertyID int, res models.Reservation) error {
+
+	now := time.Now()
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id,
+			amount, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	var reservationID int
+	err := tx.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate,
+		res.EndDate, res.RoomID, nullInt(res.Amount), now, now).Scan(&reservationID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditCreate,
+		entityType: auditReservation,
+		entityID:   reservationID,
+		propertyID: propertyID,
+		after:      reservationSnapshot(res),
+	})
+	if err != nil {
+		return err
+	}
+
+	return importRestriction(ctx, tx, actor, propertyID, models.RoomRestriction{
+		RoomID:        res.RoomID,
+		ReservationID: reservationID,
+		RestrictionID: models.RestrictionReservation,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+	})
+}
+
+// importBlock inserts an owner block of the room and dates of res
+func importBlock(ctx context.Context, tx *sql.Tx, actor models.Actor, propertyID int, res models.Reservation) error {
+
+	return importRestriction(ctx, tx, actor, propertyID, models.RoomRestriction{
+		RoomID:        res.RoomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+	})
+}
+
+// importRestriction inserts a room restriction. Blocks have no reservation, it is stored as null
+func importRestriction(ctx context.Context, tx *sql.Tx, actor models.Actor, propertyID int, rr models.RoomRestriction) error {
+
+	now := time.Now()
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+			created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var id int
+	err := tx.QueryRowContext(ctx, stmt, rr.StartDate, rr.EndDate, rr.RoomID, nullInt(rr.ReservationID),
+		rr.RestrictionID, now, now).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditCreate,
+		entityType: auditRoomRestriction,
+		entityID:   id,
+		propertyID: propertyID,
+		after:      roomRestrictionSnapshot(rr),
+	})
+}
@@ -0,0 +1,243 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/metrics"
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+)
+
+// instrumentedDBRepo wraps a DatabaseRepo and records the latency and errors of every call
+// per method. Every method added to DatabaseRepo needs a wrapper here
+type instrumentedDBRepo struct {
+	repo repository.DatabaseRepo
+}
+
+// NewInstrumentedRepo wraps repo so that its calls are reported on /metrics
+func NewInstrumentedRepo(repo repository.DatabaseRepo) repository.DatabaseRepo {
+
+	return &instrumentedDBRepo{repo: repo}
+}
+
+// WithContext wraps the repository of the client of ctx
+func (m *instrumentedDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return &instrumentedDBRepo{repo: m.repo.WithContext(ctx)}
+}
+
+func (m *instrumentedDBRepo) AllUsers() bool {
+
+	defer metrics.ObserveDB("AllUsers", time.Now(), nil)
+	return m.repo.AllUsers()
+}
+
+func (m *instrumentedDBRepo) InsertReservation(actor models.Actor, res models.Reservation) (id int, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("InsertReservation", start, err) }(time.Now())
+	return m.repo.InsertReservation(actor, res)
+}
+
+func (m *instrumentedDBRepo) InserRoomRestriction(actor models.Actor, res models.RoomRestriction) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("InserRoomRestriction", start, err) }(time.Now())
+	return m.repo.InserRoomRestriction(actor, res)
+}
+
+func (m *instrumentedDBRepo) SearchAvailabilityByDatesByRoomID(start_date, end_date time.Time, roomID int) (ok bool, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("SearchAvailabilityByDatesByRoomID", start, err) }(time.Now())
+	return m.repo.SearchAvailabilityByDatesByRoomID(start_date, end_date, roomID)
+}
+
+func (m *instrumentedDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time.Time, propertyID int) (rooms []models.Room, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("SearchAvailabilityForAllRooms", start, err) }(time.Now())
+	return m.repo.SearchAvailabilityForAllRooms(start_date, end_date, propertyID)
+}
+
+func (m *instrumentedDBRepo) GetRoomByID(roomID int) (room models.Room, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetRoomByID", start, err) }(time.Now())
+	return m.repo.GetRoomByID(roomID)
+}
+
+func (m *instrumentedDBRepo) AllProperties() (properties []models.Property, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("AllProperties", start, err) }(time.Now())
+	return m.repo.AllProperties()
+}
+
+func (m *instrumentedDBRepo) GetPropertyByID(id int) (property models.Property, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetPropertyByID", start, err) }(time.Now())
+	return m.repo.GetPropertyByID(id)
+}
+
+func (m *instrumentedDBRepo) GetPropertyBySlug(slug string) (property models.Property, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetPropertyBySlug", start, err) }(time.Now())
+	return m.repo.GetPropertyBySlug(slug)
+}
+
+func (m *instrumentedDBRepo) GetPropertyByHostname(hostname string) (property models.Property, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetPropertyByHostname", start, err) }(time.Now())
+	return m.repo.GetPropertyByHostname(hostname)
+}
+
+func (m *instrumentedDBRepo) GetUserByID(id int) (user models.User, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetUserByID", start, err) }(time.Now())
+	return m.repo.GetUserByID(id)
+}
+
+func (m *instrumentedDBRepo) Authenticate(email, testPassword string) (id int, hash string, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("Authenticate", start, err) }(time.Now())
+	return m.repo.Authenticate(email, testPassword)
+}
+
+func (m *instrumentedDBRepo) PropertiesForUser(userID int) (properties []models.Property, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("PropertiesForUser", start, err) }(time.Now())
+	return m.repo.PropertiesForUser(userID)
+}
+
+func (m *instrumentedDBRepo) UserHasProperty(userID, propertyID int) (ok bool, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("UserHasProperty", start, err) }(time.Now())
+	return m.repo.UserHasProperty(userID, propertyID)
+}
+
+func (m *instrumentedDBRepo) AllReservations(propertyID int) (reservations []models.Reservation, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("AllReservations", start, err) }(time.Now())
+	return m.repo.AllReservations(propertyID)
+}
+
+func (m *instrumentedDBRepo) SearchReservations(filter models.ReservationFilter) (page models.ReservationPage, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("SearchReservations", start, err) }(time.Now())
+	return m.repo.SearchReservations(filter)
+}
+
+func (m *instrumentedDBRepo) ExportReservations(filter models.ReservationFilter, fn func(models.Reservation) error) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("ExportReservations", start, err) }(time.Now())
+	return m.repo.ExportReservations(filter, fn)
+}
+
+func (m *instrumentedDBRepo) ExportRoomRestrictions(filter models.RoomRestrictionFilter, fn func(models.RoomRestriction) error) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("ExportRoomRestrictions", start, err) }(time.Now())
+	return m.repo.ExportRoomRestrictions(filter, fn)
+}
+
+func (m *instrumentedDBRepo) AllRooms(propertyID int) (rooms []models.Room, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("AllRooms", start, err) }(time.Now())
+	return m.repo.AllRooms(propertyID)
+}
+
+func (m *instrumentedDBRepo) ImportBookings(actor models.Actor, propertyID int, rows []models.ImportRow, commit bool) (conflicts []models.ImportError, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("ImportBookings", start, err) }(time.Now())
+	return m.repo.ImportBookings(actor, propertyID, rows, commit)
+}
+
+func (m *instrumentedDBRepo) RoomNights(propertyID int, from, to time.Time) (nights []models.RoomNight, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("RoomNights", start, err) }(time.Now())
+	return m.repo.RoomNights(propertyID, from, to)
+}
+
+func (m *instrumentedDBRepo) AuditEvents(filter models.AuditFilter) (events []models.AuditEvent, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("AuditEvents", start, err) }(time.Now())
+	return m.repo.AuditEvents(filter)
+}
+
+func (m *instrumentedDBRepo) GetUserByEmail(email string) (u models.User, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetUserByEmail", start, err) }(time.Now())
+	return m.repo.GetUserByEmail(email)
+}
+
+func (m *instrumentedDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("InsertPasswordReset", start, err) }(time.Now())
+	return m.repo.InsertPasswordReset(userID, tokenHash, expiresAt)
+}
+
+func (m *instrumentedDBRepo) UserForPasswordReset(tokenHash string) (u models.User, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("UserForPasswordReset", start, err) }(time.Now())
+	return m.repo.UserForPasswordReset(tokenHash)
+}
+
+func (m *instrumentedDBRepo) ResetPassword(actor models.Actor, tokenHash, password string) (userID int, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("ResetPassword", start, err) }(time.Now())
+	return m.repo.ResetPassword(actor, tokenHash, password)
+}
+
+func (m *instrumentedDBRepo) InsertGuest(guest models.Guest, password string) (id int, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("InsertGuest", start, err) }(time.Now())
+	return m.repo.InsertGuest(guest, password)
+}
+
+func (m *instrumentedDBRepo) AuthenticateGuest(email, password string) (id int, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("AuthenticateGuest", start, err) }(time.Now())
+	return m.repo.AuthenticateGuest(email, password)
+}
+
+func (m *instrumentedDBRepo) GetGuestByID(id int) (g models.Guest, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GetGuestByID", start, err) }(time.Now())
+	return m.repo.GetGuestByID(id)
+}
+
+func (m *instrumentedDBRepo) GuestReservations(guestID int) (reservations []models.Reservation, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("GuestReservations", start, err) }(time.Now())
+	return m.repo.GuestReservations(guestID)
+}
+
+func (m *instrumentedDBRepo) EnableTwoFactor(actor models.Actor, userID int, secret string, step int64, recoveryCodeHashes []string) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("EnableTwoFactor", start, err) }(time.Now())
+	return m.repo.EnableTwoFactor(actor, userID, secret, step, recoveryCodeHashes)
+}
+
+func (m *instrumentedDBRepo) DisableTwoFactor(actor models.Actor, userID int) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("DisableTwoFactor", start, err) }(time.Now())
+	return m.repo.DisableTwoFactor(actor, userID)
+}
+
+func (m *instrumentedDBRepo) UseTOTPStep(userID int, step int64) (ok bool, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("UseTOTPStep", start, err) }(time.Now())
+	return m.repo.UseTOTPStep(userID, step)
+}
+
+func (m *instrumentedDBRepo) UseRecoveryCode(actor models.Actor, userID int, codeHash string) (ok bool, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("UseRecoveryCode", start, err) }(time.Now())
+	return m.repo.UseRecoveryCode(actor, userID, codeHash)
+}
+
+func (m *instrumentedDBRepo) TwoFactorPolicy() (levels []int, err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("TwoFactorPolicy", start, err) }(time.Now())
+	return m.repo.TwoFactorPolicy()
+}
+
+func (m *instrumentedDBRepo) SetTwoFactorPolicy(actor models.Actor, accessLevels []int) (err error) {
+
+	defer func(start time.Time) { metrics.ObserveDB("SetTwoFactorPolicy", start, err) }(time.Now())
+	return m.repo.SetTwoFactorPolicy(actor, accessLevels)
+}
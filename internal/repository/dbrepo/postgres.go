@@ -3,9 +3,14 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func (m *postgresDBRepo) AllUsers() bool {
@@ -13,21 +18,27 @@ func (m *postgresDBRepo) AllUsers() bool {
 	return true
 }
 
-// InsertReservation inserts a reservation into the db
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// InsertReservation inserts a reservation into the db and records it in the audit log
+func (m *postgresDBRepo) InsertReservation(actor models.Actor, res models.Reservation) (int, error) {
 
 	// creating context to make sure that the txn should not open for more than set time like adding a default timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// need reservation-id for room-restriction table
 	var newID int
 
 	stmt := `insert into reservations (first_name , last_name, email, phone, start_date,
-	        end_date, room_id, created_at, updated_at)
-			values($1, $2,$3, $4, $5, $6, $7, $8,$9) returning id`
+	        end_date, room_id, guest_id, amount, created_at, updated_at)
+			values($1, $2,$3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -35,6 +46,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		nullInt(res.GuestID),
+		nullInt(res.Amount),
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -42,21 +55,50 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	if err != nil {
 		return 0, err
 	}
+
+	propertyID, err := roomPropertyID(ctx, tx, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditCreate,
+		entityType: auditReservation,
+		entityID:   newID,
+		propertyID: propertyID,
+		after:      reservationSnapshot(res),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	m.wrote()
 	return newID, nil
 }
 
-// InserRoomRestriction inserts a room restriction into DB
-func (m *postgresDBRepo) InserRoomRestriction(res models.RoomRestriction) error {
+// InserRoomRestriction inserts a room restriction into DB and records it in the audit log
+func (m *postgresDBRepo) InserRoomRestriction(actor models.Actor, res models.RoomRestriction) error {
 
 	// creating context to make sure that the txn should not open for more than set time like adding a default timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
 		    created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7)`
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
@@ -64,14 +106,37 @@ func (m *postgresDBRepo) InserRoomRestriction(res models.RoomRestriction) error
 		res.RestrictionID,
 		time.Now(),
 		time.Now(),
-	)
+	).Scan(&newID)
+	if err != nil {
+		return err
+	}
+
+	propertyID, err := roomPropertyID(ctx, tx, res.RoomID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditCreate,
+		entityType: auditRoomRestriction,
+		entityID:   newID,
+		propertyID: propertyID,
+		after:      roomRestrictionSnapshot(res),
+	})
 	if err != nil {
 		return err
 	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.wrote()
 	return nil
 }
 
-// SearchAvailabilityByDatesByRoomID returns true if availability exist for roomID else false
+// SearchAvailabilityByDatesByRoomID returns true if availability exist for roomID else false. It is
+// checked right before booking, hence it reads from the primary
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start_date, end_date time.Time, roomID int) (bool, error) {
 
 	// creating context to make sure that the txn should not open for more than set time like adding a default timeout
@@ -102,8 +167,9 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start_date, end_date
 	return false, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of rooms for a given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns a slice of rooms of a property for a given date range. It
+// reads from a replica
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time.Time, propertyID int) ([]models.Room, error) {
 
 	// creating context to make sure that the txn should not open for more than set time like adding a default timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -111,23 +177,27 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time
 
 	var rooms []models.Room
 	query := `select
-	 	r.id, r.room_name
+	 	r.id, r.room_name, r.property_id
 	from
 		rooms r
 	where
+		r.property_id = $3
+	and
 		r.id not in (select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);
 	`
 	// Here we are querying multiple rows hence using QueryContext instead of QueryRowContext
-	rows, err := m.DB.QueryContext(ctx, query, start_date, end_date)
+	rows, err := m.reader().QueryContext(ctx, query, start_date, end_date, propertyID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var room models.Room
 		err = rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.PropertyID,
 		)
 		if err != nil {
 			return rooms, err
@@ -141,20 +211,825 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time
 	return rooms, nil
 }
 
-// GetRoomByID get a room by roomID
+// GetRoomByID get a room by roomID. It reads from a replica
 func (m *postgresDBRepo) GetRoomByID(roomID int) (models.Room, error) {
 
 	// creating context to make sure that the txn should not open for more than set time like adding a default timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1;`
+	query := `select id, room_name, property_id, nightly_rate, created_at, updated_at from rooms where id = $1;`
 
 	var room models.Room
-	err := m.DB.QueryRowContext(ctx, query, roomID).Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	err := m.reader().QueryRowContext(ctx, query, roomID).Scan(&room.ID, &room.RoomName, &room.PropertyID, &room.NightlyRate, &room.CreatedAt, &room.UpdatedAt)
 	if err != nil {
 		return room, err
 	}
 
 	return room, nil
 }
+
+// AllRooms returns the rooms of a property ordered by name
+func (m *postgresDBRepo) AllRooms(propertyID int) ([]models.Room, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_name, property_id, nightly_rate, created_at, updated_at from rooms
+		where property_id = $1 order by room_name, id`
+
+	rows, err := m.reader().QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(&room.ID, &room.RoomName, &room.PropertyID, &room.NightlyRate, &room.CreatedAt, &room.UpdatedAt)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+// propertyColumns are the columns selected for a property, in the order scanned by scanProperty
+const propertyColumns = `id, name, slug, coalesce(hostname, ''), address, timezone, currency, contact_email,
+	created_at, updated_at`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProperty(row scanner) (models.Property, error) {
+
+	var p models.Property
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Slug,
+		&p.Hostname,
+		&p.Address,
+		&p.Timezone,
+		&p.Currency,
+		&p.ContactEmail,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+// AllProperties returns all the properties ordered by name
+func (m *postgresDBRepo) AllProperties() ([]models.Property, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var properties []models.Property
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return properties, err
+	}
+	return properties, nil
+}
+
+// GetPropertyByID gets a property by id
+func (m *postgresDBRepo) GetPropertyByID(id int) (models.Property, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where id = $1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetPropertyBySlug gets a property by the slug used in the /p/{slug} path prefix
+func (m *postgresDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where slug = $1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// GetPropertyByHostname gets a property by the hostname its public site is served on
+func (m *postgresDBRepo) GetPropertyByHostname(hostname string) (models.Property, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where lower(hostname) = lower($1)`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, hostname))
+}
+
+// userColumns are the columns selected for a user, in the order scanned by scanUser. The queries
+// select from users without an alias
+const userColumns = `id, first_name, last_name, email, password, access_level, session_version,
+	totp_secret, totp_enabled_at is not null,
+	exists (select 1 from two_factor_policy tp where tp.access_level = users.access_level),
+	created_at, updated_at`
+
+func scanUser(row scanner) (models.User, error) {
+
+	var u models.User
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.SessionVersion,
+		&u.TOTPSecret,
+		&u.TwoFactorEnabled,
+		&u.TwoFactorRequired,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	return u, err
+}
+
+// GetUserByID returns a user by id
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where id = $1`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetUserByEmail returns a user by email address, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
+
+// Authenticate authenticates a staff user. It returns the user id and the password hash
+func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	// emails are matched whatever their case, like in GetUserByEmail
+	row := m.DB.QueryRowContext(ctx, "select id, password from users where lower(email) = lower($1)", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// PropertiesForUser returns the properties a staff user is allowed to manage: every property for
+// admins, the properties granted in property_users for the other users
+func (m *postgresDBRepo) PropertiesForUser(userID int) ([]models.Property, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select p.id, p.name, p.slug, coalesce(p.hostname, ''), p.address, p.timezone, p.currency,
+			p.contact_email, p.created_at, p.updated_at
+		from
+			properties p
+		where
+			p.id in (select property_id from property_users where user_id = $1)
+			or exists (select 1 from users where id = $1 and access_level = $2)
+		order by p.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, models.AccessLevelAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var properties []models.Property
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return properties, err
+	}
+	return properties, nil
+}
+
+// UserHasProperty returns true if the staff user is allowed to manage the property, see
+// PropertiesForUser
+func (m *postgresDBRepo) UserHasProperty(userID, propertyID int) (bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select
+			exists (select 1 from property_users where user_id = $1 and property_id = $2)
+			or exists (select 1 from users where id = $1 and access_level = $3)
+				and exists (select 1 from properties where id = $2)`
+
+	var ok bool
+	err := m.DB.QueryRowContext(ctx, query, userID, propertyID, models.AccessLevelAdmin).Scan(&ok)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// AllReservations returns all the reservations for the rooms of a property. It reads from a replica
+func (m *postgresDBRepo) AllReservations(propertyID int) ([]models.Reservation, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name, rm.property_id
+		from
+			reservations r
+			join rooms rm on (r.room_id = rm.id)
+		where
+			rm.property_id = $1
+		order by r.start_date asc`
+
+	rows, err := m.reader().QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []models.Reservation
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+			&i.Room.PropertyID,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// InsertPasswordReset stores the hash of a password reset token
+func (m *postgresDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+	m.wrote()
+	return nil
+}
+
+// UserForPasswordReset returns the user of an unused and unexpired password reset token, or
+// repository.ErrTokenInvalid
+func (m *postgresDBRepo) UserForPasswordReset(tokenHash string) (models.User, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users
+		where id = (select user_id from password_resets
+			where token_hash = $1 and used_at is null and expires_at > now())`
+
+	u, err := scanUser(m.DB.QueryRowContext(ctx, query, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, repository.ErrTokenInvalid
+	}
+
+	return u, err
+}
+
+// ResetPassword sets a new password for the user of a password reset token and returns the user
+// id. The token and every other outstanding token of the user are used up, and the session
+// version is incremented so that the user is logged out everywhere. Returns
+// repository.ErrTokenInvalid for unknown, used or expired tokens
+func (m *postgresDBRepo) ResetPassword(actor models.Actor, tokenHash, password string) (int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// the row lock keeps two requests from using the same token
+	var userID int
+	err = tx.QueryRowContext(ctx, `select user_id from password_resets
+		where token_hash = $1 and used_at is null and expires_at > now()
+		for update`, tokenHash).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrTokenInvalid
+	} else if err != nil {
+		return 0, err
+	}
+
+	var sessionVersion int
+	err = tx.QueryRowContext(ctx, `update users set password = $1, session_version = session_version + 1,
+			updated_at = $2
+		where id = $3 returning session_version`, string(hash), time.Now(), userID).Scan(&sessionVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_resets set used_at = $1 where user_id = $2 and used_at is null`,
+		time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the password itself is never written to the audit log
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditUpdate,
+		entityType: auditUser,
+		entityID:   userID,
+		before:     map[string]interface{}{"session_version": sessionVersion - 1},
+		after:      map[string]interface{}{"session_version": sessionVersion, "password": "reset"},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	m.wrote()
+	return userID, nil
+}
+
+// uniqueViolation is the postgres error code of a violated unique constraint
+const uniqueViolation = "23505"
+
+// InsertGuest registers a guest account. It returns repository.ErrDuplicateEmail if the email
+// address already has one
+func (m *postgresDBRepo) InsertGuest(guest models.Guest, password string) (int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into guests (first_name, last_name, email, phone, password, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, stmt,
+		guest.FirstName,
+		guest.LastName,
+		guest.Email,
+		guest.Phone,
+		string(hash),
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return 0, repository.ErrDuplicateEmail
+	} else if err != nil {
+		return 0, err
+	}
+
+	m.wrote()
+	return id, nil
+}
+
+// AuthenticateGuest checks the password of a guest account and returns its id
+func (m *postgresDBRepo) AuthenticateGuest(email, password string) (int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from guests where lower(email) = lower($1)", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, errors.New("incorrect password")
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetGuestByID returns a guest account by id
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, phone, password, created_at, updated_at
+		from guests where id = $1`
+
+	var g models.Guest
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Password,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+
+	return g, err
+}
+
+// GuestReservations returns the reservations of a guest account across all properties, the
+// latest stay first
+func (m *postgresDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// read from the primary, a guest expects to see the booking just made
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.guest_id, r.created_at, r.updated_at, rm.id, rm.room_name, rm.property_id,
+			p.name, p.currency
+		from
+			reservations r
+			join rooms rm on (r.room_id = rm.id)
+			join properties p on (rm.property_id = p.id)
+		where
+			r.guest_id = $1
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []models.Reservation
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.GuestID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+			&i.Room.PropertyID,
+			&i.Room.Property.Name,
+			&i.Room.Property.Currency,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		i.Room.Property.ID = i.Room.PropertyID
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// EnableTwoFactor turns on the second factor of a user with the secret confirmed by a code of the
+// period step, which can't be used again. The recovery codes replace any earlier ones
+func (m *postgresDBRepo) EnableTwoFactor(actor models.Actor, userID int, secret string, step int64, recoveryCodeHashes []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = $1, totp_enabled_at = $2, totp_last_step = $3,
+			updated_at = $2
+		where id = $4`, secret, time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	// neither the secret nor the codes are written to the audit log
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditUpdate,
+		entityType: auditUser,
+		entityID:   userID,
+		before:     map[string]interface{}{"two_factor": false},
+		after:      map[string]interface{}{"two_factor": true},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.wrote()
+	return nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and stores the new ones
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+
+	_, err := tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, `insert into user_recovery_codes (user_id, code_hash, created_at)
+			values ($1, $2, $3)`, userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DisableTwoFactor turns off the second factor of a user and deletes the secret and recovery codes
+func (m *postgresDBRepo) DisableTwoFactor(actor models.Actor, userID int) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = '', totp_enabled_at = null, totp_last_step = 0,
+			updated_at = $1
+		where id = $2`, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditUpdate,
+		entityType: auditUser,
+		entityID:   userID,
+		before:     map[string]interface{}{"two_factor": true},
+		after:      map[string]interface{}{"two_factor": false},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.wrote()
+	return nil
+}
+
+// UseTOTPStep records that a code of the period step has been used to log in. It returns false if
+// a code of the same or a later period has been used already, i.e. the code is replayed
+func (m *postgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update users set totp_last_step = $1
+		where id = $2 and totp_last_step < $1`, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	m.wrote()
+	return n == 1, nil
+}
+
+// UseRecoveryCode uses up a recovery code of a user. It returns false for unknown and used codes
+func (m *postgresDBRepo) UseRecoveryCode(actor models.Actor, userID int, codeHash string) (bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `update user_recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	var left int
+	err = tx.QueryRowContext(ctx, `select count(*) from user_recovery_codes
+		where user_id = $1 and used_at is null`, userID).Scan(&left)
+	if err != nil {
+		return false, err
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditUpdate,
+		entityType: auditUser,
+		entityID:   userID,
+		before:     map[string]interface{}{"recovery_codes_left": left + 1},
+		after:      map[string]interface{}{"recovery_codes_left": left},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	m.wrote()
+	return true, nil
+}
+
+// TwoFactorPolicy returns the access levels whose users must log in with a second factor
+func (m *postgresDBRepo) TwoFactorPolicy() ([]int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select access_level from two_factor_policy order by access_level`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levels []int
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			return levels, err
+		}
+		levels = append(levels, level)
+	}
+
+	if err = rows.Err(); err != nil {
+		return levels, err
+	}
+	return levels, nil
+}
+
+// SetTwoFactorPolicy replaces the access levels whose users must log in with a second factor
+func (m *postgresDBRepo) SetTwoFactorPolicy(actor models.Actor, accessLevels []int) error {
+
+	before, err := m.TwoFactorPolicy()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `delete from two_factor_policy`); err != nil {
+		return err
+	}
+
+	for _, level := range accessLevels {
+		_, err = tx.ExecContext(ctx, `insert into two_factor_policy (access_level, created_at) values ($1, $2)`,
+			level, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertAuditEvent(ctx, tx, auditRecord{
+		actor:      actor,
+		action:     models.AuditUpdate,
+		entityType: auditTwoFactorPolicy,
+		before:     map[string]interface{}{"access_levels": before},
+		after:      map[string]interface{}{"access_levels": accessLevels},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.wrote()
+	return nil
+}
@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// RoomNights returns every room of a property on every day from from to to, both included, on
+// which the room existed, with the owner blocks and reservations of the night. The amount of a reservation is spread evenly
+// over its nights, reservations without an amount are valued at the rate of their room. Ordered by
+// room and day. It reads from a replica
+func (m *postgresDBRepo) RoomNights(propertyID int, from, to time.Time) ([]models.RoomNight, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `select rm.id, rm.room_name, d.day::date,
+			exists (
+				select 1 from room_restrictions rr
+				where rr.room_id = rm.id and rr.restriction_id = $4
+					and rr.start_date <= d.day and rr.end_date > d.day
+			),
+			count(r.id),
+			coalesce(sum(
+				coalesce(r.amount, (r.end_date - r.start_date) * rm.nightly_rate)::float8
+					/ greatest(r.end_date - r.start_date, 1)
+			), 0)
+		from
+			rooms rm
+			cross join generate_series($2::date, $3::date, interval '1 day') as d(day)
+			left join reservations r on (r.room_id = rm.id and r.start_date <= d.day and r.end_date > d.day)
+		where
+			rm.property_id = $1 and d.day >= rm.created_at::date
+		group by rm.id, rm.room_name, d.day
+		order by rm.id, d.day`
+
+	rows, err := m.reader().QueryContext(ctx, query, propertyID, from, to, models.RestrictionOwnerBlock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nights []models.RoomNight
+	for rows.Next() {
+		var n models.RoomNight
+		err := rows.Scan(&n.RoomID, &n.RoomName, &n.Day, &n.Blocked, &n.Sold, &n.Revenue)
+		if err != nil {
+			return nights, err
+		}
+		nights = append(nights, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nights, err
+	}
+	return nights, nil
+}
@@ -0,0 +1,341 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+)
+
+// Limits of a page of reservations
+const (
+	defaultReservationLimit = 50
+	maxReservationLimit     = 200
+)
+
+// sortColumn is a column reservations can be sorted by. value formats the column of a
+// reservation for a cursor, parse turns it back into the type of the column. cast is that type in
+// the query
+type sortColumn struct {
+	expr  string
+	cast  string
+	value func(models.Reservation) string
+	parse func(string) (interface{}, error)
+}
+
+// Layouts of the dates and times of cursors
+const (
+	cursorDate = "2006-01-02"
+	cursorTime = "2006-01-02T15:04:05.999999"
+)
+
+var reservationSortColumns = map[string]sortColumn{
+	models.ReservationSortStart: {"r.start_date", "date", func(r models.Reservation) string {
+		return r.StartDate.Format(cursorDate)
+	}, parseCursorTime(cursorDate)},
+	models.ReservationSortEnd: {"r.end_date", "date", func(r models.Reservation) string {
+		return r.EndDate.Format(cursorDate)
+	}, parseCursorTime(cursorDate)},
+	models.ReservationSortLastName: {"r.last_name", "text", func(r models.Reservation) string {
+		return r.LastName
+	}, func(v string) (interface{}, error) {
+		return v, nil
+	}},
+	models.ReservationSortCreated: {"r.created_at", "timestamp", func(r models.Reservation) string {
+		return r.CreatedAt.Format(cursorTime)
+	}, parseCursorTime(cursorTime)},
+	models.ReservationSortID: {"r.id", "integer", func(r models.Reservation) string {
+		return strconv.Itoa(r.ID)
+	}, func(v string) (interface{}, error) {
+		return strconv.ParseInt(v, 10, 32)
+	}},
+}
+
+// parseCursorTime returns a parse func of sortColumn for times formatted with layout
+func parseCursorTime(layout string) func(string) (interface{}, error) {
+
+	return func(v string) (interface{}, error) {
+		return time.Parse(layout, v)
+	}
+}
+
+// reservationCursor is the position after the last reservation of a page: the value of the sort
+// column and the id, which breaks ties. The sort is part of it, so that a cursor isn't applied
+// to another order
+type reservationCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+func encodeCursor(c reservationCursor) string {
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (reservationCursor, error) {
+
+	var c reservationCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, repository.ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, repository.ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// nameQuery turns the words typed into a tsquery matching names starting with each of them, e.g.
+// "smi jo" gives "smi:* & jo:*". Everything but letters and digits is dropped, so that the input
+// can't break the query syntax
+func nameQuery(s string) string {
+
+	var terms []string
+	for _, word := range strings.Fields(s) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, word)
+		if word != "" {
+			terms = append(terms, word+":*")
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// digits returns the digits of s
+func digits(s string) string {
+
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// reservationColumns are the columns of reservations and their rooms selected by the search and
+// the export, in the order of scanReservation
+const reservationColumns = `r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+		r.room_id, coalesce(r.guest_id, 0), r.created_at, r.updated_at, rm.id, rm.room_name, rm.property_id`
+
+// scanReservation scans a row of reservationColumns
+func scanReservation(rows *sql.Rows) (models.Reservation, error) {
+
+	var i models.Reservation
+	err := rows.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.StartDate,
+		&i.EndDate,
+		&i.RoomID,
+		&i.GuestID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Room.ID,
+		&i.Room.RoomName,
+		&i.Room.PropertyID,
+	)
+	return i, err
+}
+
+// reservationSort returns the sort column of filter
+func reservationSort(filter models.ReservationFilter) (string, sortColumn, error) {
+
+	sortKey := filter.Sort
+	if sortKey == "" {
+		sortKey = models.ReservationSortStart
+	}
+	sort, ok := reservationSortColumns[sortKey]
+	if !ok {
+		return "", sort, fmt.Errorf("unknown sort column %q", filter.Sort)
+	}
+	return sortKey, sort, nil
+}
+
+// reservationWhere returns the conditions of filter, except the cursor, and their arguments
+func reservationWhere(filter models.ReservationFilter) ([]string, []interface{}, error) {
+
+	today := filter.Today
+	if today.IsZero() {
+		today = time.Now()
+	}
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	add("rm.property_id = $?", filter.PropertyID)
+
+	if q := nameQuery(filter.Name); q != "" {
+		// uses reservations_name_search_idx
+		add("to_tsvector('simple', r.first_name || ' ' || r.last_name) @@ to_tsquery('simple', $?)", q)
+	}
+	if filter.Email != "" {
+		// uses reservations_email_idx
+		add("r.email = $?", strings.TrimSpace(filter.Email))
+	}
+	if d := digits(filter.Phone); d != "" {
+		add("regexp_replace(r.phone, '[^0-9]', '', 'g') like '%' || $? || '%'", d)
+	}
+	if filter.RoomID != 0 {
+		add("r.room_id = $?", filter.RoomID)
+	}
+	// stays overlap the range if they start before its end and end after its start. The end date
+	// of a stay is the day of departure
+	if !filter.From.IsZero() {
+		add("r.end_date > $?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("r.start_date <= $?", filter.To)
+	}
+	switch filter.Status {
+	case "":
+	case models.ReservationUpcoming:
+		add("r.start_date > $?", today)
+	case models.ReservationInHouse:
+		add("r.start_date <= $? and r.end_date > $?", today)
+	case models.ReservationDeparted:
+		add("r.end_date <= $?", today)
+	default:
+		return nil, nil, fmt.Errorf("unknown reservation status %q", filter.Status)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("r.created_at >= $?", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("r.created_at < $?", filter.CreatedTo.AddDate(0, 0, 1))
+	}
+
+	return where, args, nil
+}
+
+// reservationSearchQuery builds the query of SearchReservations. It selects one row more than the
+// limit, which tells whether there is a next page
+func reservationSearchQuery(filter models.ReservationFilter) (string, []interface{}, int, error) {
+
+	sortKey, sort, err := reservationSort(filter)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultReservationLimit
+	}
+	if limit > maxReservationLimit {
+		limit = maxReservationLimit
+	}
+
+	where, args, err := reservationWhere(filter)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	dir, cmp := "asc", ">"
+	if filter.Desc {
+		dir, cmp = "desc", "<"
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if c.Sort != sortKey || c.Desc != filter.Desc {
+			return "", nil, 0, repository.ErrInvalidCursor
+		}
+		// a value which doesn't fit the column would fail the query
+		value, err := sort.parse(c.Value)
+		if err != nil {
+			return "", nil, 0, repository.ErrInvalidCursor
+		}
+		args = append(args, value, c.ID)
+		where = append(where, fmt.Sprintf("(%s, r.id) %s ($%d::%s, $%d)", sort.expr, cmp, len(args)-1, sort.cast, len(args)))
+	}
+
+	query := `select ` + reservationColumns + `
+		from
+			reservations r
+			join rooms rm on (r.room_id = rm.id)
+		where ` + strings.Join(where, ` and `) +
+		fmt.Sprintf(` order by %s %s, r.id %s limit %d`, sort.expr, dir, dir, limit+1)
+
+	return query, args, limit, nil
+}
+
+// SearchReservations returns a page of the reservations of a property matching filter. The pages
+// are paginated by keyset, so that a page costs the same however far the listing goes. It reads
+// from a replica
+func (m *postgresDBRepo) SearchReservations(filter models.ReservationFilter) (models.ReservationPage, error) {
+
+	var page models.ReservationPage
+
+	query, args, limit, err := reservationSearchQuery(filter)
+	if err != nil {
+		return page, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i, err := scanReservation(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Reservations = append(page.Reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	page.Reservations, page.NextCursor = nextPage(page.Reservations, limit, filter)
+	return page, nil
+}
+
+// nextPage cuts the extra row selected beyond the limit and returns the cursor of the next page,
+// empty if there is none
+func nextPage(reservations []models.Reservation, limit int, filter models.ReservationFilter) ([]models.Reservation, string) {
+
+	if len(reservations) <= limit {
+		return reservations, ""
+	}
+	reservations = reservations[:limit]
+
+	sortKey, sort, _ := reservationSort(filter)
+	last := reservations[len(reservations)-1]
+
+	return reservations, encodeCursor(reservationCursor{
+		Sort:  sortKey,
+		Desc:  filter.Desc,
+		Value: sort.value(last),
+		ID:    last.ID,
+	})
+}
@@ -0,0 +1,219 @@
+package dbrepo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+)
+
+var nameQueryTests = []struct {
+	input    string
+	expected string
+}{
+	{"smith", "smith:*"},
+	{"smi  JO", "smi:* & jo:*"},
+	{"o'brien", "obrien:*"},
+	{"a & b | !c", "a:* & b:* & c:*"},
+	{"  ", ""},
+	{"Müller", "müller:*"},
+}
+
+func TestNameQuery(t *testing.T) {
+
+	for _, e := range nameQueryTests {
+		if got := nameQuery(e.input); got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.input, e.expected, got)
+		}
+	}
+}
+
+var searchQueryTests = []struct {
+	name             string
+	filter           models.ReservationFilter
+	expectedContains []string
+	expectedArgs     int
+	expectedLimit    int
+}{
+	{
+		"property-only",
+		models.ReservationFilter{PropertyID: 1},
+		[]string{"rm.property_id = $1", "order by r.start_date asc, r.id asc limit 51"},
+		1, 50,
+	},
+	{
+		"guest-filters",
+		models.ReservationFilter{PropertyID: 1, Name: "john", Email: "john@smith.com", Phone: "+1 (555) 01", RoomID: 2},
+		[]string{"@@ to_tsquery('simple', $2)", "r.email = $3", "like '%' || $4 || '%'", "r.room_id = $5"},
+		5, 50,
+	},
+	{
+		"date-overlap",
+		models.ReservationFilter{PropertyID: 1, From: time.Now(), To: time.Now()},
+		[]string{"r.end_date > $2", "r.start_date <= $3"},
+		3, 50,
+	},
+	{
+		"in-house",
+		models.ReservationFilter{PropertyID: 1, Status: models.ReservationInHouse},
+		[]string{"r.start_date <= $2 and r.end_date > $2"},
+		2, 50,
+	},
+	{
+		"created",
+		models.ReservationFilter{PropertyID: 1, CreatedFrom: time.Now(), CreatedTo: time.Now()},
+		[]string{"r.created_at >= $2", "r.created_at < $3"},
+		3, 50,
+	},
+	{
+		"sort-desc-limited",
+		models.ReservationFilter{PropertyID: 1, Sort: models.ReservationSortLastName, Desc: true, Limit: 1000},
+		[]string{"order by r.last_name desc, r.id desc limit 201"},
+		1, 200,
+	},
+	{
+		"cursor",
+		models.ReservationFilter{
+			PropertyID: 1,
+			Sort:       models.ReservationSortCreated,
+			Cursor:     encodeCursor(reservationCursor{Sort: models.ReservationSortCreated, Value: "2021-10-01T10:00:00", ID: 7}),
+		},
+		[]string{"(r.created_at, r.id) > ($2::timestamp, $3)"},
+		3, 50,
+	},
+}
+
+func TestReservationSearchQuery(t *testing.T) {
+
+	for _, e := range searchQueryTests {
+		query, args, limit, err := reservationSearchQuery(e.filter)
+		if err != nil {
+			t.Errorf("failed %s: %s", e.name, err)
+			continue
+		}
+
+		for _, s := range e.expectedContains {
+			if !strings.Contains(query, s) {
+				t.Errorf("failed %s: expected %q in the query %s", e.name, s, query)
+			}
+		}
+		if len(args) != e.expectedArgs {
+			t.Errorf("failed %s: expected %d arguments but got %v", e.name, e.expectedArgs, args)
+		}
+		if limit != e.expectedLimit {
+			t.Errorf("failed %s: expected limit %d but got %d", e.name, e.expectedLimit, limit)
+		}
+	}
+}
+
+var invalidSearchTests = []struct {
+	name           string
+	filter         models.ReservationFilter
+	expectedCursor bool
+}{
+	{"unknown-sort", models.ReservationFilter{Sort: "phone"}, false},
+	{"unknown-status", models.ReservationFilter{Status: "cancelled"}, false},
+	{"garbage-cursor", models.ReservationFilter{Cursor: "not a cursor"}, true},
+	{
+		"cursor-of-other-sort",
+		models.ReservationFilter{Sort: models.ReservationSortEnd, Cursor: encodeCursor(reservationCursor{Sort: models.ReservationSortStart})},
+		true,
+	},
+	{
+		"cursor-of-other-direction",
+		models.ReservationFilter{Desc: true, Cursor: encodeCursor(reservationCursor{Sort: models.ReservationSortStart})},
+		true,
+	},
+	{
+		"cursor-not-a-date",
+		models.ReservationFilter{Cursor: encodeCursor(reservationCursor{Sort: models.ReservationSortStart, Value: "yesterday"})},
+		true,
+	},
+	{
+		"cursor-not-a-time",
+		models.ReservationFilter{
+			Sort:   models.ReservationSortCreated,
+			Cursor: encodeCursor(reservationCursor{Sort: models.ReservationSortCreated, Value: "2021-13-01T10:00:00"}),
+		},
+		true,
+	},
+	{
+		"cursor-not-an-id",
+		models.ReservationFilter{
+			Sort:   models.ReservationSortID,
+			Cursor: encodeCursor(reservationCursor{Sort: models.ReservationSortID, Value: "1 or 1=1"}),
+		},
+		true,
+	},
+}
+
+func TestReservationSearchQueryInvalid(t *testing.T) {
+
+	for _, e := range invalidSearchTests {
+		_, _, _, err := reservationSearchQuery(e.filter)
+		if err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+			continue
+		}
+		if errors.Is(err, repository.ErrInvalidCursor) != e.expectedCursor {
+			t.Errorf("failed %s: expected an invalid cursor %v but got %s", e.name, e.expectedCursor, err)
+		}
+	}
+}
+
+func TestNextPage(t *testing.T) {
+
+	day := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	reservations := []models.Reservation{
+		{ID: 3, StartDate: day},
+		{ID: 1, StartDate: day.AddDate(0, 0, 1)},
+		{ID: 2, StartDate: day.AddDate(0, 0, 2)},
+	}
+	filter := models.ReservationFilter{Desc: true}
+
+	page, cursor := nextPage(reservations, 2, filter)
+	if len(page) != 2 {
+		t.Fatalf("expected the extra row to be cut but got %d rows", len(page))
+	}
+
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := reservationCursor{Sort: models.ReservationSortStart, Desc: true, Value: "2021-10-02", ID: 1}
+	if c != expected {
+		t.Errorf("expected cursor %+v but got %+v", expected, c)
+	}
+
+	filter.Cursor = cursor
+	if _, _, _, err := reservationSearchQuery(filter); err != nil {
+		t.Errorf("the cursor of a page must continue the same search: %s", err)
+	}
+
+	if _, cursor := nextPage(reservations, 3, filter); cursor != "" {
+		t.Errorf("expected no cursor on the last page but got %q", cursor)
+	}
+}
+
+func TestReservationExportQuery(t *testing.T) {
+
+	filter := models.ReservationFilter{PropertyID: 1, Name: "smith", Sort: models.ReservationSortEnd, Desc: true, Cursor: "ignored", Limit: 10}
+
+	query, args, err := reservationExportQuery(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(query, "order by r.end_date desc, r.id desc") {
+		t.Errorf("expected the sort of the search without a limit but got %s", query)
+	}
+	if len(args) != 2 {
+		t.Errorf("expected the property and name arguments but got %v", args)
+	}
+
+	if _, _, err := reservationExportQuery(models.ReservationFilter{Status: "cancelled"}); err == nil {
+		t.Error("expected an unknown status to fail")
+	}
+}
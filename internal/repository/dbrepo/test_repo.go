@@ -2,20 +2,30 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository"
+	"github.com/prayagsingh/bookings/internal/tokens"
 )
 
+// WithContext returns the test repository, it has no replicas
+func (m *testPostgresDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return m
+}
+
 func (m *testPostgresDBRepo) AllUsers() bool {
 
 	return true
 }
 
 // InsertReservation inserts a reservation into the db
-func (m *testPostgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testPostgresDBRepo) InsertReservation(actor models.Actor, res models.Reservation) (int, error) {
 	// if the room id is 2 then fail otherwise pass
 	if res.RoomID == 2 {
 		return 0, errors.New("invalid room ID")
@@ -24,7 +34,7 @@ func (m *testPostgresDBRepo) InsertReservation(res models.Reservation) (int, err
 }
 
 // InserRoomRestriction inserts a room restriction into DB
-func (m *testPostgresDBRepo) InserRoomRestriction(res models.RoomRestriction) error {
+func (m *testPostgresDBRepo) InserRoomRestriction(actor models.Actor, res models.RoomRestriction) error {
 	// for room id 1000, make it faile
 	if res.RoomID == 1000 {
 		return errors.New("room id is incorrect")
@@ -63,7 +73,7 @@ func (m *testPostgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.T
 }
 
 // SearchAvailabilityForAllRooms returns a slice of rooms for a given date range
-func (m *testPostgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time.Time) ([]models.Room, error) {
+func (m *testPostgresDBRepo) SearchAvailabilityForAllRooms(start_date, end_date time.Time, propertyID int) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -104,8 +114,417 @@ func (m *testPostgresDBRepo) GetRoomByID(roomID int) (models.Room, error) {
 
 	var room models.Room
 
-	if roomID > 2 {
+	// room 1000 exists so that inserting its room restriction can fail
+	switch {
+	case roomID == TestForeignRoomID:
+		return models.Room{ID: roomID, RoomName: "Foreign Room", PropertyID: testProperty.ID + 1}, nil
+	case roomID > 2 && roomID != 1000:
 		return room, errors.New("room not found. room-id is greator than 2")
 	}
+
+	room.ID = roomID
+	room.PropertyID = testProperty.ID
 	return room, nil
 }
+
+// TestForeignRoomID is a room of another property than the test property
+const TestForeignRoomID = 99
+
+// AllRooms returns the two rooms of the test property
+func (m *testPostgresDBRepo) AllRooms(propertyID int) ([]models.Room, error) {
+
+	if propertyID != testProperty.ID {
+		return nil, nil
+	}
+	return []models.Room{
+		{ID: 1, RoomName: "General's Quarters", PropertyID: 1},
+		{ID: 2, RoomName: "Major's Suite", PropertyID: 1},
+	}, nil
+}
+
+// testProperty is the only property known to the test repository
+var testProperty = models.Property{
+	ID:       1,
+	Name:     "Aisa Fort",
+	Slug:     "aisa-fort",
+	Hostname: "aisa-fort.test",
+	Timezone: "UTC",
+	Currency: "USD",
+}
+
+// AllProperties returns all the properties ordered by name
+func (m *testPostgresDBRepo) AllProperties() ([]models.Property, error) {
+
+	return []models.Property{testProperty}, nil
+}
+
+// GetPropertyByID gets a property by id
+func (m *testPostgresDBRepo) GetPropertyByID(id int) (models.Property, error) {
+
+	if id != testProperty.ID {
+		return models.Property{}, errors.New("property not found")
+	}
+	return testProperty, nil
+}
+
+// GetPropertyBySlug gets a property by the slug used in the /p/{slug} path prefix
+func (m *testPostgresDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+
+	if slug != testProperty.Slug {
+		return models.Property{}, sql.ErrNoRows
+	}
+	return testProperty, nil
+}
+
+// GetPropertyByHostname gets a property by the hostname its public site is served on
+func (m *testPostgresDBRepo) GetPropertyByHostname(hostname string) (models.Property, error) {
+
+	switch hostname {
+	case testProperty.Hostname:
+		return testProperty, nil
+	case TestUnreachableHostname:
+		return models.Property{}, errors.New("connection refused")
+	}
+	return models.Property{}, sql.ErrNoRows
+}
+
+// TestUnreachableHostname is a hostname whose lookup fails as if the database was down
+const TestUnreachableHostname = "db-down.test"
+
+// GetUserByID returns a user by id. User 1 is an admin, user 2 works at the front desk, user 3 is a
+// manager logging in with a second factor
+func (m *testPostgresDBRepo) GetUserByID(id int) (models.User, error) {
+
+	var u models.User
+	switch id {
+	case 1:
+		u = models.User{ID: 1, Email: "admin@here.com", AccessLevel: models.AccessLevelAdmin, SessionVersion: 1}
+	case 2:
+		u = models.User{ID: 2, Email: "staff@here.com", AccessLevel: models.AccessLevelFrontDesk, SessionVersion: 1}
+	case 3:
+		u = models.User{ID: 3, Email: "manager@here.com", AccessLevel: models.AccessLevelManager, SessionVersion: 1,
+			TOTPSecret: TestTOTPSecret, TwoFactorEnabled: true, TwoFactorRequired: true}
+	default:
+		return u, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail returns a user by email address
+func (m *testPostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+
+	switch strings.ToLower(email) {
+	case "admin@here.com":
+		return m.GetUserByID(1)
+	case "staff@here.com":
+		return m.GetUserByID(2)
+	case "manager@here.com":
+		return m.GetUserByID(3)
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// Authenticate authenticates a staff user. Only admin@here.com and manager@here.com with password
+// "password" succeed
+func (m *testPostgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+
+	if email == "admin@here.com" && testPassword == "password" {
+		return 1, "", nil
+	}
+	if email == "manager@here.com" && testPassword == "password" {
+		return 3, "", nil
+	}
+	return 0, "", errors.New("incorrect password")
+}
+
+// PropertiesForUser returns the properties a staff user is allowed to manage. User 1 is an admin
+// and manages every property, the other users have been granted none
+func (m *testPostgresDBRepo) PropertiesForUser(userID int) ([]models.Property, error) {
+
+	if userID != 1 {
+		return nil, nil
+	}
+	return m.AllProperties()
+}
+
+// UserHasProperty returns true if the staff user is allowed to manage the property
+func (m *testPostgresDBRepo) UserHasProperty(userID, propertyID int) (bool, error) {
+
+	return userID == 1 && propertyID == testProperty.ID, nil
+}
+
+// AllReservations returns all the reservations for the rooms of a property
+func (m *testPostgresDBRepo) AllReservations(propertyID int) ([]models.Reservation, error) {
+
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+// TestCursor is the cursor of the second page of the reservation search
+const TestCursor = "test-cursor"
+
+// SearchReservations returns reservation 1 on the first page and reservation 2 on the page of
+// TestCursor. Other cursors are invalid
+func (m *testPostgresDBRepo) SearchReservations(filter models.ReservationFilter) (models.ReservationPage, error) {
+
+	today := time.Now().Truncate(24 * time.Hour)
+	room := models.Room{ID: 1, RoomName: "General's Quarters", PropertyID: 1, Property: testProperty}
+
+	switch filter.Cursor {
+	case "":
+		return models.ReservationPage{
+			Reservations: []models.Reservation{
+				{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", RoomID: 1,
+					StartDate: today.AddDate(0, 0, -1), EndDate: today.AddDate(0, 0, 2), Room: room},
+			},
+			NextCursor: TestCursor,
+		}, nil
+	case TestCursor:
+		return models.ReservationPage{
+			Reservations: []models.Reservation{
+				{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", RoomID: 1,
+					StartDate: today.AddDate(0, 0, 10), EndDate: today.AddDate(0, 0, 12), Room: room},
+			},
+		}, nil
+	}
+	return models.ReservationPage{}, repository.ErrInvalidCursor
+}
+
+// ExportReservations calls fn for the reservations of both pages of the search
+func (m *testPostgresDBRepo) ExportReservations(filter models.ReservationFilter, fn func(models.Reservation) error) error {
+
+	for _, cursor := range []string{"", TestCursor} {
+		page, _ := m.SearchReservations(models.ReservationFilter{Cursor: cursor})
+		for _, res := range page.Reservations {
+			if err := fn(res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportRoomRestrictions calls fn for the owner block of room 1
+func (m *testPostgresDBRepo) ExportRoomRestrictions(filter models.RoomRestrictionFilter, fn func(models.RoomRestriction) error) error {
+
+	today := time.Now().Truncate(24 * time.Hour)
+
+	return fn(models.RoomRestriction{
+		ID:            1,
+		RoomID:        1,
+		RestrictionID: 2,
+		StartDate:     today.AddDate(0, 0, 20),
+		EndDate:       today.AddDate(0, 0, 22),
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters", PropertyID: 1},
+		Restriction:   models.Restriction{ID: 2, RestrictionName: "Owner Block"},
+	})
+}
+
+// ImportBookings reports every row of room 2 as a conflict, the room is booked for good
+func (m *testPostgresDBRepo) ImportBookings(actor models.Actor, propertyID int, rows []models.ImportRow, commit bool) ([]models.ImportError, error) {
+
+	var conflicts []models.ImportError
+	for _, row := range rows {
+		if row.Reservation.RoomID == 2 {
+			conflicts = append(conflicts, models.ImportError{Line: row.Line, Message: "the room is booked"})
+		}
+	}
+	return conflicts, nil
+}
+
+// RoomNights returns the nights of the two rooms of the test property. Room 1 is sold every night
+// at 100.00 and room 2, which exists since 2021, is blocked by its owner on the first day of every
+// month
+func (m *testPostgresDBRepo) RoomNights(propertyID int, from, to time.Time) ([]models.RoomNight, error) {
+
+	rooms := []models.Room{
+		{ID: 1, RoomName: "General's Quarters"},
+		{ID: 2, RoomName: "Major's Suite", CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	var nights []models.RoomNight
+	for _, room := range rooms {
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			if day.Before(room.CreatedAt) {
+				continue
+			}
+			n := models.RoomNight{RoomID: room.ID, RoomName: room.RoomName, Day: day}
+			if room.ID == 1 {
+				n.Sold = 1
+				n.Revenue = 10000
+			} else {
+				n.Blocked = day.Day() == 1
+			}
+			nights = append(nights, n)
+		}
+	}
+	return nights, nil
+}
+
+// AuditEvents returns the audit events matching filter. Reservation 1 has been created by a guest,
+// user 1 has enabled two factor authentication and user 2, who has no property, has been created
+func (m *testPostgresDBRepo) AuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error) {
+
+	events := []models.AuditEvent{
+		{
+			ID:         1,
+			Actor:      models.Actor{Type: models.ActorGuest, IP: "192.0.2.1"},
+			Action:     models.AuditCreate,
+			EntityType: auditReservation,
+			EntityID:   1,
+			PropertyID: testProperty.ID,
+			After:      []byte(`{"first_name":"John","last_name":"Smith"}`),
+		},
+		{
+			ID:         2,
+			Actor:      models.Actor{Type: models.ActorUser, UserID: 1},
+			Action:     models.AuditUpdate,
+			EntityType: auditUser,
+			EntityID:   1,
+			After:      []byte(`{"two_factor":true}`),
+		},
+		{
+			ID:         3,
+			Actor:      models.SystemActor("seed"),
+			Action:     models.AuditCreate,
+			EntityType: auditUser,
+			EntityID:   2,
+			After:      []byte(`{"email":"nobody@here.com"}`),
+		},
+	}
+
+	var matching []models.AuditEvent
+	for _, e := range events {
+		inProperty := e.PropertyID == filter.PropertyID ||
+			e.PropertyID == 0 && e.EntityType == auditUser && e.EntityID == 1 && filter.PropertyID == testProperty.ID
+		if filter.PropertyID != 0 && !inProperty ||
+			filter.EntityType != "" && e.EntityType != filter.EntityType ||
+			filter.EntityID != 0 && e.EntityID != filter.EntityID {
+			continue
+		}
+		matching = append(matching, e)
+	}
+
+	return matching, nil
+}
+
+// TestResetToken is the password reset token known to the test repository, it belongs to user 1
+const TestResetToken = "test-reset-token"
+
+// InsertPasswordReset stores the hash of a password reset token
+func (m *testPostgresDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+
+	return nil
+}
+
+// UserForPasswordReset returns user 1 for TestResetToken
+func (m *testPostgresDBRepo) UserForPasswordReset(tokenHash string) (models.User, error) {
+
+	if tokenHash != tokens.Hash(TestResetToken) {
+		return models.User{}, repository.ErrTokenInvalid
+	}
+	return m.GetUserByID(1)
+}
+
+// ResetPassword accepts TestResetToken only
+func (m *testPostgresDBRepo) ResetPassword(actor models.Actor, tokenHash, password string) (int, error) {
+
+	if tokenHash != tokens.Hash(TestResetToken) {
+		return 0, repository.ErrTokenInvalid
+	}
+	return 1, nil
+}
+
+// testGuest is the guest account known to the test repository, its password is "password"
+var testGuest = models.Guest{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-0100"}
+
+// InsertGuest registers a guest account. john@smith.com is taken already
+func (m *testPostgresDBRepo) InsertGuest(guest models.Guest, password string) (int, error) {
+
+	if strings.EqualFold(guest.Email, testGuest.Email) {
+		return 0, repository.ErrDuplicateEmail
+	}
+	return 2, nil
+}
+
+// AuthenticateGuest succeeds for john@smith.com with password "password" only
+func (m *testPostgresDBRepo) AuthenticateGuest(email, password string) (int, error) {
+
+	if strings.EqualFold(email, testGuest.Email) && password == "password" {
+		return testGuest.ID, nil
+	}
+	return 0, errors.New("incorrect password")
+}
+
+// GetGuestByID returns guest 1, and an empty profile for the guest registered by InsertGuest
+func (m *testPostgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+
+	switch id {
+	case 1:
+		return testGuest, nil
+	case 2:
+		return models.Guest{ID: 2, Email: "new@guest.com"}, nil
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// GuestReservations returns a past and an upcoming stay of guest 1
+func (m *testPostgresDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+
+	if guestID != testGuest.ID {
+		return nil, nil
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	room := models.Room{ID: 1, RoomName: "General's Quarters", PropertyID: 1, Property: testProperty}
+
+	return []models.Reservation{
+		{ID: 2, FirstName: "John", LastName: "Smith", Email: testGuest.Email, RoomID: 1, GuestID: 1,
+			StartDate: today.AddDate(0, 0, 10), EndDate: today.AddDate(0, 0, 12), Room: room},
+		{ID: 1, FirstName: "John", LastName: "Smith", Email: testGuest.Email, RoomID: 1, GuestID: 1,
+			StartDate: today.AddDate(0, -1, 0), EndDate: today.AddDate(0, -1, 3), Room: room},
+	}, nil
+}
+
+// TestTOTPSecret is the authenticator secret of user 3
+const TestTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+// TestRecoveryCode is the only unused recovery code of user 3
+const TestRecoveryCode = "k7mq-x2pd-9rtw"
+
+// EnableTwoFactor turns on the second factor of a user
+func (m *testPostgresDBRepo) EnableTwoFactor(actor models.Actor, userID int, secret string, step int64, recoveryCodeHashes []string) error {
+
+	return nil
+}
+
+// DisableTwoFactor turns off the second factor of a user
+func (m *testPostgresDBRepo) DisableTwoFactor(actor models.Actor, userID int) error {
+
+	return nil
+}
+
+// UseTOTPStep accepts every period, the test repository doesn't remember them
+func (m *testPostgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+
+	return true, nil
+}
+
+// UseRecoveryCode accepts TestRecoveryCode of user 3
+func (m *testPostgresDBRepo) UseRecoveryCode(actor models.Actor, userID int, codeHash string) (bool, error) {
+
+	return userID == 3 && codeHash == tokens.Hash(TestRecoveryCode), nil
+}
+
+// TwoFactorPolicy requires a second factor of managers
+func (m *testPostgresDBRepo) TwoFactorPolicy() ([]int, error) {
+
+	return []int{models.AccessLevelManager}, nil
+}
+
+// SetTwoFactorPolicy replaces the access levels requiring a second factor
+func (m *testPostgresDBRepo) SetTwoFactorPolicy(actor models.Actor, accessLevels []int) error {
+
+	return nil
+}
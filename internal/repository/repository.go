@@ -1,19 +1,84 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/prayagsingh/bookings/internal/models"
 )
 
+// ErrDuplicateEmail is returned when registering an email address which already has an account
+var ErrDuplicateEmail = errors.New("email address is already registered")
+
+// ErrInvalidCursor is returned for pagination cursors which are malformed or belong to another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrTokenInvalid is returned for tokens which don't exist, have expired or have been used
+var ErrTokenInvalid = errors.New("token is invalid or has expired")
+
 type DatabaseRepo interface {
 
+	// WithContext returns the repository for the client of ctx, see driver.WithClient: its reads
+	// go to the primary for a while after it wrote, other clients keep reading from replicas
+	WithContext(ctx context.Context) DatabaseRepo
+
 	// Implemented in postgres.go file
 	AllUsers() bool
 
-	InsertReservation(res models.Reservation) (int, error)
-	InserRoomRestriction(res models.RoomRestriction) error
+	// mutations are recorded in the audit log together with the actor making them
+	InsertReservation(actor models.Actor, res models.Reservation) (int, error)
+	InserRoomRestriction(actor models.Actor, res models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(start_date, end_date time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start_date, end_date time.Time) ([]models.Room, error)
+	SearchAvailabilityForAllRooms(start_date, end_date time.Time, propertyID int) ([]models.Room, error)
 	GetRoomByID(roomID int) (models.Room, error)
+	AllRooms(propertyID int) ([]models.Room, error)
+
+	// properties
+	AllProperties() ([]models.Property, error)
+	GetPropertyByID(id int) (models.Property, error)
+	GetPropertyBySlug(slug string) (models.Property, error)
+	GetPropertyByHostname(hostname string) (models.Property, error)
+
+	// staff
+	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	Authenticate(email, testPassword string) (int, string, error)
+	PropertiesForUser(userID int) ([]models.Property, error)
+	UserHasProperty(userID, propertyID int) (bool, error)
+	AllReservations(propertyID int) ([]models.Reservation, error)
+	SearchReservations(filter models.ReservationFilter) (models.ReservationPage, error)
+
+	// exports stream every matching row to fn, which stops the export by returning an error
+	ExportReservations(filter models.ReservationFilter, fn func(models.Reservation) error) error
+	ExportRoomRestrictions(filter models.RoomRestrictionFilter, fn func(models.RoomRestriction) error) error
+
+	// bulk import, the rows which conflict with bookings are returned and skipped. Without commit
+	// it is a dry run
+	ImportBookings(actor models.Actor, propertyID int, rows []models.ImportRow, commit bool) ([]models.ImportError, error)
+
+	// reports
+	RoomNights(propertyID int, from, to time.Time) ([]models.RoomNight, error)
+
+	// password resets
+	InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error
+	UserForPasswordReset(tokenHash string) (models.User, error)
+	ResetPassword(actor models.Actor, tokenHash, password string) (int, error)
+
+	// two factor authentication of staff users
+	EnableTwoFactor(actor models.Actor, userID int, secret string, step int64, recoveryCodeHashes []string) error
+	DisableTwoFactor(actor models.Actor, userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(actor models.Actor, userID int, codeHash string) (bool, error)
+	TwoFactorPolicy() ([]int, error)
+	SetTwoFactorPolicy(actor models.Actor, accessLevels []int) error
+
+	// guest accounts
+	InsertGuest(guest models.Guest, password string) (int, error)
+	AuthenticateGuest(email, password string) (int, error)
+	GetGuestByID(id int) (models.Guest, error)
+	GuestReservations(guestID int) ([]models.Reservation, error)
+
+	// audit log
+	AuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error)
 }
@@ -0,0 +1,77 @@
+// Package requestctx holds the values which middleware attaches to the request context so that
+// handlers, render and helpers can read them without importing each other.
+package requestctx
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+// contextKey is unexported to avoid collisions with keys defined in other packages
+type contextKey string
+
+const (
+	propertyKey  contextKey = "property"
+	loggerKey    contextKey = "logger"
+	requestIDKey contextKey = "request_id"
+	cspNonceKey  contextKey = "csp_nonce"
+	userKey      contextKey = "user"
+)
+
+// WithProperty returns a copy of ctx carrying the property the request is served for
+func WithProperty(ctx context.Context, p models.Property) context.Context {
+	return context.WithValue(ctx, propertyKey, p)
+}
+
+// Property returns the property stored in ctx. ok is false if no property was resolved
+func Property(ctx context.Context) (models.Property, bool) {
+	p, ok := ctx.Value(propertyKey).(models.Property)
+	return p, ok
+}
+
+// WithLogger returns a copy of ctx carrying a logger which adds the request attributes
+// (request id, method, path, session, user id) to every record
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// Logger returns the request scoped logger stored in ctx, or nil
+func Logger(ctx context.Context) *slog.Logger {
+	l, _ := ctx.Value(loggerKey).(*slog.Logger)
+	return l
+}
+
+// WithRequestID returns a copy of ctx carrying the id of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the id of the request stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithCSPNonce returns a copy of ctx carrying the Content Security Policy nonce of the response
+func WithCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, cspNonceKey, nonce)
+}
+
+// CSPNonce returns the Content Security Policy nonce stored in ctx, or an empty string
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
+
+// WithUser returns a copy of ctx carrying the logged in staff user
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+// User returns the logged in staff user stored in ctx. ok is false outside the admin area
+func User(ctx context.Context) (models.User, bool) {
+	u, ok := ctx.Value(userKey).(models.User)
+	return u, ok
+}
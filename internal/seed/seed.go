@@ -0,0 +1,403 @@
+// Package seed generates demo data: rooms, staff users with every access level and a year of
+// reservations and owner blocks. The data only depends on the options, so the same seed value
+// always gives the same calendar
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+	"github.com/prayagsingh/bookings/internal/repository/dbrepo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Options controls the generated data
+type Options struct {
+	// Seed of the random generator
+	Seed int64
+	// PropertyID is the property the rooms and reservations are created for
+	PropertyID int
+	// Rooms is the number of rooms to create
+	Rooms int
+	// Start is the first day of the calendar, Days its length
+	Start time.Time
+	Days  int
+	// Password of every generated user
+	Password string
+}
+
+// DefaultOptions returns a year of data for the default property, starting six months ago so that
+// reports have both past and upcoming stays
+func DefaultOptions() Options {
+
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -6, 0)
+
+	return Options{
+		Seed:       1,
+		PropertyID: 1,
+		Rooms:      6,
+		Start:      start,
+		Days:       365,
+		Password:   "password",
+	}
+}
+
+// Data is the generated demo data. Reservations and Blocks reference Rooms by index
+type Data struct {
+	Rooms        []models.Room
+	Users        []models.User
+	Reservations []models.Reservation
+	// Blocks are owner blocks, they have no reservation
+	Blocks []models.RoomRestriction
+}
+
+var roomNames = []string{
+	"Garden Villa", "Ocean Suite", "Fort View Room", "Maharaja Suite", "Courtyard Room",
+	"Desert Villa", "Terrace Suite", "Lake View Room", "Royal Villa", "Heritage Room",
+}
+
+var firstNames = []string{
+	"Aarav", "Priya", "John", "Emma", "Liam", "Sofia", "Noah", "Mia", "Arjun", "Ananya",
+	"Lucas", "Chloe", "Ravi", "Isla", "Omar", "Hana", "Mateo", "Zara", "Kenji", "Lena",
+}
+
+var lastNames = []string{
+	"Sharma", "Smith", "Garcia", "Patel", "Müller", "Rossi", "Tanaka", "Khan", "Dubois", "Singh",
+	"Brown", "Silva", "Kowalski", "Nguyen", "Jensen", "Haddad", "Iyer", "Lopez", "Cohen", "Ali",
+}
+
+// staff are the generated users, one per access level
+var staff = []struct {
+	firstName, lastName, email string
+	accessLevel                int
+}{
+	{"Ada", "Admin", "admin@here.com", models.AccessLevelAdmin},
+	{"Olive", "Owner", "owner@here.com", models.AccessLevelOwner},
+	{"Manny", "Manager", "manager@here.com", models.AccessLevelManager},
+	{"Sam", "Staff", "staff@here.com", models.AccessLevelFrontDesk},
+}
+
+// Generate creates the demo data. Each room gets back to back stays of one to seven nights with
+// gaps of up to six nights in between; about one in eight of them is an owner block. Stays never
+// overlap on a room
+func Generate(opts Options) Data {
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	var data Data
+
+	for i := 0; i < opts.Rooms; i++ {
+		name := roomNames[i%len(roomNames)]
+		if i >= len(roomNames) {
+			name = fmt.Sprintf("%s %d", name, i/len(roomNames)+1)
+		}
+		// rates from 90.00 to 210.00, not drawn from rnd so that a seed keeps giving the same calendar
+		rate := 9000 + i%5*3000
+		// the rooms exist from the first day, reports leave out the days before a room was created
+		data.Rooms = append(data.Rooms, models.Room{RoomName: name, PropertyID: opts.PropertyID, NightlyRate: rate,
+			CreatedAt: opts.Start})
+	}
+
+	for _, s := range staff {
+		data.Users = append(data.Users, models.User{
+			FirstName:   s.firstName,
+			LastName:    s.lastName,
+			Email:       s.email,
+			Password:    opts.Password,
+			AccessLevel: s.accessLevel,
+		})
+	}
+
+	end := opts.Start.AddDate(0, 0, opts.Days)
+	for roomIndex := range data.Rooms {
+		day := opts.Start.AddDate(0, 0, rnd.Intn(4))
+		for {
+			nights := 1 + rnd.Intn(7)
+			checkout := day.AddDate(0, 0, nights)
+			if checkout.After(end) {
+				break
+			}
+
+			if rnd.Intn(8) == 0 {
+				data.Blocks = append(data.Blocks, models.RoomRestriction{
+					RoomID:        roomIndex,
+					RestrictionID: models.RestrictionOwnerBlock,
+					StartDate:     day,
+					EndDate:       checkout,
+				})
+			} else {
+				res := guest(rnd, roomIndex, day, checkout)
+				res.Amount = nights * data.Rooms[roomIndex].NightlyRate
+				data.Reservations = append(data.Reservations, res)
+			}
+
+			day = checkout.AddDate(0, 0, rnd.Intn(7))
+		}
+	}
+
+	return data
+}
+
+// guest returns a reservation by a random guest, booked up to 90 days before arrival
+func guest(rnd *rand.Rand, roomIndex int, start, end time.Time) models.Reservation {
+
+	first := firstNames[rnd.Intn(len(firstNames))]
+	last := lastNames[rnd.Intn(len(lastNames))]
+	booked := start.AddDate(0, 0, -rnd.Intn(90)).Add(time.Duration(8+rnd.Intn(12)) * time.Hour)
+
+	return models.Reservation{
+		FirstName: first,
+		LastName:  last,
+		Email:     fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), rnd.Intn(100)),
+		Phone:     fmt.Sprintf("+91 %05d %05d", rnd.Intn(100000), rnd.Intn(100000)),
+		RoomID:    roomIndex,
+		StartDate: start,
+		EndDate:   end,
+		CreatedAt: booked,
+		UpdatedAt: booked,
+	}
+}
+
+// ErrNotEmpty is returned by Apply if there are reservations already and reset isn't set
+var ErrNotEmpty = errors.New("the database already has reservations, use reset to replace them")
+
+// Apply writes data to the database in one transaction. With reset the rooms of the property are
+// deleted first, along with their reservations and restrictions. Existing users are updated. Every
+// change has an audit event by the system actor "seed"
+func Apply(ctx context.Context, db *sql.DB, propertyID int, data Data, reset bool) error {
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	audit := dbrepo.NewTxAudit(tx, models.SystemActor("seed"), propertyID)
+
+	if reset {
+		err = auditReset(ctx, tx, audit, propertyID)
+		if err != nil {
+			return err
+		}
+
+		// reservations and restrictions are deleted along with the rooms by the foreign keys
+		_, err = tx.ExecContext(ctx, `delete from rooms where property_id = $1`, propertyID)
+		if err != nil {
+			return err
+		}
+	} else {
+		var count int
+		err = tx.QueryRowContext(ctx, `select count(*) from reservations`).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrNotEmpty
+		}
+	}
+
+	now := time.Now()
+
+	roomIDs := make([]int, len(data.Rooms))
+	for i, room := range data.Rooms {
+		err = tx.QueryRowContext(ctx, `insert into rooms (room_name, property_id, nightly_rate, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`, room.RoomName, room.PropertyID, room.NightlyRate, room.CreatedAt, now).Scan(&roomIDs[i])
+		if err != nil {
+			return fmt.Errorf("can't insert room: %w", err)
+		}
+
+		room.ID = roomIDs[i]
+		err = audit.Room(ctx, models.AuditCreate, room)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, u := range data.Users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+
+		// users which exist already get the generated name, password and access level. xmax is
+		// zero for a row which has just been inserted
+		var inserted bool
+		err = tx.QueryRowContext(ctx, `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)
+			on conflict (lower(email)) do update set first_name = excluded.first_name, last_name = excluded.last_name,
+				password = excluded.password, access_level = excluded.access_level, updated_at = excluded.updated_at
+			returning id, xmax = 0`,
+			u.FirstName, u.LastName, u.Email, string(hash), u.AccessLevel, now, now).Scan(&u.ID, &inserted)
+		if err != nil {
+			return fmt.Errorf("can't insert user %s: %w", u.Email, err)
+		}
+
+		action := models.AuditUpdate
+		if inserted {
+			action = models.AuditCreate
+		}
+		err = audit.User(ctx, action, u)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into property_users (property_id, user_id, created_at, updated_at)
+			values ($1, $2, $3, $4) on conflict (property_id, user_id) do nothing`, propertyID, u.ID, now, now)
+		if err != nil {
+			return fmt.Errorf("can't grant property to %s: %w", u.Email, err)
+		}
+	}
+
+	for _, res := range data.Reservations {
+		var reservationID int
+		err = tx.QueryRowContext(ctx, `insert into reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, amount, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate,
+			roomIDs[res.RoomID], res.Amount, res.CreatedAt, res.UpdatedAt).Scan(&reservationID)
+		if err != nil {
+			return fmt.Errorf("can't insert reservation: %w", err)
+		}
+
+		res.ID = reservationID
+		res.RoomID = roomIDs[res.RoomID]
+		err = audit.Reservation(ctx, models.AuditCreate, res)
+		if err != nil {
+			return err
+		}
+
+		err = insertRestriction(ctx, tx, audit, models.RoomRestriction{
+			RoomID:        res.RoomID,
+			ReservationID: reservationID,
+			RestrictionID: models.RestrictionReservation,
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+		}, res.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range data.Blocks {
+		b.RoomID = roomIDs[b.RoomID]
+		err = insertRestriction(ctx, tx, audit, b, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertRestriction inserts a room restriction. ReservationID 0 is stored as null
+func insertRestriction(ctx context.Context, tx *sql.Tx, audit dbrepo.TxAudit, rr models.RoomRestriction, created time.Time) error {
+
+	var resID sql.NullInt64
+	if rr.ReservationID != 0 {
+		resID = sql.NullInt64{Int64: int64(rr.ReservationID), Valid: true}
+	}
+
+	err := tx.QueryRowContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+		restriction_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`,
+		rr.StartDate, rr.EndDate, rr.RoomID, resID, rr.RestrictionID, created, created).Scan(&rr.ID)
+	if err != nil {
+		return fmt.Errorf("can't insert room restriction: %w", err)
+	}
+
+	return audit.RoomRestriction(ctx, models.AuditCreate, rr)
+}
+
+// auditReset records the deletion of the rooms of the property, their reservations and their
+// restrictions, before they are deleted
+func auditReset(ctx context.Context, tx *sql.Tx, audit dbrepo.TxAudit, propertyID int) error {
+
+	rows, err := tx.QueryContext(ctx, `select id, room_name, property_id, nightly_rate from rooms
+		where property_id = $1 order by id`, propertyID)
+	if err != nil {
+		return err
+	}
+	var rooms []models.Room
+	for rows.Next() {
+		var room models.Room
+		err = rows.Scan(&room.ID, &room.RoomName, &room.PropertyID, &room.NightlyRate)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rooms = append(rooms, room)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, coalesce(r.guest_id, 0), coalesce(r.amount, 0)
+		from reservations r join rooms rm on rm.id = r.room_id
+		where rm.property_id = $1 order by r.id`, propertyID)
+	if err != nil {
+		return err
+	}
+	var reservations []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		err = rows.Scan(&res.ID, &res.FirstName, &res.LastName, &res.Email, &res.Phone, &res.StartDate,
+			&res.EndDate, &res.RoomID, &res.GuestID, &res.Amount)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		reservations = append(reservations, res)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `select rr.id, rr.start_date, rr.end_date, rr.room_id,
+			coalesce(rr.reservation_id, 0), rr.restriction_id
+		from room_restrictions rr join rooms rm on rm.id = rr.room_id
+		where rm.property_id = $1 order by rr.id`, propertyID)
+	if err != nil {
+		return err
+	}
+	var restrictions []models.RoomRestriction
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err = rows.Scan(&rr.ID, &rr.StartDate, &rr.EndDate, &rr.RoomID, &rr.ReservationID, &rr.RestrictionID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		restrictions = append(restrictions, rr)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rr := range restrictions {
+		if err = audit.RoomRestriction(ctx, models.AuditDelete, rr); err != nil {
+			return err
+		}
+	}
+	for _, res := range reservations {
+		if err = audit.Reservation(ctx, models.AuditDelete, res); err != nil {
+			return err
+		}
+	}
+	for _, room := range rooms {
+		if err = audit.Room(ctx, models.AuditDelete, room); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package seed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/models"
+)
+
+func testOptions(seed int64) Options {
+
+	opts := DefaultOptions()
+	opts.Seed = seed
+	opts.Start = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	return opts
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+
+	a := Generate(testOptions(42))
+	b := Generate(testOptions(42))
+	if !reflect.DeepEqual(a, b) {
+		t.Error("expected the same data for the same seed")
+	}
+
+	c := Generate(testOptions(43))
+	if reflect.DeepEqual(a.Reservations, c.Reservations) {
+		t.Error("expected different reservations for a different seed")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+
+	opts := testOptions(1)
+	data := Generate(opts)
+
+	if len(data.Rooms) != opts.Rooms {
+		t.Errorf("expected %d rooms but got %d", opts.Rooms, len(data.Rooms))
+	}
+
+	// one user per access level
+	levels := map[int]bool{}
+	for _, u := range data.Users {
+		levels[u.AccessLevel] = true
+	}
+	if len(levels) != 4 {
+		t.Errorf("expected users with 4 access levels but got %v", levels)
+	}
+
+	if len(data.Reservations) == 0 || len(data.Blocks) == 0 {
+		t.Fatal("expected reservations and owner blocks")
+	}
+
+	// reservations are priced at the rate of their room, which the reports need for the revenue
+	for _, r := range data.Reservations {
+		nights := int(r.EndDate.Sub(r.StartDate).Hours() / 24)
+		if rate := data.Rooms[r.RoomID].NightlyRate; rate <= 0 || r.Amount != nights*rate {
+			t.Errorf("expected %d nights at %d but got an amount of %d", nights, rate, r.Amount)
+		}
+	}
+
+	// stays of a room never overlap and stay within the calendar
+	type stay struct{ start, end time.Time }
+	byRoom := map[int][]stay{}
+	for _, r := range data.Reservations {
+		byRoom[r.RoomID] = append(byRoom[r.RoomID], stay{r.StartDate, r.EndDate})
+		if r.CreatedAt.After(r.StartDate.Add(24 * time.Hour)) {
+			t.Errorf("reservation booked after arrival: %v", r)
+		}
+	}
+	for _, b := range data.Blocks {
+		if b.RestrictionID != models.RestrictionOwnerBlock {
+			t.Errorf("expected an owner block but got restriction %d", b.RestrictionID)
+		}
+		byRoom[b.RoomID] = append(byRoom[b.RoomID], stay{b.StartDate, b.EndDate})
+	}
+
+	end := opts.Start.AddDate(0, 0, opts.Days)
+	for room, stays := range byRoom {
+		for i, a := range stays {
+			if !a.end.After(a.start) || a.start.Before(opts.Start) || a.end.After(end) {
+				t.Errorf("room %d: invalid stay %s - %s", room, a.start, a.end)
+			}
+			for _, b := range stays[i+1:] {
+				if a.start.Before(b.end) && b.start.Before(a.end) {
+					t.Errorf("room %d: %s - %s overlaps %s - %s", room, a.start, a.end, b.start, b.end)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package sessionstore keeps the sessions in postgres so that in-progress bookings and logins
+// survive restarts and are shared between instances
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// PostgresStore implements scs.Store on the sessions table
+type PostgresStore struct {
+	db *sql.DB
+
+	// Logger, if set, logs failed cleanups
+	Logger *slog.Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var _ scs.Store = (*PostgresStore)(nil)
+
+// timeout of every query, sessions are loaded on every request
+const timeout = 3 * time.Second
+
+// NewPostgresStore returns a store on db. Expired sessions are deleted every cleanupInterval,
+// zero disables the cleanup
+func NewPostgresStore(db *sql.DB, cleanupInterval time.Duration) *PostgresStore {
+
+	s := &PostgresStore{db: db, stop: make(chan struct{})}
+	if cleanupInterval > 0 {
+		go s.startCleanup(cleanupInterval)
+	}
+
+	return s
+}
+
+// Find returns the data of an unexpired session. found is false if there is none
+func (s *PostgresStore) Find(token string) ([]byte, bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var b []byte
+	err := s.db.QueryRowContext(ctx, `select data from sessions where token = $1 and expiry > now()`, token).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// Commit adds the session or replaces its data and expiry
+func (s *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`, token, b, expiry)
+
+	return err
+}
+
+// Delete removes the session. Deleting a session which doesn't exist is not an error
+func (s *PostgresStore) Delete(token string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `delete from sessions where token = $1`, token)
+
+	return err
+}
+
+// DeleteExpired removes the expired sessions and returns how many there were
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+
+	result, err := s.db.ExecContext(ctx, `delete from sessions where expiry < now()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// StopCleanup stops the background cleanup
+func (s *PostgresStore) StopCleanup() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *PostgresStore) startCleanup(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, err := s.DeleteExpired(ctx)
+			cancel()
+			if err != nil && s.Logger != nil {
+				s.Logger.Error("can't delete expired sessions", "error", err.Error())
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prayagsingh/bookings/internal/driver"
+)
+
+// the store needs the sessions table, set TEST_DATABASE_URL to a migrated database to run this
+func TestPostgresStore(t *testing.T) {
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := driver.ConnectSQL(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := NewPostgresStore(db.SQL, 0)
+
+	// case 1: unknown token
+	_, found, err := s.Find("missing")
+	if err != nil || found {
+		t.Errorf("expected no session and no error but got found=%v err=%v", found, err)
+	}
+
+	// case 2: commit, overwrite and find
+	err = s.Commit("token", []byte("first"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Commit("token", []byte("second"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, found, err := s.Find("token")
+	if err != nil || !found || !bytes.Equal(b, []byte("second")) {
+		t.Errorf("expected the second commit but got %q found=%v err=%v", b, found, err)
+	}
+
+	// case 3: expired sessions are not found and are cleaned up
+	err = s.Commit("expired", []byte("old"), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, _ = s.Find("expired")
+	if found {
+		t.Error("expected an expired session not to be found")
+	}
+	n, err := s.DeleteExpired(context.Background())
+	if err != nil || n < 1 {
+		t.Errorf("expected the expired session to be deleted but got n=%d err=%v", n, err)
+	}
+
+	// case 4: delete
+	err = s.Delete("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, _ = s.Find("token")
+	if found {
+		t.Error("expected the session to be deleted")
+	}
+}
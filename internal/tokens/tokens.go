@@ -0,0 +1,43 @@
+// Package tokens creates the random tokens sent to users, e.g. in password reset links. Only the
+// hash of a token is stored, so that a leaked database doesn't give away working links. Tokens put
+// into links are signed with the secret key of the application, so that forged links are rejected
+// without a database lookup
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// New returns a random 32 byte token, base64url encoded
+func New() (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash returns the hex encoded sha256 of token, which is stored instead of the token
+func Hash(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the base64url encoded HMAC-SHA256 of value with key
+func Sign(key []byte, value string) string {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sig is the signature of value with key, in constant time
+func Verify(key []byte, value, sig string) bool {
+	return hmac.Equal([]byte(Sign(key, value)), []byte(sig))
+}
@@ -0,0 +1,38 @@
+package tokens
+
+import "testing"
+
+func TestNew(t *testing.T) {
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := New()
+
+	if a == b {
+		t.Error("expected different tokens")
+	}
+	if len(a) != 43 {
+		t.Errorf("expected 43 characters but got %d", len(a))
+	}
+	if len(Hash(a)) != 64 || Hash(a) == Hash(b) {
+		t.Error("expected distinct sha256 hashes")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sig := Sign(key, "token")
+
+	if !Verify(key, "token", sig) {
+		t.Error("expected the signature to verify")
+	}
+	if Verify(key, "other", sig) {
+		t.Error("expected the signature of another value to fail")
+	}
+	if Verify([]byte("another key"), "token", sig) {
+		t.Error("expected the signature with another key to fail")
+	}
+}
@@ -0,0 +1,151 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 used as second factor of
+// staff logins, as generated by authenticator apps: HMAC-SHA1, 6 digits, a new code every 30
+// seconds. It also creates the recovery codes which replace the app when it is lost
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the number of seconds a code is valid for
+	Period = 30
+	// Digits is the length of a code
+	Digits = 6
+	// Skew is the number of periods a code may be early or late, for clocks which are off
+	Skew = 1
+	// secretSize is the size of a secret in bytes, the size of an HMAC-SHA1 key
+	secretSize = 20
+)
+
+// encoding is how secrets are written, authenticator apps expect base32 without padding
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded
+func GenerateSecret() (string, error) {
+
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the number of the period t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code of secret for the period step
+func Code(secret string, step int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Validate checks code against the periods around t and returns the period it belongs to. The
+// caller must refuse periods which have been used already, a code is good for one login only
+func Validate(secret, code string, t time.Time) (int64, bool) {
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth:// provisioning URI of secret which authenticator apps read from a QR
+// code. The issuer and account name are shown in the app
+func URI(issuer, account, secret string) string {
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// recoveryAlphabet leaves out characters which are easily mixed up, like 0 and o
+const recoveryAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// RecoveryCodes returns n random recovery codes like "k7mq-x2pd-9rtw". Each of them replaces a
+// code of the app once
+func RecoveryCodes(n int) ([]string, error) {
+
+	codes := make([]string, n)
+	b := make([]byte, 12)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		for j, c := range b {
+			if j > 0 && j%4 == 0 {
+				sb.WriteByte('-')
+			}
+			// the modulo bias is negligible for an alphabet of 31 characters
+			sb.WriteByte(recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+		}
+		codes[i] = sb.String()
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode returns code as generated by RecoveryCodes, whatever the case, spacing and
+// dashes typed by the user
+func NormalizeRecoveryCode(code string) string {
+
+	var sb strings.Builder
+	for _, c := range strings.ToLower(code) {
+		if c == '-' || c == ' ' {
+			continue
+		}
+		if sb.Len() > 0 && (sb.Len()+1)%5 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the test vectors of RFC 6238 appendix B, "12345678901234567890"
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// the RFC lists 8 digit codes, these are their last 6 digits
+var codeTests = []struct {
+	unix     int64
+	expected string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+
+	for _, e := range codeTests {
+		got, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, got)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected an invalid secret")
+	}
+}
+
+var validateTests = []struct {
+	name     string
+	code     string
+	at       int64
+	expected bool
+}{
+	{"current", "081804", 1111111109, true},
+	{"spaces", " 081 804 ", 1111111109, true},
+	{"one-period-late", "081804", 1111111109 + Period, true},
+	{"one-period-early", "081804", 1111111109 - Period, true},
+	{"two-periods-late", "081804", 1111111109 + 2*Period, false},
+	{"wrong", "123456", 1111111109, false},
+	{"too-short", "81804", 1111111109, false},
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, e := range validateTests {
+		step, ok := Validate(rfcSecret, e.code, time.Unix(e.at, 0))
+		if ok != e.expected {
+			t.Errorf("failed %s: expected %v but got %v", e.name, e.expected, ok)
+		}
+		// the step is the period of the code, not of the time of the check
+		if ok && step != Step(time.Unix(1111111109, 0)) {
+			t.Errorf("failed %s: expected step %d but got %d", e.name, Step(time.Unix(1111111109, 0)), step)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("expected 32 base32 characters but got %q", secret)
+	}
+
+	code, err := Code(secret, Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, time.Now()); !ok {
+		t.Error("the code of a new secret doesn't validate")
+	}
+}
+
+func TestURI(t *testing.T) {
+
+	got := URI("Aisa Fort", "admin@here.com", "JBSWY3DPEHPK3PXP")
+	expected := "otpauth://totp/Aisa%20Fort:admin@here.com?algorithm=SHA1&digits=6&issuer=Aisa+Fort&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != expected {
+		t.Errorf("expected %s but got %s", expected, got)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 14 || strings.Count(c, "-") != 2 {
+			t.Errorf("malformed recovery code %q", c)
+		}
+		if seen[c] {
+			t.Errorf("duplicate recovery code %q", c)
+		}
+		seen[c] = true
+
+		typed := strings.ToUpper(strings.ReplaceAll(c, "-", " "))
+		if got := NormalizeRecoveryCode(typed); got != c {
+			t.Errorf("%q normalized to %q instead of %q", typed, got, c)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+alter table reservations
+    add constraint reservations_rooms_id_fk foreign key (room_id)
+    references rooms (id) on delete cascade on update cascade;
@@ -0,0 +1,7 @@
+alter table room_restrictions
+    add constraint room_restrictions_rooms_id_fk foreign key (room_id)
+    references rooms (id) on delete cascade on update cascade;
+
+alter table room_restrictions
+    add constraint room_restrictions_restrictions_id_fk foreign key (restriction_id)
+    references restrictions (id) on delete cascade on update cascade;
@@ -0,0 +1,3 @@
+drop index if exists room_restrictions_reservation_id_idx;
+drop index if exists room_restrictions_room_id_idx;
+drop index if exists room_restrictions_start_date_end_date_idx;
@@ -0,0 +1,3 @@
+alter table room_restrictions drop constraint if exists room_restrictions_reservations_id_fk;
+drop index if exists reservations_email_idx;
+drop index if exists reservations_last_name_idx;
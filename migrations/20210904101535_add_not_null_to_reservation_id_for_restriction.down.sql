@@ -0,0 +1 @@
+-- not reverted: owner blocks without a reservation would violate the constraint
@@ -0,0 +1,2 @@
+-- owner blocks have no reservation
+alter table room_restrictions alter column reservation_id drop not null;
@@ -0,0 +1,15 @@
+create table properties (
+    id serial primary key,
+    name varchar(255) not null default '',
+    slug varchar(255) not null,
+    hostname varchar(255),
+    address varchar(255) not null default '',
+    timezone varchar(255) not null default 'UTC',
+    currency varchar(3) not null default 'USD',
+    contact_email varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index properties_slug_idx on properties (slug);
+create unique index properties_hostname_idx on properties (hostname);
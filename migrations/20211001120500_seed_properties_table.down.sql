@@ -0,0 +1 @@
+delete from properties;
\ No newline at end of file
@@ -0,0 +1,2 @@
+INSERT INTO public.properties (name,slug,hostname,address,timezone,currency,contact_email,created_at,updated_at) VALUES
+	 ('Aisa Fort','aisa-fort',NULL,'','UTC','USD','','2021-10-01 00:00:00','2021-10-01 00:00:00');
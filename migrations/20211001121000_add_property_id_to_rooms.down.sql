@@ -0,0 +1,3 @@
+alter table rooms drop constraint if exists rooms_properties_id_fk;
+drop index if exists rooms_property_id_idx;
+alter table rooms drop column if exists property_id;
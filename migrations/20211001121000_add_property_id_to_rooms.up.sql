@@ -0,0 +1,7 @@
+alter table rooms add column property_id integer not null default 1;
+
+alter table rooms
+    add constraint rooms_properties_id_fk foreign key (property_id)
+    references properties (id) on delete cascade on update cascade;
+
+create index rooms_property_id_idx on rooms (property_id);
@@ -0,0 +1,17 @@
+create table property_users (
+    id serial primary key,
+    property_id integer not null,
+    user_id integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+alter table property_users
+    add constraint property_users_properties_id_fk foreign key (property_id)
+    references properties (id) on delete cascade on update cascade;
+
+alter table property_users
+    add constraint property_users_users_id_fk foreign key (user_id)
+    references users (id) on delete cascade on update cascade;
+
+create unique index property_users_property_id_user_id_idx on property_users (property_id, user_id);
@@ -0,0 +1,20 @@
+create table audit_events (
+    id bigserial primary key,
+    actor_type varchar(20) not null,
+    actor_user_id integer,
+    actor_label varchar(255) not null default '',
+    action varchar(50) not null,
+    entity_type varchar(50) not null,
+    entity_id integer not null,
+    property_id integer,
+    before jsonb,
+    after jsonb,
+    ip varchar(64) not null default '',
+    created_at timestamptz not null default now()
+);
+
+-- no foreign keys: the history outlives the users, rooms and reservations it is about
+
+create index audit_events_entity_idx on audit_events (entity_type, entity_id, created_at);
+create index audit_events_property_id_created_at_idx on audit_events (property_id, created_at);
+create index audit_events_actor_user_id_idx on audit_events (actor_user_id);
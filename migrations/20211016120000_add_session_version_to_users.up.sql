@@ -0,0 +1,3 @@
+-- sessions store the version of the user they were created with. Incrementing it, e.g. after a
+-- password reset, logs the user out everywhere
+alter table users add column session_version integer not null default 1;
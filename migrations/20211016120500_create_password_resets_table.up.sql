@@ -0,0 +1,16 @@
+create table password_resets (
+    id serial primary key,
+    user_id integer not null,
+    -- sha256 of the token sent by email, the token itself is never stored
+    token_hash varchar(64) not null,
+    expires_at timestamptz not null,
+    used_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+alter table password_resets
+    add constraint password_resets_users_id_fk foreign key (user_id)
+    references users (id) on delete cascade on update cascade;
+
+create unique index password_resets_token_hash_idx on password_resets (token_hash);
+create index password_resets_user_id_idx on password_resets (user_id);
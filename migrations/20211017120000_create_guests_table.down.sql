@@ -0,0 +1,2 @@
+alter table reservations drop column if exists guest_id;
+drop table if exists guests;
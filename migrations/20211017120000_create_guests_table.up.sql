@@ -0,0 +1,24 @@
+-- guests book on the public site. They are kept apart from the staff in users, a guest account
+-- gives no access to the admin area
+create table guests (
+    id serial primary key,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    phone varchar(255) not null default '',
+    password varchar(60) not null,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+);
+
+create unique index guests_email_idx on guests (lower(email));
+
+-- reservations made while logged in belong to the guest. The name, email and phone of the
+-- reservation are still stored with it, as entered for that stay
+alter table reservations add column guest_id integer;
+
+alter table reservations
+    add constraint reservations_guests_id_fk foreign key (guest_id)
+    references guests (id) on delete set null on update cascade;
+
+create index reservations_guest_id_idx on reservations (guest_id);
@@ -0,0 +1,5 @@
+drop table if exists two_factor_policy;
+drop table if exists user_recovery_codes;
+alter table users drop column if exists totp_last_step;
+alter table users drop column if exists totp_enabled_at;
+alter table users drop column if exists totp_secret;
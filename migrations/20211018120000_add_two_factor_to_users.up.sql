@@ -0,0 +1,30 @@
+-- TOTP second factor of staff logins. The secret is set while enrolling, the second factor is
+-- only asked for once totp_enabled_at is set. totp_last_step is the period of the last code used,
+-- a code is refused if its period isn't later, so that it can't be replayed
+alter table users add column totp_secret varchar(64) not null default '';
+alter table users add column totp_enabled_at timestamptz;
+alter table users add column totp_last_step bigint not null default 0;
+
+-- recovery codes replace the authenticator app once each. Only their sha256 is stored
+create table user_recovery_codes (
+    id serial primary key,
+    user_id integer not null,
+    code_hash varchar(64) not null,
+    used_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+alter table user_recovery_codes
+    add constraint user_recovery_codes_users_id_fk foreign key (user_id)
+    references users (id) on delete cascade on update cascade;
+
+create unique index user_recovery_codes_user_id_code_hash_idx on user_recovery_codes (user_id, code_hash);
+
+-- the access levels whose users must log in with a second factor, set by admins. Owners and
+-- admins are required to from the start
+create table two_factor_policy (
+    access_level integer primary key,
+    created_at timestamptz not null default now()
+);
+
+insert into two_factor_policy (access_level) values (3), (4);
@@ -0,0 +1,3 @@
+drop index if exists reservations_created_at_idx;
+drop index if exists reservations_start_date_id_idx;
+drop index if exists reservations_name_search_idx;
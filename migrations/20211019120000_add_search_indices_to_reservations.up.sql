@@ -0,0 +1,7 @@
+-- full-text search on guest names, the expression must match the one of the search query
+create index reservations_name_search_idx on reservations
+    using gin (to_tsvector('simple', first_name || ' ' || last_name));
+
+-- keyset pagination of the default sort and the created date filter
+create index reservations_start_date_id_idx on reservations (start_date, id);
+create index reservations_created_at_idx on reservations (created_at);
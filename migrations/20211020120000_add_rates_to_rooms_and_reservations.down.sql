@@ -0,0 +1,2 @@
+alter table reservations drop column if exists amount;
+alter table rooms drop column if exists nightly_rate;
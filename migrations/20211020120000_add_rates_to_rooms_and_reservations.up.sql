@@ -0,0 +1,6 @@
+-- amounts are in minor units of the currency of the property, e.g. cents
+alter table rooms add column nightly_rate integer not null default 0;
+
+-- the amount charged for the whole stay, fixed when booking. Reservations made before have none,
+-- reports value them at the rate of their room
+alter table reservations add column amount integer;
@@ -0,0 +1,3 @@
+-- staff log in and are looked up by their email whatever its case, like guests
+drop index if exists users_email_idx;
+create unique index users_email_idx on users (lower(email));
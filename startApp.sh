@@ -1,4 +1,4 @@
 #!/bin/bash
 
 # run booking iff go build is successful
-go build -o bookings cmd/web/*.go && ./bookings
\ No newline at end of file
+go build -o bookings cmd/web/*.go && ./bookings -assets . -migrate
\ No newline at end of file